@@ -2,6 +2,7 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,9 +10,19 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/ineverbee/wbl0/internal/invalidation"
+	"github.com/ineverbee/wbl0/internal/reconcile"
+	"github.com/ineverbee/wbl0/internal/retention"
+	"github.com/ineverbee/wbl0/internal/retry"
 	"github.com/ineverbee/wbl0/internal/store"
 	"github.com/ineverbee/wbl0/internal/store/db"
+	"github.com/ineverbee/wbl0/internal/store/lru"
 	"github.com/ineverbee/wbl0/internal/store/mapstore"
+	"github.com/ineverbee/wbl0/internal/store/readthrough"
+	"github.com/ineverbee/wbl0/internal/store/redisstore"
+	"github.com/ineverbee/wbl0/internal/store/sharded"
+	"github.com/ineverbee/wbl0/internal/store/sqlite"
+	"github.com/ineverbee/wbl0/internal/wal"
 	"github.com/ineverbee/wbl0/internal/worker"
 	"github.com/nats-io/stan.go"
 )
@@ -24,44 +35,117 @@ type App struct {
 
 var app *App
 
+// reconciler repairs drift between app.cache and app.db.
+var reconciler *reconcile.Reconciler
+
+// dbBreaker pauses the worker while the db is down.
+var dbBreaker *retry.Breaker
+
 func StartApp() error {
 	var err error
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	router := mux.NewRouter()
+	if adminToken = os.Getenv("ADMIN_TOKEN"); adminToken == "" {
+		log.Println("Admin endpoints are disabled, set ADMIN_TOKEN to enable them")
+	}
 
 	router.Handle("/", limit(errorHandler(GetHomePageHandler()))).Methods("GET", "POST")
 	router.Handle("/data/{id}", limit(errorHandler(GetDataPageHandler()))).Methods("GET")
+	router.Handle("/data/{id}/history", limit(errorHandler(GetHistoryPageHandler()))).Methods("GET")
+	router.Handle("/data/uid/{order_uid}", limit(errorHandler(GetDataByUIDPageHandler()))).Methods("GET")
+	router.Handle("/orders", limit(errorHandler(GetOrdersPageHandler()))).Methods("GET")
+	router.Handle("/api/orders", limit(errorHandler(GetOrdersJSONHandler()))).Methods("GET")
+	router.Handle("/api/cache", limit(errorHandler(GetCacheStatsHandler()))).Methods("GET")
+	router.Handle("/admin/reconcile", limit(admin(errorHandler(GetReconcileHandler())))).Methods("GET", "POST")
+	router.Handle("/admin/quarantine", limit(admin(errorHandler(GetQuarantinePageHandler())))).Methods("GET")
+	router.Handle("/admin/quarantine/{id}", limit(admin(errorHandler(GetQuarantinedPageHandler())))).Methods("GET", "POST")
+	router.Handle("/health", errorHandler(GetHealthHandler())).Methods("GET")
+	router.Handle("/search", limit(errorHandler(GetSearchPageHandler()))).Methods("GET")
+	router.Handle("/api/search", limit(errorHandler(GetSearchJSONHandler()))).Methods("GET")
 
-	connStr := fmt.Sprintf("postgresql://%s:%s@%s:%s/%s?connect_timeout=5",
-		os.Getenv("DB_USERNAME"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_NAME"),
-	)
+	dbStore, err := openDB(ctx, os.Getenv("DB_DRIVER"))
+	if err != nil {
+		return err
+	}
 
-	dbStore, err := db.NewDBStore(ctx, connStr, 30*time.Second)
+	cache, err := newCache()
 	if err != nil {
 		return err
 	}
 
-	mapStore := mapstore.NewMapStore(make(map[int]*store.Model, 1))
+	negativeTTL, err := envDuration("CACHE_NEGATIVE_TTL", readthrough.DefaultNegativeTTL)
+	if err != nil {
+		return err
+	}
 
 	app = &App{
 		&http.Server{Addr: ":8080", Handler: router},
 		dbStore,
-		mapStore,
+		readthrough.NewReadThrough(cache, dbStore, negativeTTL),
 	}
 
-	mp, err := app.db.GetAll()
+	warmUpChunk, err := envInt("WARMUP_CHUNK", db.DefaultStreamChunk)
+	if err != nil {
+		return err
+	}
+	snapshotPath := os.Getenv("SNAPSHOT_PATH")
+	snapshotInterval, err := envDuration("SNAPSHOT_INTERVAL", 5*time.Minute)
+	if err != nil {
+		return err
+	}
+	ms, canSnapshot := cache.(*mapstore.MapStore)
+	if snapshotPath != "" && !canSnapshot {
+		log.Println("Snapshots are only supported by the unbounded unsharded cache")
+	}
+	changes, hasChanges := dbStore.(store.ChangesIface)
+	if snapshotPath != "" && canSnapshot && !hasChanges {
+		log.Println("Snapshots are only supported by dbs that can list changes")
+	}
+	canSnapshot = canSnapshot && hasChanges && snapshotPath != ""
+	after := 0
+	if canSnapshot {
+		after = restoreSnapshot(ctx, ms, changes, snapshotPath)
+	}
+	go func() {
+		// A snapshot taken during warm-up would miss orders below its
+		// high-water mark.
+		if err := warmUp(ctx, app.db, cache, after, warmUpChunk); err == nil && canSnapshot {
+			ms.RunSnapshots(ctx, snapshotPath, snapshotInterval, changes.Now)
+		}
+	}()
 
-	if err == nil {
-		app.cache = mapstore.NewMapStore(mp)
-	} else if err != db.Error404NotFound {
+	retentionCfg, ok, err := retentionConfig()
+	if err != nil {
+		return err
+	}
+	if archiver, canArchive := dbStore.(retention.Archiver); ok && canArchive {
+		go retention.Run(ctx, archiver, app.cache, retentionCfg)
+		log.Printf("Archiving orders older than %s every %s, dry run: %t\n",
+			retentionCfg.MaxAge, retentionCfg.Interval, retentionCfg.DryRun)
+	}
+
+	reconcileCfg, ok, err := reconcileConfig()
+	if err != nil {
 		return err
 	}
+	_, bounded := cache.(*lru.LRUStore)
+	reconcileCfg.OnlyCached = bounded
+	reconciler = reconcile.NewReconciler(app.db, cache, reconcileCfg)
+	if ok {
+		go reconciler.Run(ctx)
+		log.Printf("Reconciling the cache every %s\n", reconcileCfg.Interval)
+	}
+
+	listen, err := envBool("CACHE_LISTEN", true)
+	if err != nil {
+		return err
+	}
+	if listener, canListen := dbStore.(invalidation.Listener); listen && canListen {
+		go invalidation.Run(ctx, listener, app.db, app.cache, invalidation.DefaultRetry)
+		log.Printf("Listening for order changes on %s\n", db.NotifyChannel)
+	}
 
 	sc, err := stan.Connect(
 		os.Getenv("NATS_CLUSTER_ID"),
@@ -72,15 +156,306 @@ func StartApp() error {
 		return err
 	}
 
+	batchSize, err := envInt("BATCH_SIZE", 0)
+	if err != nil {
+		return err
+	}
+	batchInterval, err := envDuration("BATCH_INTERVAL", 100*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	ackWait, err := envDuration("NATS_ACK_WAIT", stan.DefaultAckWait)
+	if err != nil {
+		return err
+	}
+	workerOpts := []worker.Option{worker.WithAckWait(ackWait)}
+	if q, canQuarantine := dbStore.(store.QuarantineIface); canQuarantine {
+		workerOpts = append(workerOpts, worker.WithReject(worker.QuarantineTo(q)))
+	}
+	if deadLetter := os.Getenv("NATS_DEAD_LETTER_CHANNEL"); deadLetter != "" {
+		workerOpts = append(workerOpts, worker.WithReject(worker.DeadLetterTo(sc, deadLetter)))
+		log.Printf("Rejected messages go to [%s]\n", deadLetter)
+	}
+	if pg, isPostgres := dbStore.(*db.DBStore); batchSize > 0 && !isPostgres {
+		log.Println("Batching writes is only supported with Postgres, writing one by one")
+	} else if batchSize > 0 {
+		batch := db.NewBatchWriter(pg, batchSize, batchInterval)
+		go batch.Run(ctx)
+		workerOpts = append(workerOpts, worker.WithBatch(batch))
+		log.Printf("Batching writes: size=%d, interval=%s\n", batchSize, batchInterval)
+	}
+	backoff, failures, cooldown, err := retryConfig()
+	if err != nil {
+		return err
+	}
+	dbBreaker = retry.NewBreaker("db", failures, cooldown)
+	workerOpts = append(workerOpts, worker.WithRetry(backoff, dbBreaker, transient(dbStore)))
+	workerOpts = append(workerOpts, worker.WithRefused(refused(dbStore)))
+	walCfg, drainChunk, ok, err := walConfig()
+	if err != nil {
+		return err
+	}
+	if ok {
+		walLog, err := wal.Open(walCfg)
+		if err != nil {
+			return err
+		}
+		defer walLog.Close()
+		go walLog.Run(ctx)
+		workerOpts = append(workerOpts, worker.WithWAL(walLog, drainChunk))
+		log.Printf("Writing orders ahead to %s, sync: %s\n", walCfg.Dir, walCfg.Sync)
+	}
+
 	go worker.Worker(
+		ctx,
 		app.db,
 		app.cache,
 		sc,
 		os.Getenv("NATS_CHANNEL"),
 		os.Getenv("NATS_DURABLE"),
+		workerOpts...,
 	)
 
 	log.Println("Starting server on Port 8080")
 	err = http.ListenAndServe(":8080", router)
 	return err
 }
+
+// transient returns the classifier of the errors of dbStore worth retrying.
+func transient(dbStore store.DBIface) func(error) bool {
+	if _, isPostgres := dbStore.(*db.DBStore); isPostgres {
+		return db.Transient
+	}
+	return sqlite.Transient
+}
+
+// refused returns the classifier of the errors of dbStore that refuse an
+// order for good.
+func refused(dbStore store.DBIface) func(error) bool {
+	if _, isPostgres := dbStore.(*db.DBStore); isPostgres {
+		return db.Refused
+	}
+	return sqlite.Refused
+}
+
+// openDB connects to the database selected by driver, "postgres" (the
+// default) or "sqlite", and brings its schema up to date.
+func openDB(ctx context.Context, driver string) (store.DBIface, error) {
+	switch driver {
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "wbl0.db"
+		}
+		return sqlite.Open(ctx, path)
+	case "", "postgres":
+	default:
+		return nil, fmt.Errorf("error: unknown DB_DRIVER '%s'", driver)
+	}
+
+	connStr := fmt.Sprintf("postgresql://%s:%s@%s:%s/%s?connect_timeout=5",
+		os.Getenv("DB_USERNAME"),
+		os.Getenv("DB_PASSWORD"),
+		os.Getenv("DB_HOST"),
+		os.Getenv("DB_PORT"),
+		os.Getenv("DB_NAME"),
+	)
+
+	storageMode, err := db.ParseStorageMode(os.Getenv("DB_STORAGE"))
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts, err := dbTimeouts()
+	if err != nil {
+		return nil, err
+	}
+
+	dbStore, err := db.NewDBStore(ctx, connStr, 30*time.Second, storageMode, timeouts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrate(ctx, dbStore, os.Getenv("DB_MIGRATE"))
+	if err != nil {
+		return nil, err
+	}
+	if storageMode == db.ModeNormalized {
+		n, err := dbStore.Backfill(ctx)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Backfilled %d orders from wb_data into the normalized tables\n", n)
+	}
+	return dbStore, nil
+}
+
+// loader is a cache that can be filled in the background without replacing
+// newer entries.
+type loader interface {
+	Load([]store.Record) int
+}
+
+// loadingCache is a cache that warmUp can fill.
+type loadingCache interface {
+	store.CacheIface
+	loader
+}
+
+// newCache returns a Redis cache if CACHE_BACKEND is redis. Otherwise it
+// returns an LRU cache bounded by CACHE_MAX_ENTRIES and CACHE_MAX_BYTES, or
+// an unbounded one if neither is set. The unbounded cache is split into
+// CACHE_SHARDS segments routed by CACHE_SHARD_BY. Only the unsharded
+// unbounded cache indexes orders for search.
+func newCache() (loadingCache, error) {
+	switch backend := os.Getenv("CACHE_BACKEND"); backend {
+	case "", "memory":
+	case "redis":
+		cfg, err := redisConfig()
+		if err != nil {
+			return nil, err
+		}
+		rs := redisstore.NewRedisStore(cfg)
+		// Reads fall back to the db until the server is up.
+		if err = rs.Ping(context.Background()); err != nil {
+			log.Printf("[CACHE] Error: %s\n", err.Error())
+		}
+		log.Printf("Caching orders in Redis at %s\n", cfg.Addr)
+		return rs, nil
+	default:
+		return nil, fmt.Errorf("error: unknown CACHE_BACKEND '%s'", backend)
+	}
+	maxEntries, err := envInt("CACHE_MAX_ENTRIES", 0)
+	if err != nil {
+		return nil, err
+	}
+	maxBytes, err := envInt("CACHE_MAX_BYTES", 0)
+	if err != nil {
+		return nil, err
+	}
+	shards, err := envInt("CACHE_SHARDS", 0)
+	if err != nil {
+		return nil, err
+	}
+	route, err := sharded.ParseRoute(os.Getenv("CACHE_SHARD_BY"))
+	if err != nil {
+		return nil, err
+	}
+	if maxEntries > 0 || maxBytes > 0 {
+		if shards > 0 {
+			log.Println("Sharding is not supported by the bounded cache, using one segment")
+		}
+		log.Printf("Caching up to %d orders and %d bytes\n", maxEntries, maxBytes)
+		return lru.NewLRUStore(maxEntries, int64(maxBytes)), nil
+	}
+	if shards > 0 {
+		log.Printf("Caching orders in %d shards\n", shards)
+		return sharded.NewShardedStore(shards, route), nil
+	}
+	return mapstore.NewMapStore(make(map[int]*store.Model)), nil
+}
+
+// errCacheFull stops warmUp once a bounded cache can't take more orders.
+var errCacheFull = errors.New("cache is full")
+
+// warmUpLogInterval limits how often warmUp reports its progress.
+var warmUpLogInterval = 5 * time.Second
+
+// snapshotSlack is how long before the time of a snapshot restoreSnapshot
+// looks for changes, so that writes committed while the snapshot was taken
+// aren't missed. It must outlast the longest write transaction.
+const snapshotSlack = time.Minute
+
+// restoreSnapshot loads the cache snapshot at path and returns its high-water
+// mark, or 0 if there is no usable snapshot. Orders up to the mark that db
+// replaced since the snapshot are loaded as db has them now, and the ones it
+// archived since are left out, so that only the changes are read.
+func restoreSnapshot(ctx context.Context, ms *mapstore.MapStore, db store.ChangesIface, path string) int {
+	start := time.Now()
+	snap, err := mapstore.ReadSnapshot(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0
+	}
+	if err != nil {
+		log.Printf("[SNAPSHOT] Ignoring %s: %s\n", path, err.Error())
+		return 0
+	}
+	changed, archived, err := db.Changed(ctx, snap.Since.Add(-snapshotSlack), snap.HighWater)
+	if err != nil {
+		log.Printf("[SNAPSHOT] Ignoring %s: %s\n", path, err.Error())
+		return 0
+	}
+	skip := make(map[int]bool, len(changed)+len(archived))
+	for _, r := range changed {
+		skip[r.ID] = true
+	}
+	for _, id := range archived {
+		skip[id] = true
+	}
+	records := make([]store.Record, 0, len(snap.Records)+len(changed))
+	for _, r := range snap.Records {
+		if !skip[r.ID] {
+			records = append(records, r)
+		}
+	}
+	n := ms.Load(append(records, changed...))
+	log.Printf("[SNAPSHOT] Restored %d orders up to id %d in %s, %d changed and %d archived since\n",
+		n, snap.HighWater, time.Since(start), len(changed), len(archived))
+	return snap.HighWater
+}
+
+// warmUp streams orders with ids greater than after from db into cache in
+// chunks until it has them all or a bounded cache is full. The server and the
+// worker run meanwhile, so the orders they cache first are kept.
+func warmUp(ctx context.Context, db store.DBIface, cache loader, after, chunk int) error {
+	start := time.Now()
+	last, read, loaded := start, 0, 0
+	log.Println("[WARMUP] Loading orders into cache")
+	err := db.Stream(ctx, after, chunk, func(records []store.Record) error {
+		read += len(records)
+		loaded += cache.Load(records)
+		if time.Since(last) >= warmUpLogInterval {
+			last = time.Now()
+			log.Printf("[WARMUP] %d orders read, %d cached\n", read, loaded)
+		}
+		if c, ok := cache.(interface{ Full() bool }); ok && c.Full() {
+			return errCacheFull
+		}
+		return nil
+	})
+	if errors.Is(err, errCacheFull) {
+		log.Printf("[WARMUP] Cache is full: %d orders read, %d cached in %s\n", read, loaded, time.Since(start))
+		return nil
+	}
+	if err != nil {
+		log.Printf("[WARMUP] Error after %d orders: %s\n", read, err.Error())
+		return err
+	}
+	log.Printf("[WARMUP] Done: %d orders read, %d cached in %s\n", read, loaded, time.Since(start))
+	return nil
+}
+
+// migrate brings the schema to the version this binary expects. Mode "up"
+// (the default) applies pending migrations, "check" refuses to start on a
+// version mismatch and "off" skips schema handling altogether.
+func migrate(ctx context.Context, dbStore *db.DBStore, mode string) error {
+	if mode == "off" {
+		return nil
+	}
+	mg, err := dbStore.Migrator()
+	if err != nil {
+		return err
+	}
+	switch mode {
+	case "", "up":
+		n, err := mg.Up(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("[MIGRATE] Applied %d migrations, schema version %d\n", n, mg.Latest())
+		return nil
+	case "check":
+		return mg.Check(ctx)
+	}
+	return fmt.Errorf("error: unknown DB_MIGRATE mode '%s'", mode)
+}
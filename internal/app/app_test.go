@@ -1,13 +1,32 @@
 package app
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/gorilla/mux"
+	"github.com/ineverbee/wbl0/internal/reconcile"
+	"github.com/ineverbee/wbl0/internal/retry"
 	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/ineverbee/wbl0/internal/store/lru"
+	"github.com/ineverbee/wbl0/internal/store/mapstore"
+	"github.com/ineverbee/wbl0/internal/store/readthrough"
+	"github.com/ineverbee/wbl0/internal/store/redisstore"
+	"github.com/ineverbee/wbl0/internal/store/sharded"
+	"github.com/ineverbee/wbl0/internal/store/sqlite"
+	"github.com/ineverbee/wbl0/internal/wal"
+	"github.com/ineverbee/wbl0/internal/worker"
 	"github.com/stretchr/testify/require"
 )
 
@@ -15,6 +34,10 @@ func TestHandlers(t *testing.T) {
 	router := mux.NewRouter()
 	router.Handle("/", limit(errorHandler(GetHomePageHandler()))).Methods("GET", "POST")
 	router.Handle("/data/{id}", limit(errorHandler(GetDataPageHandler()))).Methods("GET")
+	router.Handle("/data/{id}/history", limit(errorHandler(GetHistoryPageHandler()))).Methods("GET")
+	router.Handle("/data/uid/{order_uid}", limit(errorHandler(GetDataByUIDPageHandler()))).Methods("GET")
+	router.Handle("/orders", limit(errorHandler(GetOrdersPageHandler()))).Methods("GET")
+	router.Handle("/api/orders", limit(errorHandler(GetOrdersJSONHandler()))).Methods("GET")
 
 	app = &App{
 		&http.Server{},
@@ -31,7 +54,20 @@ func TestHandlers(t *testing.T) {
 		{"GET", "/", nil, http.StatusOK},
 		{"GET", "/data/1", nil, http.StatusOK},
 		{"GET", "/data/-10", nil, http.StatusBadRequest},
+		{"GET", "/data/-20", nil, http.StatusOK},
 		{"GET", "/data/NaN", nil, http.StatusBadRequest},
+		{"GET", "/data/1/history", nil, http.StatusOK},
+		{"GET", "/data/-10/history", nil, http.StatusBadRequest},
+		{"GET", "/data/NaN/history", nil, http.StatusBadRequest},
+		{"GET", "/data/uid/b563feb7b2b84b6test", nil, http.StatusOK},
+		{"GET", "/data/uid/very_wrong_uid_for_cache", nil, http.StatusBadRequest},
+		{"GET", "/orders", nil, http.StatusOK},
+		{"GET", "/orders?customer_id=test&from=2021-11-01&to=2021-12-01T00:00:00Z", nil, http.StatusOK},
+		{"GET", "/orders?customer_id=very_wrong_customer_for_db", nil, http.StatusInternalServerError},
+		{"GET", "/api/orders?limit=10", nil, http.StatusOK},
+		{"GET", "/api/orders?limit=NaN", nil, http.StatusBadRequest},
+		{"GET", "/api/orders?from=yesterday", nil, http.StatusBadRequest},
+		{"GET", "/api/orders?cursor=!!!", nil, http.StatusBadRequest},
 	}
 	for _, c := range tc {
 		request(t, router, c.method, c.target, c.body, c.code)
@@ -48,6 +84,232 @@ func TestHandlers(t *testing.T) {
 	rr = httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
 	require.Equal(t, http.StatusFound, rr.Code)
+
+	req = httptest.NewRequest("POST", "/", nil)
+	req.URL.RawQuery += "uid=b563feb7b2b84b6test"
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusFound, rr.Code)
+	require.Equal(t, "/data/uid/b563feb7b2b84b6test", rr.Header().Get("Location"))
+}
+
+func TestOrdersJSON(t *testing.T) {
+	app = &App{
+		&http.Server{},
+		&store.DBMock{},
+		&store.CacheMock{},
+	}
+	handler := errorHandler(GetOrdersJSONHandler())
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/orders", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	res := new(listResponse)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(res))
+	require.Len(t, res.Orders, 1)
+	require.Equal(t, "b563feb7b2b84b6test", res.Orders[0].Model.Order_uid)
+	require.NotEmpty(t, res.NextCursor)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/orders?cursor="+res.NextCursor, nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	res = new(listResponse)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(res))
+	require.Empty(t, res.NextCursor)
+}
+
+func TestOrdersDateRange(t *testing.T) {
+	ctx := context.Background()
+	sqliteStore, err := sqlite.Open(ctx, ":memory:")
+	require.NoError(t, err)
+	defer sqliteStore.Close()
+	for i, date := range []time.Time{
+		time.Date(2021, 11, 25, 23, 59, 0, 0, time.UTC),
+		time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC),
+		time.Date(2021, 11, 27, 0, 0, 0, 0, time.UTC),
+	} {
+		id, date := -1, date
+		require.NoError(t, sqliteStore.Set(ctx, &id, &store.Model{Order_uid: fmt.Sprintf("uid%d", i), Date_created: &date}))
+	}
+	app = &App{&http.Server{}, sqliteStore, &store.CacheMock{}}
+	handler := errorHandler(GetOrdersJSONHandler())
+
+	tc := []struct {
+		query string
+		uids  []string
+	}{
+		{"from=2021-11-26&to=2021-11-26", []string{"uid1"}},
+		{"to=2021-11-26", []string{"uid0", "uid1"}},
+		{"from=2021-11-26&to=2021-11-26T06:22:19Z", nil},
+		{"from=2021-11-26T06:22:19Z", []string{"uid1", "uid2"}},
+	}
+	for _, c := range tc {
+		// Testing 'GetOrdersJSONHandler', expecting a date-only to to include the whole day
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/orders?"+c.query, nil))
+		require.Equal(t, http.StatusOK, rr.Code, c.query)
+		res := new(listResponse)
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(res))
+		uids := []string(nil)
+		for _, r := range res.Orders {
+			uids = append(uids, r.Model.Order_uid)
+		}
+		require.ElementsMatch(t, c.uids, uids, c.query)
+	}
+}
+
+func TestHistoryPage(t *testing.T) {
+	app = &App{
+		&http.Server{},
+		&store.DBMock{},
+		&store.CacheMock{},
+	}
+	router := mux.NewRouter()
+	router.Handle("/data/{id}/history", errorHandler(GetHistoryPageHandler()))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/data/1/history", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	require.Contains(t, body, "Version 2")
+	require.Contains(t, body, "Version 1")
+	// Only locale differs between the versions of the mock
+	require.Equal(t, 1, strings.Count(body, "table-warning"))
+}
+
+func TestWarmUp(t *testing.T) {
+	ctx := context.Background()
+	cache := mapstore.NewMapStore(make(map[int]*store.Model))
+
+	// Testing 'warmUp', expecting the streamed order in cache
+	require.NoError(t, warmUp(ctx, &store.DBMock{}, cache, 0, 10))
+	res, err := cache.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "b563feb7b2b84b6test", res.Order_uid)
+
+	// Testing 'warmUp', expecting the cached order to be kept
+	id, newer := 1, &store.Model{Order_uid: "b563feb7b2b84b6test", Locale: "ru"}
+	require.NoError(t, cache.Set(ctx, &id, newer))
+	require.NoError(t, warmUp(ctx, &store.DBMock{}, cache, 0, 10))
+	res, err = cache.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, newer, res)
+}
+
+func TestOpenDB(t *testing.T) {
+	// Testing 'openDB' with sqlite, expecting a working store
+	t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "wbl0.db"))
+	res, err := openDB(context.Background(), "sqlite")
+	require.NoError(t, err)
+	require.IsType(t, &sqlite.SQLiteStore{}, res)
+	id := -1
+	require.NoError(t, res.Set(context.Background(), &id, &store.Model{Order_uid: "b563feb7b2b84b6test"}))
+	require.NoError(t, res.(*sqlite.SQLiteStore).Close())
+
+	// Testing 'openDB', expecting error on an unknown driver
+	_, err = openDB(context.Background(), "mysql")
+	require.Error(t, err)
+}
+
+func TestEnvConfig(t *testing.T) {
+	t.Setenv("TEST_INT", "")
+	n, err := envInt("TEST_INT", 5)
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+
+	t.Setenv("TEST_INT", "12")
+	n, err = envInt("TEST_INT", 5)
+	require.NoError(t, err)
+	require.Equal(t, 12, n)
+
+	t.Setenv("TEST_INT", "NaN")
+	_, err = envInt("TEST_INT", 5)
+	require.Error(t, err)
+
+	t.Setenv("TEST_DURATION", "")
+	d, err := envDuration("TEST_DURATION", time.Second)
+	require.NoError(t, err)
+	require.Equal(t, time.Second, d)
+
+	t.Setenv("TEST_DURATION", "250ms")
+	d, err = envDuration("TEST_DURATION", time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 250*time.Millisecond, d)
+
+	t.Setenv("TEST_DURATION", "soon")
+	_, err = envDuration("TEST_DURATION", time.Second)
+	require.Error(t, err)
+
+	t.Setenv("TEST_BOOL", "")
+	b, err := envBool("TEST_BOOL", true)
+	require.NoError(t, err)
+	require.True(t, b)
+
+	t.Setenv("TEST_BOOL", "false")
+	b, err = envBool("TEST_BOOL", true)
+	require.NoError(t, err)
+	require.False(t, b)
+
+	t.Setenv("TEST_BOOL", "maybe")
+	_, err = envBool("TEST_BOOL", true)
+	require.Error(t, err)
+
+	t.Setenv("RETENTION_DAYS", "")
+	_, ok, err := retentionConfig()
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	t.Setenv("RETENTION_DAYS", "30")
+	t.Setenv("RETENTION_DRY_RUN", "true")
+	cfg, ok, err := retentionConfig()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 30*24*time.Hour, cfg.MaxAge)
+	require.Equal(t, time.Hour, cfg.Interval)
+	require.True(t, cfg.DryRun)
+
+	t.Setenv("RECONCILE_INTERVAL", "0")
+	rcfg, ok, err := reconcileConfig()
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Equal(t, reconcile.DefaultChunk, rcfg.Chunk)
+
+	t.Setenv("RECONCILE_INTERVAL", "")
+	rcfg, ok, err = reconcileConfig()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 10*time.Minute, rcfg.Interval)
+
+	t.Setenv("DB_RETRY_ATTEMPTS", "3")
+	t.Setenv("BREAKER_COOLDOWN", "1m")
+	backoff, failures, cooldown, err := retryConfig()
+	require.NoError(t, err)
+	require.Equal(t, 3, backoff.Attempts)
+	require.Equal(t, retry.DefaultBackoff.Base, backoff.Base)
+	require.Equal(t, 5, failures)
+	require.Equal(t, time.Minute, cooldown)
+
+	t.Setenv("BREAKER_FAILURES", "few")
+	_, _, _, err = retryConfig()
+	require.Error(t, err)
+
+	t.Setenv("WAL_DIR", "")
+	_, _, ok, err = walConfig()
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	t.Setenv("WAL_DIR", t.TempDir())
+	t.Setenv("WAL_SYNC", "interval")
+	wcfg, chunk, ok, err := walConfig()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, wal.SyncInterval, wcfg.Sync)
+	require.Equal(t, int64(wal.DefaultSegmentBytes), wcfg.SegmentBytes)
+	require.Equal(t, worker.DefaultDrainChunk, chunk)
+
+	t.Setenv("WAL_SYNC", "sometimes")
+	_, _, _, err = walConfig()
+	require.Error(t, err)
 }
 
 func request(t *testing.T, handler http.Handler, method, target string, body io.Reader, code int) {
@@ -56,3 +318,480 @@ func request(t *testing.T, handler http.Handler, method, target string, body io.
 	handler.ServeHTTP(rr, req)
 	require.Equal(t, code, rr.Code)
 }
+
+func TestCacheMiss(t *testing.T) {
+	ctx := context.Background()
+	router := mux.NewRouter()
+	router.Handle("/data/{id}", errorHandler(GetDataPageHandler()))
+	router.Handle("/data/uid/{order_uid}", errorHandler(GetDataByUIDPageHandler()))
+	router.Handle("/api/cache", errorHandler(GetCacheStatsHandler()))
+	sqliteStore, err := sqlite.Open(ctx, ":memory:")
+	require.NoError(t, err)
+	defer sqliteStore.Close()
+	id := -1
+	require.NoError(t, sqliteStore.Set(ctx, &id, &store.Model{Order_uid: "b563feb7b2b84b6test"}))
+	cache := lru.NewLRUStore(10, 0)
+	app = &App{&http.Server{}, sqliteStore, readthrough.NewReadThrough(cache, sqliteStore, time.Minute)}
+
+	// Testing 'GetDataByUIDPageHandler' on a cache miss, expecting the order from db
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/data/uid/b563feb7b2b84b6test", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), "b563feb7b2b84b6test")
+
+	// Testing 'GetDataPageHandler' on a cache miss, expecting the order from db cached
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/data/%d", id), nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	res, err := cache.Get(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, "b563feb7b2b84b6test", res.Order_uid)
+
+	// Testing 'GetCacheStatsHandler', expecting the cache counters
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/cache", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var stats lru.Stats
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&stats))
+	require.Equal(t, uint64(2), stats.Misses)
+	require.Equal(t, uint64(1), stats.Hits)
+	require.Equal(t, 1, stats.Entries)
+
+	// Testing 'GetDataPageHandler' of an order that doesn't exist, expecting StatusBadRequest
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/data/999", nil))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// Testing 'GetCacheStatsHandler' with an unbounded cache, expecting StatusNotFound
+	app.cache = mapstore.NewMapStore(make(map[int]*store.Model))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/cache", nil))
+	require.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestWarmUpFull(t *testing.T) {
+	ctx := context.Background()
+	sqliteStore, err := sqlite.Open(ctx, ":memory:")
+	require.NoError(t, err)
+	defer sqliteStore.Close()
+	for i := 0; i < 5; i++ {
+		id := -1
+		require.NoError(t, sqliteStore.Set(ctx, &id, &store.Model{Order_uid: fmt.Sprintf("uid%d", i)}))
+	}
+
+	// Testing 'warmUp' into a bounded cache, expecting it to stop once full
+	cache := lru.NewLRUStore(2, 0)
+	require.NoError(t, warmUp(ctx, sqliteStore, cache, 0, 1))
+	require.Equal(t, 2, cache.Stats().Entries)
+	require.Zero(t, cache.Stats().Evictions)
+}
+
+func TestNewCache(t *testing.T) {
+	// Testing 'newCache' without limits, expecting an unbounded cache
+	t.Setenv("CACHE_MAX_ENTRIES", "")
+	t.Setenv("CACHE_MAX_BYTES", "")
+	c, err := newCache()
+	require.NoError(t, err)
+	require.IsType(t, &mapstore.MapStore{}, c)
+
+	// Testing 'newCache' with a limit, expecting an LRU cache
+	t.Setenv("CACHE_MAX_BYTES", "1048576")
+	c, err = newCache()
+	require.NoError(t, err)
+	require.Equal(t, int64(1048576), c.(*lru.LRUStore).Stats().MaxBytes)
+
+	// Testing 'newCache' with shards, expecting a sharded cache
+	t.Setenv("CACHE_MAX_BYTES", "")
+	t.Setenv("CACHE_SHARDS", "16")
+	t.Setenv("CACHE_SHARD_BY", "shardkey")
+	c, err = newCache()
+	require.NoError(t, err)
+	require.IsType(t, &sharded.ShardedStore{}, c)
+
+	// Testing 'newCache', expecting error on an unknown route
+	t.Setenv("CACHE_SHARD_BY", "customer_id")
+	_, err = newCache()
+	require.Error(t, err)
+
+	// Testing 'newCache', expecting error on a bad limit
+	t.Setenv("CACHE_MAX_ENTRIES", "many")
+	_, err = newCache()
+	require.Error(t, err)
+
+	// Testing 'newCache' with the redis backend, expecting a Redis cache
+	srv := miniredis.RunT(t)
+	t.Setenv("CACHE_BACKEND", "redis")
+	t.Setenv("REDIS_ADDR", srv.Addr())
+	t.Setenv("REDIS_TTL", "1h")
+	c, err = newCache()
+	require.NoError(t, err)
+	id := 1
+	require.NoError(t, c.Set(context.Background(), &id, &store.Model{Order_uid: "b563feb7b2b84b6test"}))
+	require.Equal(t, time.Hour, srv.TTL("wbl0:order:1"))
+	c.(*redisstore.RedisStore).Close()
+
+	// Testing 'newCache', expecting error on a bad redis setting
+	t.Setenv("REDIS_TIMEOUT", "soon")
+	_, err = newCache()
+	require.Error(t, err)
+
+	// Testing 'newCache', expecting error on an unknown backend
+	t.Setenv("CACHE_BACKEND", "memcached")
+	_, err = newCache()
+	require.Error(t, err)
+}
+
+func TestSearch(t *testing.T) {
+	router := mux.NewRouter()
+	router.Handle("/search", errorHandler(GetSearchPageHandler()))
+	router.Handle("/api/search", errorHandler(GetSearchJSONHandler()))
+	cache := mapstore.NewMapStore(map[int]*store.Model{
+		1: {Order_uid: "b563feb7b2b84b6test", Track_number: "WBILMTESTTRACK", Items: []*store.Item{{Rid: "ab4219087a764ae0btest"}}},
+		2: {Order_uid: "other", Track_number: "WBILMTESTTRACK"},
+	})
+	app = &App{&http.Server{}, &store.DBMock{}, readthrough.NewReadThrough(cache, &store.DBMock{}, time.Minute)}
+
+	tc := []struct {
+		target string
+		code   int
+		count  int
+	}{
+		{"/api/search?field=track_number&value=WBILMTESTTRACK", http.StatusOK, 2},
+		{"/api/search?field=rid&value=ab4219087a764ae0btest", http.StatusOK, 1},
+		{"/api/search?field=customer_id&value=nobody", http.StatusOK, 0},
+		{"/api/search?field=locale&value=en", http.StatusBadRequest, 0},
+		{"/api/search?field=rid", http.StatusBadRequest, 0},
+	}
+	for _, c := range tc {
+		// Testing 'GetSearchJSONHandler', expecting the matching orders
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", c.target, nil))
+		require.Equal(t, c.code, rr.Code, c.target)
+		if c.code != http.StatusOK {
+			continue
+		}
+		var res listResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+		require.Len(t, res.Orders, c.count, c.target)
+	}
+
+	// Testing 'GetSearchPageHandler', expecting the found orders linked
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/search?field=order_uid&value=other", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), `href="/data/2"`)
+
+	// Testing 'GetSearchPageHandler' with a cache without indexes, expecting StatusNotImplemented
+	app.cache = &store.CacheMock{}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/search", nil))
+	require.Equal(t, http.StatusNotImplemented, rr.Code)
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	sqliteStore, err := sqlite.Open(ctx, ":memory:")
+	require.NoError(t, err)
+	defer sqliteStore.Close()
+	old, recent := time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC), time.Now().UTC()
+	set := func(i int, locale string) {
+		date := &recent
+		if i == 3 {
+			date = &old
+		}
+		id := -1
+		require.NoError(t, sqliteStore.Set(ctx, &id, &store.Model{Order_uid: fmt.Sprintf("uid%d", i), Locale: locale, Date_created: date}))
+	}
+	for i := 1; i <= 5; i++ {
+		set(i, "en")
+	}
+
+	// Testing 'restoreSnapshot' without a snapshot, expecting a full warm-up
+	cache := mapstore.NewMapStore(make(map[int]*store.Model))
+	require.Zero(t, restoreSnapshot(ctx, cache, sqliteStore, path))
+	err = sqliteStore.Stream(ctx, 0, 4, func(records []store.Record) error {
+		cache.Load(records)
+		return errCacheFull
+	})
+	require.ErrorIs(t, err, errCacheFull)
+	// The snapshot tells which orders were restored from it.
+	id := 1
+	require.NoError(t, cache.Set(ctx, &id, &store.Model{Order_uid: "uid1", Locale: "snapshot"}))
+	since, err := sqliteStore.Now(ctx)
+	require.NoError(t, err)
+	_, err = cache.WriteSnapshot(path, since)
+	require.NoError(t, err)
+	set(2, "ru")
+	_, err = sqliteStore.Archive(ctx, old.Add(time.Hour), 10, false)
+	require.NoError(t, err)
+
+	// Testing 'restoreSnapshot', expecting the unchanged orders from the
+	// snapshot, the replaced ones from the db, the archived ones left out and
+	// only orders past the high-water mark streamed
+	cache = mapstore.NewMapStore(make(map[int]*store.Model))
+	require.Equal(t, 4, restoreSnapshot(ctx, cache, sqliteStore, path))
+	res, err := cache.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "snapshot", res.Locale)
+	res, err = cache.Get(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, "ru", res.Locale)
+	_, err = cache.Get(ctx, 3)
+	require.Error(t, err)
+	streamed := 0
+	require.NoError(t, sqliteStore.Stream(ctx, 4, 10, func(records []store.Record) error {
+		streamed += len(records)
+		return nil
+	}))
+	require.Equal(t, 1, streamed)
+	require.NoError(t, warmUp(ctx, sqliteStore, cache, 4, 10))
+	for _, id := range []int{4, 5} {
+		_, err = cache.Get(ctx, id)
+		require.NoError(t, err)
+	}
+
+	// Testing 'restoreSnapshot' of a corrupt snapshot, expecting a full warm-up
+	require.NoError(t, os.WriteFile(path, []byte("garbage"), 0644))
+	require.Zero(t, restoreSnapshot(ctx, mapstore.NewMapStore(make(map[int]*store.Model)), sqliteStore, path))
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	router := mux.NewRouter()
+	router.Handle("/admin/reconcile", errorHandler(GetReconcileHandler()))
+	sqliteStore, err := sqlite.Open(ctx, ":memory:")
+	require.NoError(t, err)
+	defer sqliteStore.Close()
+	id := -1
+	require.NoError(t, sqliteStore.Set(ctx, &id, &store.Model{Order_uid: "b563feb7b2b84b6test"}))
+	cache := mapstore.NewMapStore(make(map[int]*store.Model))
+	app = &App{&http.Server{}, sqliteStore, cache}
+
+	// Testing 'GetReconcileHandler' without a reconciler, expecting StatusNotFound
+	reconciler = nil
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/reconcile", nil))
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	// Testing 'GetReconcileHandler' before any run, expecting null
+	reconciler = reconcile.NewReconciler(sqliteStore, cache, reconcile.Config{})
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/reconcile", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "null\n", rr.Body.String())
+
+	// Testing 'GetReconcileHandler' on POST, expecting the missing order repaired
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/admin/reconcile", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var report reconcile.Report
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&report))
+	require.Equal(t, 1, report.Missing)
+	_, err = cache.Get(ctx, id)
+	require.NoError(t, err)
+
+	// Testing 'GetReconcileHandler', expecting the last report
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/reconcile", nil))
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&report))
+	require.Equal(t, 1, report.Missing)
+}
+
+func TestAdmin(t *testing.T) {
+	router := mux.NewRouter()
+	router.Handle("/admin", admin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	serve := func(method string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.com/admin", nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	basic := func(password string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth("admin", password)
+		return req.Header.Get("Authorization")
+	}
+	defer func() { adminToken = "" }()
+
+	// Testing 'admin' without a token configured, expecting StatusForbidden
+	adminToken = ""
+	rr := serve("GET", http.Header{"Authorization": {"Bearer "}})
+	require.Equal(t, http.StatusForbidden, rr.Code)
+
+	adminToken = "secret"
+	// Testing 'admin' without credentials, expecting StatusUnauthorized
+	rr = serve("GET", nil)
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+	require.NotEmpty(t, rr.Header().Get("WWW-Authenticate"))
+
+	// Testing 'admin' with a wrong token, expecting StatusUnauthorized
+	rr = serve("POST", http.Header{"Authorization": {"Bearer wrong"}})
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr = serve("GET", http.Header{"Authorization": {basic("wrong")}})
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// Testing 'admin' with a bearer token, expecting StatusOK
+	rr = serve("POST", http.Header{"Authorization": {"Bearer secret"}})
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// Testing 'admin' with basic auth, expecting StatusOK for reads
+	rr = serve("GET", http.Header{"Authorization": {basic("secret")}})
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// Testing 'admin' with basic auth on a cross-origin POST, expecting
+	// StatusForbidden
+	rr = serve("POST", http.Header{"Authorization": {basic("secret")}})
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	rr = serve("POST", http.Header{"Authorization": {basic("secret")}, "Origin": {"http://evil.com"}})
+	require.Equal(t, http.StatusForbidden, rr.Code)
+
+	// Testing 'admin' with basic auth on a same-origin POST, expecting StatusOK
+	rr = serve("POST", http.Header{"Authorization": {basic("secret")}, "Origin": {"http://example.com"}})
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr = serve("POST", http.Header{"Authorization": {basic("secret")}, "Referer": {"http://example.com/admin/quarantine"}})
+	require.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestQuarantine(t *testing.T) {
+	ctx := context.Background()
+	router := mux.NewRouter()
+	router.Handle("/admin/quarantine", errorHandler(GetQuarantinePageHandler()))
+	router.Handle("/admin/quarantine/{id}", errorHandler(GetQuarantinedPageHandler()))
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Testing 'GetQuarantinePageHandler' with a db without quarantine,
+	// expecting StatusNotImplemented
+	app = &App{&http.Server{}, &store.DBMock{}, &store.CacheMock{}}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/quarantine", nil))
+	require.Equal(t, http.StatusNotImplemented, rr.Code)
+
+	sqliteStore, err := sqlite.Open(ctx, ":memory:")
+	require.NoError(t, err)
+	defer sqliteStore.Close()
+	cache := mapstore.NewMapStore(make(map[int]*store.Model))
+	app = &App{&http.Server{}, sqliteStore, cache}
+	order, err := os.ReadFile("../../model.json")
+	require.NoError(t, err)
+	broken := strings.Replace(string(order), `"sm_id": 99`, `"sm_id": -1`, 1)
+	require.NoError(t, sqliteStore.Quarantine(ctx, &store.Quarantined{
+		Data:         []byte(broken),
+		Class:        store.ClassDecode,
+		Reason:       "json: cannot unmarshal number -1 into Go struct field Model.sm_id of type uint",
+		Channel:      "foo",
+		Nats_seq:     7,
+		Published_at: time.Now(),
+	}))
+
+	// Testing 'GetQuarantinePageHandler', expecting the message listed
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/quarantine", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), `href="/admin/quarantine/1"`)
+
+	// Testing 'GetQuarantinedPageHandler', expecting the schema issue
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/quarantine/1", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), "<td>sm_id</td>")
+	require.Contains(t, rr.Body.String(), "<td>wrong type</td>")
+
+	// Testing 'GetQuarantinedPageHandler' with a bad id, expecting errors
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/quarantine/2", nil))
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/quarantine/NaN", nil))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// Testing 'GetQuarantinedPageHandler' saving an edit, expecting it kept
+	rr = post("/admin/quarantine/1", url.Values{"action": {"save"}, "data": {`{"sm_id": 99}`}})
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	msg, err := sqliteStore.GetQuarantined(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, `{"sm_id": 99}`, string(msg.Data))
+
+	// Testing 'GetQuarantinedPageHandler' resubmitting an invalid message,
+	// expecting it kept with the new class
+	rr = post("/admin/quarantine/1", url.Values{"action": {"resubmit"}, "data": {`{"sm_id": 99}`}})
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), "Resubmit failed")
+	msg, err = sqliteStore.GetQuarantined(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, store.ClassValidation, msg.Class)
+
+	// Testing 'GetQuarantinedPageHandler' resubmitting a fixed message,
+	// expecting the order stored and cached and the message gone
+	rr = post("/admin/quarantine/1", url.Values{"action": {"resubmit"}, "data": {string(order)}})
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	require.Equal(t, "/data/1", rr.Header().Get("Location"))
+	res, err := cache.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "b563feb7b2b84b6test", res.Order_uid)
+	_, err = sqliteStore.GetQuarantined(ctx, 1)
+	require.ErrorIs(t, err, store.Error404NotFound)
+
+	// Testing 'GetQuarantinedPageHandler' behind 'admin' with a cross-site
+	// POST, expecting StatusForbidden and the message kept
+	require.NoError(t, sqliteStore.Quarantine(ctx, &store.Quarantined{Data: []byte("{"), Channel: "foo", Nats_seq: 8}))
+	adminToken = "secret"
+	defer func() { adminToken = "" }()
+	req := httptest.NewRequest("POST", "http://example.com/admin/quarantine/2", strings.NewReader("action=delete"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "http://evil.com")
+	req.SetBasicAuth("admin", "secret")
+	rr = httptest.NewRecorder()
+	admin(errorHandler(GetQuarantinedPageHandler())).ServeHTTP(rr, mux.SetURLVars(req, map[string]string{"id": "2"}))
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	_, err = sqliteStore.GetQuarantined(ctx, 2)
+	require.NoError(t, err)
+
+	// Testing 'GetQuarantinedPageHandler' deleting a message, expecting it gone
+	rr = post("/admin/quarantine/2", url.Values{"action": {"delete"}})
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	_, err = sqliteStore.GetQuarantined(ctx, 2)
+	require.ErrorIs(t, err, store.Error404NotFound)
+}
+
+func TestHealth(t *testing.T) {
+	router := mux.NewRouter()
+	router.Handle("/health", errorHandler(GetHealthHandler()))
+	health := func(code int) Health {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+		require.Equal(t, code, rr.Code)
+		var h Health
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&h))
+		return h
+	}
+
+	// Testing 'GetHealthHandler' without a breaker, expecting ok
+	dbBreaker = nil
+	require.Equal(t, Health{Status: "ok"}, health(http.StatusOK))
+
+	// Testing 'GetHealthHandler' with a closed breaker, expecting ok
+	dbBreaker = retry.NewBreaker("db", 1, time.Hour)
+	h := health(http.StatusOK)
+	require.Equal(t, "ok", h.Status)
+	require.Equal(t, retry.Closed, h.DB.State)
+
+	// Testing 'GetHealthHandler' with an open breaker, expecting
+	// StatusServiceUnavailable and the error that opened it
+	require.NoError(t, dbBreaker.Wait(context.Background()))
+	dbBreaker.Record(fmt.Errorf("connection refused"))
+	h = health(http.StatusServiceUnavailable)
+	require.Equal(t, "degraded", h.Status)
+	require.Equal(t, retry.Open, h.DB.State)
+	require.Equal(t, "connection refused", h.DB.Error)
+	dbBreaker = nil
+}
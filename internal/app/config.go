@@ -0,0 +1,188 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/reconcile"
+	"github.com/ineverbee/wbl0/internal/retention"
+	"github.com/ineverbee/wbl0/internal/retry"
+	"github.com/ineverbee/wbl0/internal/store/db"
+	"github.com/ineverbee/wbl0/internal/store/redisstore"
+	"github.com/ineverbee/wbl0/internal/wal"
+	"github.com/ineverbee/wbl0/internal/worker"
+)
+
+// envInt reads an integer environment variable, def is used when it's unset.
+func envInt(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("error: %s should be an integer: %w", name, err)
+	}
+	return n, nil
+}
+
+// envDuration reads a time.ParseDuration environment variable, def is used
+// when it's unset.
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("error: %s should be a duration: %w", name, err)
+	}
+	return d, nil
+}
+
+// envBool reads a strconv.ParseBool environment variable, def is used when
+// it's unset.
+func envBool(name string, def bool) (bool, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("error: %s should be a boolean: %w", name, err)
+	}
+	return b, nil
+}
+
+// dbTimeouts reads the per-operation DBStore timeouts.
+func dbTimeouts() (db.Timeouts, error) {
+	var (
+		t   db.Timeouts
+		err error
+	)
+	if t.Set, err = envDuration("DB_TIMEOUT_SET", 5*time.Second); err != nil {
+		return t, err
+	}
+	if t.Get, err = envDuration("DB_TIMEOUT_GET", 2*time.Second); err != nil {
+		return t, err
+	}
+	if t.Fetch, err = envDuration("DB_TIMEOUT_FETCH", 30*time.Second); err != nil {
+		return t, err
+	}
+	if t.Find, err = envDuration("DB_TIMEOUT_FIND", 10*time.Second); err != nil {
+		return t, err
+	}
+	if t.Archive, err = envDuration("DB_TIMEOUT_ARCHIVE", 30*time.Second); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// retentionConfig reads the retention job settings. The job is disabled
+// unless RETENTION_DAYS is positive.
+func retentionConfig() (retention.Config, bool, error) {
+	var cfg retention.Config
+	days, err := envInt("RETENTION_DAYS", 0)
+	if err != nil || days <= 0 {
+		return cfg, false, err
+	}
+	cfg.MaxAge = time.Duration(days) * 24 * time.Hour
+	if cfg.Interval, err = envDuration("RETENTION_INTERVAL", time.Hour); err != nil {
+		return cfg, false, err
+	}
+	if cfg.Batch, err = envInt("RETENTION_BATCH", retention.DefaultBatch); err != nil {
+		return cfg, false, err
+	}
+	if cfg.DryRun, err = envBool("RETENTION_DRY_RUN", false); err != nil {
+		return cfg, false, err
+	}
+	return cfg, true, nil
+}
+
+// reconcileConfig reads the reconciler settings. ok is false when
+// RECONCILE_INTERVAL is 0, then the reconciler only runs on request.
+func reconcileConfig() (reconcile.Config, bool, error) {
+	var (
+		cfg reconcile.Config
+		err error
+	)
+	if cfg.Interval, err = envDuration("RECONCILE_INTERVAL", 10*time.Minute); err != nil {
+		return cfg, false, err
+	}
+	if cfg.Chunk, err = envInt("RECONCILE_CHUNK", reconcile.DefaultChunk); err != nil {
+		return cfg, false, err
+	}
+	return cfg, cfg.Interval > 0, nil
+}
+
+// redisConfig reads the Redis cache settings.
+func redisConfig() (redisstore.Config, error) {
+	cfg := redisstore.Config{
+		Addr:     os.Getenv("REDIS_ADDR"),
+		Password: os.Getenv("REDIS_PASSWORD"),
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = "localhost:6379"
+	}
+	var err error
+	if cfg.DB, err = envInt("REDIS_DB", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.PoolSize, err = envInt("REDIS_POOL_SIZE", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.TTL, err = envDuration("REDIS_TTL", 24*time.Hour); err != nil {
+		return cfg, err
+	}
+	if cfg.Timeout, err = envDuration("REDIS_TIMEOUT", 200*time.Millisecond); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// retryConfig reads how the worker retries transient db errors, and when the
+// breaker around the db opens.
+func retryConfig() (retry.Backoff, int, time.Duration, error) {
+	b := retry.DefaultBackoff
+	var err error
+	if b.Attempts, err = envInt("DB_RETRY_ATTEMPTS", b.Attempts); err != nil {
+		return b, 0, 0, err
+	}
+	if b.Base, err = envDuration("DB_RETRY_BASE", b.Base); err != nil {
+		return b, 0, 0, err
+	}
+	if b.Max, err = envDuration("DB_RETRY_MAX", b.Max); err != nil {
+		return b, 0, 0, err
+	}
+	failures, err := envInt("BREAKER_FAILURES", 5)
+	if err != nil {
+		return b, 0, 0, err
+	}
+	cooldown, err := envDuration("BREAKER_COOLDOWN", 10*time.Second)
+	return b, failures, cooldown, err
+}
+
+// walConfig reads the write-ahead log settings and how many orders are
+// drained at a time. The log is disabled unless WAL_DIR is set.
+func walConfig() (wal.Config, int, bool, error) {
+	cfg := wal.Config{Dir: os.Getenv("WAL_DIR")}
+	if cfg.Dir == "" {
+		return cfg, 0, false, nil
+	}
+	var err error
+	if cfg.Sync, err = wal.ParseSyncPolicy(os.Getenv("WAL_SYNC")); err != nil {
+		return cfg, 0, false, err
+	}
+	if cfg.SyncInterval, err = envDuration("WAL_SYNC_INTERVAL", 100*time.Millisecond); err != nil {
+		return cfg, 0, false, err
+	}
+	segmentBytes, err := envInt("WAL_SEGMENT_BYTES", wal.DefaultSegmentBytes)
+	if err != nil {
+		return cfg, 0, false, err
+	}
+	cfg.SegmentBytes = int64(segmentBytes)
+	chunk, err := envInt("WAL_DRAIN_CHUNK", worker.DefaultDrainChunk)
+	return cfg, chunk, err == nil, err
+}
@@ -1,13 +1,25 @@
 package app
 
 import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/ineverbee/wbl0/internal/retry"
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/ineverbee/wbl0/internal/store/lru"
+	"github.com/ineverbee/wbl0/internal/store/readthrough"
+	"github.com/ineverbee/wbl0/internal/worker"
 	"golang.org/x/time/rate"
 )
 
@@ -24,6 +36,51 @@ func limit(next http.Handler) http.Handler {
 	})
 }
 
+// adminToken guards the /admin endpoints, which are disabled while it's
+// empty.
+var adminToken string
+
+// admin lets through requests that carry adminToken as a bearer token or as
+// the basic auth password, which browsers prompt for. Browsers resend basic
+// auth on their own, so requests authenticated with it that may change
+// something must also come from a page of this server.
+func admin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			http.Error(w, "error: admin endpoints are disabled", http.StatusForbidden)
+			return
+		}
+		token, basic := "", false
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		} else {
+			_, token, basic = r.BasicAuth()
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="wbl0 admin"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if basic && r.Method != http.MethodGet && r.Method != http.MethodHead && !sameOrigin(r) {
+			http.Error(w, "error: cross-origin request", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin reports whether r comes from a page of the host it's sent to,
+// going by its Origin header or, without one, its Referer.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && u.Host == r.Host
+}
+
 // Error represents a handler error. It provides methods for a HTTP status
 // code and embeds the built-in error interface.
 type Error interface {
@@ -83,20 +140,9 @@ var base string = `
 </body>
 </html>`
 
-// GetDataPageHandler..
-func GetDataPageHandler() errorHandler {
-	return func(rw http.ResponseWriter, r *http.Request) error {
-		param := mux.Vars(r)["id"]
-		id, err := strconv.Atoi(param)
-		if err != nil {
-			return &StatusError{http.StatusBadRequest, fmt.Errorf("error: id is NaN")}
-		}
-		model, err := app.cache.Get(id)
-		if err != nil {
-			return &StatusError{http.StatusBadRequest, err}
-		}
-		tmpl := template.Must(template.New("data").Parse(fmt.Sprintf(base, `
-		<h1>Data</h1>
+var dataPage string = `
+		<h1>Data{{if .Archived}} <span class="badge badge-secondary">archived</span>{{end}}</h1>
+		{{if .History}}<a class="btn btn-secondary mb-3" href="{{ .History}}">History</a>{{end}}
 		<table class="table">
 			<thead>
 				<tr>
@@ -273,13 +319,624 @@ func GetDataPageHandler() errorHandler {
 					<td>{{ .Date_created}}</td>
 				</tr>
 			</tbody>
-		</table>`)))
+		</table>`
+
+// GetDataPageHandler..
+func GetDataPageHandler() errorHandler {
+	return func(rw http.ResponseWriter, r *http.Request) error {
+		param := mux.Vars(r)["id"]
+		id, err := strconv.Atoi(param)
+		if err != nil {
+			return &StatusError{http.StatusBadRequest, fmt.Errorf("error: id is NaN")}
+		}
+		model, err := app.cache.Get(r.Context(), id)
+		if err != nil {
+			// Archived orders are evicted from the cache.
+			archived, aerr := app.db.GetArchived(r.Context(), id)
+			if aerr != nil {
+				return &StatusError{http.StatusBadRequest, err}
+			}
+			renderData(rw, dataView{Model: archived, Archived: true})
+			return nil
+		}
+		renderData(rw, dataView{Model: model, History: fmt.Sprintf("/data/%d/history", id)})
+		return nil
+	}
+}
+
+// GetDataByUIDPageHandler..
+func GetDataByUIDPageHandler() errorHandler {
+	return func(rw http.ResponseWriter, r *http.Request) error {
+		uid := mux.Vars(r)["order_uid"]
+		if uid == "" {
+			return &StatusError{http.StatusBadRequest, fmt.Errorf("error: order_uid is empty")}
+		}
+		model, err := app.cache.GetByUID(r.Context(), uid)
+		if err != nil {
+			return &StatusError{http.StatusBadRequest, err}
+		}
+		renderData(rw, dataView{Model: model})
+		return nil
+	}
+}
+
+// GetCacheStatsHandler reports the cache counters if the cache keeps any.
+func GetCacheStatsHandler() errorHandler {
+	return func(rw http.ResponseWriter, r *http.Request) error {
+		c, ok := localCache().(statser)
+		if !ok {
+			return &StatusError{http.StatusNotFound, fmt.Errorf("error: cache keeps no stats")}
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(rw).Encode(c.Stats())
+	}
+}
+
+type statser interface {
+	Stats() lru.Stats
+}
+
+// GetReconcileHandler reports the last reconciliation of the cache with the
+// db, or runs one on POST and reports it.
+func GetReconcileHandler() errorHandler {
+	return func(rw http.ResponseWriter, r *http.Request) error {
+		if reconciler == nil {
+			return &StatusError{http.StatusNotFound, fmt.Errorf("error: no reconciler")}
+		}
+		report := reconciler.Last()
+		if r.Method == http.MethodPost {
+			res, err := reconciler.Once(r.Context())
+			if err != nil {
+				return err
+			}
+			report = &res
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(rw).Encode(report)
+	}
+}
+
+// Health is the body of the health endpoint.
+type Health struct {
+	Status string `json:"status"`
+	// DB is the state of the breaker around the db, if there's one.
+	DB *retry.Status `json:"db,omitempty"`
+}
+
+// GetHealthHandler reports whether the service is up, and degraded, with a
+// 503, while the breaker around the db isn't closed.
+func GetHealthHandler() errorHandler {
+	return func(rw http.ResponseWriter, r *http.Request) error {
+		health, code := Health{Status: "ok"}, http.StatusOK
+		if dbBreaker != nil {
+			status := dbBreaker.Status()
+			health.DB = &status
+			if status.State != retry.Closed {
+				health.Status, code = "degraded", http.StatusServiceUnavailable
+			}
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(code)
+		return json.NewEncoder(rw).Encode(health)
+	}
+}
+
+var quarantinePage string = `
+		<h1>Quarantine</h1>
+		<table class="table table-sm table-hover">
+			<thead>
+				<tr>
+				<th scope="col">#</th>
+				<th scope="col">quarantined_at</th>
+				<th scope="col">channel</th>
+				<th scope="col">nats_seq</th>
+				<th scope="col">class</th>
+				<th scope="col">reason</th>
+				</tr>
+			</thead>
+			<tbody>
+			{{range .}}
+				<tr>
+					<th scope="row"><a href="/admin/quarantine/{{ .ID}}">{{ .ID}}</a></th>
+					<td>{{ .Quarantined_at}}</td>
+					<td>{{ .Channel}}</td>
+					<td>{{ .Nats_seq}}</td>
+					<td>{{ .Class}}</td>
+					<td>{{ .Reason}}</td>
+				</tr>
+			{{else}}
+				<tr><td colspan="6">Nothing quarantined</td></tr>
+			{{end}}
+			</tbody>
+		</table>`
+
+var quarantinedPage string = `
+		<h1>Message {{ .ID}} <span class="badge badge-warning">{{ .Class}}</span></h1>
+		<a class="btn btn-secondary mb-3" href="/admin/quarantine">Quarantine</a>
+		<p>
+			[{{ .Channel}}] nats_seq: {{ .Nats_seq}}, published at {{ .Published_at}},
+			quarantined at {{ .Quarantined_at}}
+		</p>
+		{{with .Error}}<div class="alert alert-danger">Resubmit failed: {{ .}}</div>{{end}}
+		<div class="alert alert-secondary">{{ .Reason}}</div>
+		<h4>Schema</h4>
+		{{with .SchemaError}}
+		<p>Not JSON: {{ .}}</p>
+		{{else}}
+		<table class="table table-sm">
+			<thead>
+				<tr>
+				<th scope="col">Key</th>
+				<th scope="col">Problem</th>
+				<th scope="col">Expected</th>
+				<th scope="col">Got</th>
+				</tr>
+			</thead>
+			<tbody>
+			{{range .Issues}}
+				<tr>
+					<td>{{ .Key}}</td>
+					<td>{{ .Problem}}</td>
+					<td>{{ .Expected}}</td>
+					<td>{{ .Got}}</td>
+				</tr>
+			{{else}}
+				<tr><td colspan="4">Matches the schema</td></tr>
+			{{end}}
+			</tbody>
+		</table>
+		{{end}}
+		<form method="POST">
+			<textarea class="form-control text-monospace mb-2" name="data" rows="20">{{ .Raw}}</textarea>
+			<button class="btn btn-primary" type="submit" name="action" value="resubmit">Resubmit</button>
+			<button class="btn btn-secondary" type="submit" name="action" value="save">Save</button>
+			<button class="btn btn-danger" type="submit" name="action" value="delete">Delete</button>
+		</form>`
+
+// quarantinedView is a quarantined message with its differences from the
+// schema of an order. Error is why the last resubmit failed.
+type quarantinedView struct {
+	*store.Quarantined
+	Raw         string
+	Issues      []store.SchemaIssue
+	SchemaError string
+	Error       string
+}
+
+// GetQuarantinePageHandler lists the quarantined messages, newest first.
+func GetQuarantinePageHandler() errorHandler {
+	return func(rw http.ResponseWriter, r *http.Request) error {
+		q, err := quarantine()
+		if err != nil {
+			return err
+		}
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil {
+				return &StatusError{http.StatusBadRequest, fmt.Errorf("error: limit is NaN")}
+			}
+		}
+		list, err := q.ListQuarantined(r.Context(), limit)
+		if err != nil {
+			return err
+		}
+		tmpl := template.Must(template.New("quarantine").Parse(fmt.Sprintf(base, quarantinePage)))
+		tmpl.Execute(rw, list)
+		return nil
+	}
+}
 
-		tmpl.Execute(rw, model)
+// GetQuarantinedPageHandler shows a quarantined message and how it differs
+// from the schema of an order. On POST the edited message is saved, deleted
+// or resubmitted through the pipeline of the worker. A resubmitted message
+// leaves the quarantine once its order is stored.
+func GetQuarantinedPageHandler() errorHandler {
+	return func(rw http.ResponseWriter, r *http.Request) error {
+		q, err := quarantine()
+		if err != nil {
+			return err
+		}
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			return &StatusError{http.StatusBadRequest, fmt.Errorf("error: id is NaN")}
+		}
+		msg, err := q.GetQuarantined(r.Context(), id)
+		if errors.Is(err, store.Error404NotFound) {
+			return &StatusError{http.StatusNotFound, err}
+		}
+		if err != nil {
+			return err
+		}
+		view := quarantinedView{Quarantined: msg}
+		if r.Method == http.MethodPost {
+			self := fmt.Sprintf("/admin/quarantine/%d", id)
+			switch r.FormValue("action") {
+			case "delete":
+				if err = q.DeleteQuarantined(r.Context(), id); err != nil {
+					return err
+				}
+				http.Redirect(rw, r, "/admin/quarantine", http.StatusSeeOther)
+				return nil
+			case "save":
+				msg.Data = []byte(r.FormValue("data"))
+				if err = q.UpdateQuarantined(r.Context(), msg); err != nil {
+					return err
+				}
+				http.Redirect(rw, r, self, http.StatusSeeOther)
+				return nil
+			case "resubmit":
+				msg.Data = []byte(r.FormValue("data"))
+				orderID, err := worker.Resubmit(r.Context(), app.db, app.cache, msg.Data)
+				if err == nil {
+					if err = q.DeleteQuarantined(r.Context(), id); err != nil {
+						return err
+					}
+					http.Redirect(rw, r, fmt.Sprintf("/data/%d", orderID), http.StatusSeeOther)
+					return nil
+				}
+				// Keep the edit, and why it's still rejected.
+				if class := worker.Classify(err); class != "" {
+					msg.Class, msg.Reason = class, err.Error()
+				}
+				if uerr := q.UpdateQuarantined(r.Context(), msg); uerr != nil {
+					return uerr
+				}
+				view.Error = err.Error()
+			default:
+				return &StatusError{http.StatusBadRequest, fmt.Errorf("error: unknown action '%s'", r.FormValue("action"))}
+			}
+		}
+		view.Raw = string(msg.Data)
+		if issues, err := store.CheckSchema(msg.Data); err != nil {
+			view.SchemaError = err.Error()
+		} else {
+			view.Issues = issues
+			var pretty bytes.Buffer
+			if json.Indent(&pretty, msg.Data, "", "  ") == nil {
+				view.Raw = pretty.String()
+			}
+		}
+		tmpl := template.Must(template.New("quarantined").Parse(fmt.Sprintf(base, quarantinedPage)))
+		tmpl.Execute(rw, view)
 		return nil
 	}
 }
 
+// quarantine returns app.db as a store.QuarantineIface.
+func quarantine() (store.QuarantineIface, error) {
+	q, ok := app.db.(store.QuarantineIface)
+	if !ok {
+		return nil, &StatusError{http.StatusNotImplemented, fmt.Errorf("error: the configured db has no quarantine")}
+	}
+	return q, nil
+}
+
+// localCache returns the in-memory cache behind app.cache.
+func localCache() store.CacheIface {
+	if rt, ok := app.cache.(*readthrough.ReadThrough); ok {
+		return rt.Cache()
+	}
+	return app.cache
+}
+
+var searchPage string = `
+		<h1>Search</h1>
+		<form method="GET" class="form-inline mb-3">
+			<select class="form-control mr-2" name="field">
+			{{range .Fields}}
+				<option{{if eq . $.Field}} selected{{end}}>{{ .}}</option>
+			{{end}}
+			</select>
+			<input class="form-control mr-2" type="text" name="value" placeholder="value" value="{{ .Value}}">
+			<input class="btn btn-primary" type="submit" value="Search">
+		</form>
+		{{if .Value}}
+		<table class="table table-sm table-hover">
+			<thead>
+				<tr>
+				<th scope="col">#</th>
+				<th scope="col">order_uid</th>
+				<th scope="col">track_number</th>
+				<th scope="col">customer_id</th>
+				<th scope="col">date_created</th>
+				</tr>
+			</thead>
+			<tbody>
+			{{range .Records}}
+				<tr>
+					<th scope="row"><a href="/data/{{ .ID}}">{{ .ID}}</a></th>
+					<td>{{ .Model.Order_uid}}</td>
+					<td>{{ .Model.Track_number}}</td>
+					<td>{{ .Model.Customer_id}}</td>
+					<td>{{ .Model.Date_created}}</td>
+				</tr>
+			{{else}}
+				<tr><td colspan="5">Nothing found</td></tr>
+			{{end}}
+			</tbody>
+		</table>
+		{{end}}`
+
+// GetSearchPageHandler finds cached orders by one of store.SearchFields.
+func GetSearchPageHandler() errorHandler {
+	return func(rw http.ResponseWriter, r *http.Request) error {
+		field, value := r.URL.Query().Get("field"), r.URL.Query().Get("value")
+		if field == "" {
+			field = store.SearchFields[0]
+		}
+		records, err := search(r, field, value)
+		if err != nil {
+			return err
+		}
+		data := struct {
+			Fields       []string
+			Field, Value string
+			Records      []store.Record
+		}{store.SearchFields, field, value, records}
+		tmpl := template.Must(template.New("search").Parse(fmt.Sprintf(base, searchPage)))
+		tmpl.Execute(rw, data)
+		return nil
+	}
+}
+
+// GetSearchJSONHandler is GetSearchPageHandler for API clients.
+func GetSearchJSONHandler() errorHandler {
+	return func(rw http.ResponseWriter, r *http.Request) error {
+		field, value := r.URL.Query().Get("field"), r.URL.Query().Get("value")
+		if value == "" {
+			return &StatusError{http.StatusBadRequest, fmt.Errorf("error: value is empty")}
+		}
+		records, err := search(r, field, value)
+		if err != nil {
+			return err
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(rw).Encode(listResponse{Orders: records})
+	}
+}
+
+// search looks orders up in the cache only. An empty value finds nothing.
+func search(r *http.Request, field, value string) ([]store.Record, error) {
+	s, ok := localCache().(store.SearchIface)
+	if !ok {
+		return nil, &StatusError{http.StatusNotImplemented, fmt.Errorf("error: the configured cache doesn't index orders")}
+	}
+	if value == "" {
+		return nil, nil
+	}
+	records, err := s.Search(r.Context(), field, value)
+	if err != nil {
+		return nil, &StatusError{http.StatusBadRequest, err}
+	}
+	return records, nil
+}
+
+// dataView is an order on the data page. History links to its versions
+// unless it's empty.
+type dataView struct {
+	*store.Model
+	History  string
+	Archived bool
+}
+
+func renderData(rw http.ResponseWriter, view dataView) {
+	tmpl := template.Must(template.New("data").Parse(fmt.Sprintf(base, dataPage)))
+	tmpl.Execute(rw, view)
+}
+
+var historyPage string = `
+		<h1>History</h1>
+		<a class="btn btn-secondary mb-3" href="/data/{{ .ID}}">Current</a>
+		{{range .Versions}}
+		<h4>Version {{ .Number}}</h4>
+		<p>
+			nats_seq: {{if .Nats_seq}}{{ .Nats_seq}}{{else}}-{{end}},
+			{{with .Replaced_at}}replaced at {{ .}}{{else}}current{{end}}
+		</p>
+		<table class="table table-sm">
+			<thead>
+				<tr>
+				<th scope="col">Key</th>
+				<th scope="col">Value</th>
+				</tr>
+			</thead>
+			<tbody>
+			{{range .Fields}}
+				<tr{{if .Changed}} class="table-warning"{{end}}>
+					<td>{{ .Key}}</td>
+					<td>{{ .Value}}</td>
+				</tr>
+			{{end}}
+			</tbody>
+		</table>
+		{{end}}`
+
+type historyField struct {
+	store.Field
+	Changed bool
+}
+
+type historyVersion struct {
+	Number      int
+	Nats_seq    uint64
+	Replaced_at *time.Time
+	Fields      []historyField
+}
+
+// GetHistoryPageHandler shows every version of an order, newest first, and
+// highlights the fields that changed since the previous version.
+func GetHistoryPageHandler() errorHandler {
+	return func(rw http.ResponseWriter, r *http.Request) error {
+		param := mux.Vars(r)["id"]
+		id, err := strconv.Atoi(param)
+		if err != nil {
+			return &StatusError{http.StatusBadRequest, fmt.Errorf("error: id is NaN")}
+		}
+		versions, err := app.db.History(r.Context(), id)
+		if err != nil {
+			return &StatusError{http.StatusBadRequest, err}
+		}
+		data := struct {
+			ID       int
+			Versions []historyVersion
+		}{id, make([]historyVersion, len(versions))}
+		for i, v := range versions {
+			changed := make(map[string]bool)
+			if i+1 < len(versions) {
+				for _, key := range store.Diff(versions[i+1].Model, v.Model) {
+					changed[key] = true
+				}
+			}
+			fields := store.Fields(v.Model)
+			data.Versions[i] = historyVersion{v.Version, v.Nats_seq, v.Replaced_at, make([]historyField, len(fields))}
+			for j, f := range fields {
+				data.Versions[i].Fields[j] = historyField{f, changed[f.Key]}
+			}
+		}
+		tmpl := template.Must(template.New("history").Parse(fmt.Sprintf(base, historyPage)))
+		tmpl.Execute(rw, data)
+		return nil
+	}
+}
+
+var ordersPage string = `
+		<h1>Orders</h1>
+		<form method="GET" class="form-inline mb-3">
+			<input class="form-control mr-2" type="text" name="customer_id" placeholder="customer_id" value="{{ .Filter.Customer_id}}">
+			<input class="form-control mr-2" type="text" name="delivery_service" placeholder="delivery_service" value="{{ .Filter.Delivery_service}}">
+			<input class="form-control mr-2" type="text" name="locale" placeholder="locale" value="{{ .Filter.Locale}}">
+			<input class="form-control mr-2" type="date" name="from" value="{{ .From}}">
+			<input class="form-control mr-2" type="date" name="to" value="{{ .To}}">
+			<input class="btn btn-primary" type="submit" value="Filter">
+		</form>
+		<table class="table table-sm table-hover">
+			<thead>
+				<tr>
+				<th scope="col">#</th>
+				<th scope="col">order_uid</th>
+				<th scope="col">date_created</th>
+				<th scope="col">customer_id</th>
+				<th scope="col">delivery_service</th>
+				<th scope="col">locale</th>
+				</tr>
+			</thead>
+			<tbody>
+			{{range .Page.Records}}
+				<tr>
+					<th scope="row"><a href="/data/{{ .ID}}">{{ .ID}}</a></th>
+					<td>{{ .Model.Order_uid}}</td>
+					<td>{{ .Model.Date_created}}</td>
+					<td>{{ .Model.Customer_id}}</td>
+					<td>{{ .Model.Delivery_service}}</td>
+					<td>{{ .Model.Locale}}</td>
+				</tr>
+			{{end}}
+			</tbody>
+		</table>
+		{{if .Next}}<a class="btn btn-secondary" href="{{ .Next}}">Next</a>{{end}}`
+
+type listResponse struct {
+	Orders     []store.Record `json:"orders"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// GetOrdersPageHandler..
+func GetOrdersPageHandler() errorHandler {
+	return func(rw http.ResponseWriter, r *http.Request) error {
+		f, err := parseListFilter(r)
+		if err != nil {
+			return &StatusError{http.StatusBadRequest, err}
+		}
+		page, err := app.db.List(r.Context(), f)
+		if err != nil {
+			return err
+		}
+		data := struct {
+			Filter   store.ListFilter
+			From, To string
+			Page     *store.Page
+			Next     string
+		}{Filter: f, From: r.FormValue("from"), To: r.FormValue("to"), Page: page}
+		if page.Next != nil {
+			q := r.URL.Query()
+			q.Set("cursor", page.Next.Encode())
+			data.Next = "/orders?" + q.Encode()
+		}
+		tmpl := template.Must(template.New("orders").Parse(fmt.Sprintf(base, ordersPage)))
+		tmpl.Execute(rw, data)
+		return nil
+	}
+}
+
+// GetOrdersJSONHandler..
+func GetOrdersJSONHandler() errorHandler {
+	return func(rw http.ResponseWriter, r *http.Request) error {
+		f, err := parseListFilter(r)
+		if err != nil {
+			return &StatusError{http.StatusBadRequest, err}
+		}
+		page, err := app.db.List(r.Context(), f)
+		if err != nil {
+			return err
+		}
+		res := listResponse{Orders: page.Records}
+		if page.Next != nil {
+			res.NextCursor = page.Next.Encode()
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(rw).Encode(res)
+	}
+}
+
+// parseListFilter reads the order listing filters from the query string.
+// Dates may be given as 2006-01-02 or RFC 3339. A date-only to includes
+// the whole day.
+func parseListFilter(r *http.Request) (store.ListFilter, error) {
+	q := r.URL.Query()
+	f := store.ListFilter{
+		Customer_id:      q.Get("customer_id"),
+		Delivery_service: q.Get("delivery_service"),
+		Locale:           q.Get("locale"),
+	}
+	var err error
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil {
+			return f, fmt.Errorf("error: limit is NaN")
+		}
+	}
+	if v := q.Get("from"); v != "" {
+		if f.From, err = parseDate(v, false); err != nil {
+			return f, err
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if f.To, err = parseDate(v, true); err != nil {
+			return f, err
+		}
+	}
+	if v := q.Get("cursor"); v != "" {
+		if f.After, err = store.DecodeCursor(v); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// parseDate parses s as 2006-01-02 or RFC 3339. With end a date-only s is
+// moved to the start of the next day, as the end of a [from, to) range.
+func parseDate(s string, end bool) (*time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return &t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return &t, nil
+	}
+	return nil, fmt.Errorf("error: bad date '%s'", s)
+}
+
 // GetHomePageHandler..
 func GetHomePageHandler() errorHandler {
 	return func(rw http.ResponseWriter, r *http.Request) error {
@@ -289,13 +946,26 @@ func GetHomePageHandler() errorHandler {
 			<label>ID:</label><br />
 			<input type="number" name="id"><br />
 			<input type="submit">
-		</form>`)))
+		</form>
+		<h1>Enter order_uid</h1>
+		<form method="POST">
+			<label>order_uid:</label><br />
+			<input type="text" name="uid"><br />
+			<input type="submit">
+		</form>
+		<a href="/orders">Browse orders</a>
+		<a href="/search">Search orders</a>`)))
 
 		if r.Method != http.MethodPost {
 			tmpl.Execute(rw, nil)
 			return nil
 		}
 
+		if uid := r.FormValue("uid"); uid != "" {
+			http.Redirect(rw, r, "/data/uid/"+url.PathEscape(uid), http.StatusFound)
+			return nil
+		}
+
 		_, err := strconv.Atoi(r.FormValue("id"))
 
 		if err != nil {
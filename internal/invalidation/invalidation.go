@@ -0,0 +1,56 @@
+// Package invalidation keeps the cache of an instance up to date with the
+// orders other instances write.
+package invalidation
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+)
+
+// DefaultRetry is how long Run waits before listening again after the
+// listener failed.
+const DefaultRetry = 5 * time.Second
+
+// Listener calls f with the id of every changed order until ctx is done or
+// it fails.
+type Listener interface {
+	Listen(ctx context.Context, f func(id int)) error
+}
+
+// Run refreshes cache with every order changed in db until ctx is done. A
+// failed listener is restarted after retry.
+func Run(ctx context.Context, listener Listener, db store.DBIface, cache store.CacheIface, retry time.Duration) {
+	for {
+		err := listener.Listen(ctx, func(id int) {
+			if err := Refresh(ctx, db, cache, id); err != nil {
+				log.Printf("[INVALIDATE] Error on order %d: %s\n", id, err.Error())
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[INVALIDATE] Listener Error: %s\n", err.Error())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+	}
+}
+
+// Refresh caches the current version of order id, or evicts it if it's gone
+// from db, e.g. archived.
+func Refresh(ctx context.Context, db store.DBIface, cache store.CacheIface, id int) error {
+	model, err := db.Get(ctx, id)
+	if errors.Is(err, store.Error404NotFound) || err == nil && model == nil {
+		return cache.Delete(ctx, id)
+	}
+	if err != nil {
+		return err
+	}
+	return cache.Set(ctx, &id, model)
+}
@@ -0,0 +1,84 @@
+package invalidation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/ineverbee/wbl0/internal/store/mapstore"
+	"github.com/stretchr/testify/require"
+)
+
+// dbMock has order 1, fails on order -10 and doesn't have others.
+type dbMock struct {
+	store.DBMock
+}
+
+func (db *dbMock) Get(ctx context.Context, id int) (*store.Model, error) {
+	switch id {
+	case 1:
+		return &store.Model{Order_uid: "b563feb7b2b84b6test", Locale: "ru"}, nil
+	case -10:
+		return nil, errors.New("error")
+	}
+	return nil, store.Error404NotFound
+}
+
+// listenerMock notifies ids once, then fails until ctx is done.
+type listenerMock struct {
+	ids   []int
+	calls int
+}
+
+func (l *listenerMock) Listen(ctx context.Context, f func(id int)) error {
+	l.calls++
+	for _, id := range l.ids {
+		f(id)
+	}
+	l.ids = nil
+	return errors.New("connection lost")
+}
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	cache := mapstore.NewMapStore(map[int]*store.Model{
+		1: {Order_uid: "b563feb7b2b84b6test"},
+		2: {Order_uid: "archived"},
+	})
+
+	// Testing 'Refresh' of a changed order, expecting the new version cached
+	require.NoError(t, Refresh(ctx, &dbMock{}, cache, 1))
+	res, err := cache.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "ru", res.Locale)
+
+	// Testing 'Refresh' of an order gone from db, expecting it evicted
+	require.NoError(t, Refresh(ctx, &dbMock{}, cache, 2))
+	_, err = cache.Get(ctx, 2)
+	require.Error(t, err)
+
+	// Testing 'Refresh' on a db error, expecting error
+	require.Error(t, Refresh(ctx, &dbMock{}, cache, -10))
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cache := mapstore.NewMapStore(make(map[int]*store.Model))
+	listener := &listenerMock{ids: []int{1, -10}}
+
+	// Testing 'Run', expecting notified orders cached and the listener restarted
+	done := make(chan struct{})
+	go func() {
+		Run(ctx, listener, &dbMock{}, cache, time.Millisecond)
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		_, err := cache.Get(ctx, 1)
+		return err == nil
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-done
+	require.Greater(t, listener.calls, 1)
+}
@@ -0,0 +1,243 @@
+// Package reconcile finds and repairs drift between the cache and the db.
+package reconcile
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+)
+
+const DefaultChunk = 1000
+
+type Config struct {
+	Interval time.Duration
+	// Chunk is how many orders are compared at once.
+	Chunk int
+	// OnlyCached skips orders missing from the cache, for bounded caches
+	// that evict orders on purpose.
+	OnlyCached bool
+}
+
+// Report counts what a reconciliation found. Every counted entry has been
+// repaired unless it failed, see Errors.
+type Report struct {
+	Started time.Time     `json:"started"`
+	Took    time.Duration `json:"took"`
+	Checked int           `json:"checked"`
+	// Missing orders are in the db but not in the cache.
+	Missing int `json:"missing"`
+	// Stale orders differ between the db and the cache.
+	Stale int `json:"stale"`
+	// Extra orders are in the cache but not in the db.
+	Extra  int `json:"extra"`
+	Errors int `json:"errors"`
+}
+
+// Lister is a cache that can list its ids, so that orders gone from the db
+// can be evicted.
+type Lister interface {
+	IDs() []int
+}
+
+// peeker is a cache that can be read without counting as a use.
+type peeker interface {
+	Peek(int) (*store.Model, bool)
+}
+
+// Reconciler compares the orders of db and cache page by page. Differences
+// found in a page are confirmed with a fresh read of the order before they
+// are repaired, as the worker keeps writing meanwhile.
+type Reconciler struct {
+	db    store.DBIface
+	cache store.CacheIface
+	cfg   Config
+
+	running sync.Mutex
+	mu      sync.Mutex
+	last    *Report
+}
+
+func NewReconciler(db store.DBIface, cache store.CacheIface, cfg Config) *Reconciler {
+	if cfg.Chunk < 1 {
+		cfg.Chunk = DefaultChunk
+	}
+	return &Reconciler{db: db, cache: cache, cfg: cfg}
+}
+
+// Run reconciles every cfg.Interval until ctx is done.
+func (rc *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(rc.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := rc.Once(ctx); err != nil {
+			log.Printf("[RECONCILE] Error: %s\n", err.Error())
+		}
+	}
+}
+
+// Last returns the report of the last finished reconciliation, or nil.
+func (rc *Reconciler) Last() *Report {
+	defer rc.mu.Unlock()
+	rc.mu.Lock()
+	return rc.last
+}
+
+// Once reconciles the cache with the db. Concurrent calls wait for each
+// other.
+func (rc *Reconciler) Once(ctx context.Context) (Report, error) {
+	rc.running.Lock()
+	defer rc.running.Unlock()
+	report := Report{Started: time.Now()}
+	var cached []int
+	if l, ok := rc.cache.(Lister); ok {
+		cached = l.IDs()
+	}
+	after := 0
+	err := rc.db.Stream(ctx, 0, rc.cfg.Chunk, func(records []store.Record) error {
+		last := records[len(records)-1].ID
+		inDB := make(map[int]bool, len(records))
+		for _, r := range records {
+			inDB[r.ID] = true
+			rc.check(ctx, &report, r)
+		}
+		cached = rc.evictExtra(ctx, &report, cached, last, inDB)
+		after = last
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	rc.evictExtra(ctx, &report, cached, math.MaxInt, nil)
+	report.Took = time.Since(report.Started)
+	rc.mu.Lock()
+	rc.last = &report
+	rc.mu.Unlock()
+	if report.Missing+report.Stale+report.Extra+report.Errors > 0 {
+		log.Printf("[RECONCILE] %d orders checked up to id %d: %d missing, %d stale, %d extra, %d errors\n",
+			report.Checked, after, report.Missing, report.Stale, report.Extra, report.Errors)
+	}
+	return report, nil
+}
+
+// check compares r with its cached version.
+func (rc *Reconciler) check(ctx context.Context, report *Report, r store.Record) {
+	report.Checked++
+	cached, ok := rc.get(ctx, r.ID)
+	if !ok && rc.cfg.OnlyCached {
+		return
+	}
+	if ok && Hash(cached) == Hash(r.Model) {
+		return
+	}
+	// Confirm with the current version, the page may be outdated.
+	current, err := rc.db.Get(ctx, r.ID)
+	if errors.Is(err, store.Error404NotFound) || err == nil && current == nil {
+		return
+	}
+	if err != nil {
+		report.Errors++
+		log.Printf("[RECONCILE] Error on order %d: %s\n", r.ID, err.Error())
+		return
+	}
+	if cached, ok = rc.get(ctx, r.ID); ok && Hash(cached) == Hash(current) {
+		return
+	}
+	if ok {
+		report.Stale++
+		err = rc.swap(ctx, r.ID, cached, current)
+	} else {
+		report.Missing++
+		err = rc.add(ctx, r.ID, current)
+	}
+	if err != nil {
+		report.Errors++
+		log.Printf("[RECONCILE] Cache Error on order %d: %s\n", r.ID, err.Error())
+	}
+}
+
+// swap replaces the stale cached order with current unless the worker cached
+// another version meanwhile. Caches that can't swap get the order deleted,
+// to be read through again.
+func (rc *Reconciler) swap(ctx context.Context, id int, cached, current *store.Model) error {
+	if s, ok := rc.cache.(store.SwapperIface); ok {
+		_, err := s.Swap(ctx, id, cached, current)
+		return err
+	}
+	return rc.cache.Delete(ctx, id)
+}
+
+// add caches the missing order current unless the worker cached a version
+// meanwhile.
+func (rc *Reconciler) add(ctx context.Context, id int, current *store.Model) error {
+	if a, ok := rc.cache.(store.AdderIface); ok {
+		_, err := a.Add(ctx, id, current)
+		return err
+	}
+	return rc.cache.Set(ctx, &id, current)
+}
+
+// evictExtra evicts the cached ids up to last that aren't inDB and confirmed
+// gone from the db. It returns the cached ids after last.
+func (rc *Reconciler) evictExtra(ctx context.Context, report *Report, cached []int, last int, inDB map[int]bool) []int {
+	n := sort.Search(len(cached), func(i int) bool { return cached[i] > last })
+	for _, id := range cached[:n] {
+		if inDB[id] {
+			continue
+		}
+		_, err := rc.db.Get(ctx, id)
+		if err == nil {
+			// Written after the page was read.
+			continue
+		}
+		if !errors.Is(err, store.Error404NotFound) {
+			report.Errors++
+			log.Printf("[RECONCILE] Error on order %d: %s\n", id, err.Error())
+			continue
+		}
+		report.Extra++
+		if err = rc.cache.Delete(ctx, id); err != nil {
+			report.Errors++
+			log.Printf("[RECONCILE] Cache Error on order %d: %s\n", id, err.Error())
+		}
+	}
+	return cached[n:]
+}
+
+func (rc *Reconciler) get(ctx context.Context, id int) (*store.Model, bool) {
+	if p, ok := rc.cache.(peeker); ok {
+		return p.Peek(id)
+	}
+	model, err := rc.cache.Get(ctx, id)
+	return model, err == nil && model != nil
+}
+
+// Hash is the content hash of an order as the db stores it: date_created is
+// compared in UTC with microsecond precision.
+func Hash(m *store.Model) [sha256.Size]byte {
+	if m != nil && m.Date_created != nil {
+		stored := *m
+		date := m.Date_created.UTC().Truncate(time.Microsecond)
+		stored.Date_created = &date
+		m = &stored
+	}
+	h := sha256.New()
+	for _, f := range store.Fields(m) {
+		fmt.Fprintf(h, "%s=%s\n", f.Key, f.Value)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
@@ -0,0 +1,142 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/ineverbee/wbl0/internal/store/lru"
+	"github.com/ineverbee/wbl0/internal/store/mapstore"
+	"github.com/ineverbee/wbl0/internal/store/sqlite"
+	"github.com/stretchr/testify/require"
+)
+
+// withOrders opens a db with orders 1 to n.
+func withOrders(t *testing.T, n int) *sqlite.SQLiteStore {
+	db, err := sqlite.Open(context.Background(), ":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	for i := 1; i <= n; i++ {
+		id := -1
+		require.NoError(t, db.Set(context.Background(), &id, order(i)))
+	}
+	return db
+}
+
+func order(i int) *store.Model {
+	return &store.Model{Order_uid: fmt.Sprintf("uid%d", i), Items: []*store.Item{}}
+}
+
+func TestOnce(t *testing.T) {
+	ctx := context.Background()
+	db := withOrders(t, 5)
+	stale := order(2)
+	stale.Locale = "ru"
+	cache := mapstore.NewMapStore(map[int]*store.Model{
+		1:  order(1),
+		2:  stale,
+		4:  order(4),
+		5:  order(5),
+		99: {Order_uid: "gone"},
+	})
+	rc := NewReconciler(db, cache, Config{Chunk: 2})
+	require.Nil(t, rc.Last())
+
+	// Testing 'Once', expecting missing, stale and extra orders repaired
+	report, err := rc.Once(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 5, report.Checked)
+	require.Equal(t, 1, report.Missing)
+	require.Equal(t, 1, report.Stale)
+	require.Equal(t, 1, report.Extra)
+	require.Zero(t, report.Errors)
+	require.Equal(t, []int{1, 2, 3, 4, 5}, cache.IDs())
+	res, err := cache.Get(ctx, 2)
+	require.NoError(t, err)
+	require.Empty(t, res.Locale)
+	require.Equal(t, &report, rc.Last())
+
+	// Testing 'Once' again, expecting nothing to repair
+	report, err = rc.Once(ctx)
+	require.NoError(t, err)
+	require.Equal(t, Report{Started: report.Started, Took: report.Took, Checked: 5}, report)
+}
+
+// racingCache caches newer before the repair of the order it's confirmed
+// stale or missing, like a worker write between the check and the repair.
+type racingCache struct {
+	*mapstore.MapStore
+	id    int
+	newer *store.Model
+	gets  int
+}
+
+func (c *racingCache) Get(ctx context.Context, id int) (*store.Model, error) {
+	res, err := c.MapStore.Get(ctx, id)
+	if id == c.id {
+		if c.gets++; c.gets == 2 {
+			c.MapStore.Set(ctx, &id, c.newer)
+		}
+	}
+	return res, err
+}
+
+func TestOnceRace(t *testing.T) {
+	ctx := context.Background()
+	db := withOrders(t, 2)
+	newer := order(2)
+	newer.Locale = "en"
+
+	// Testing 'Once' with a newer order cached before a stale one is
+	// repaired, expecting the newer order kept
+	stale := order(1)
+	stale.Locale = "ru"
+	cache := &racingCache{MapStore: mapstore.NewMapStore(map[int]*store.Model{1: stale, 2: order(2)}), id: 1, newer: newer}
+	report, err := NewReconciler(db, cache, Config{}).Once(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Stale)
+	res, err := cache.MapStore.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, newer, res)
+
+	// Testing 'Once' with a newer order cached before a missing one is
+	// repaired, expecting the newer order kept
+	cache = &racingCache{MapStore: mapstore.NewMapStore(map[int]*store.Model{1: order(1)}), id: 2, newer: newer}
+	report, err = NewReconciler(db, cache, Config{}).Once(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Missing)
+	res, err = cache.MapStore.Get(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, newer, res)
+}
+
+func TestOnceOnlyCached(t *testing.T) {
+	ctx := context.Background()
+	db := withOrders(t, 3)
+	cache := lru.NewLRUStore(2, 0)
+	id, stale := 3, &store.Model{Order_uid: "uid3", Locale: "ru"}
+	require.NoError(t, cache.Set(ctx, &id, stale))
+	rc := NewReconciler(db, cache, Config{OnlyCached: true})
+
+	// Testing 'Once' with a bounded cache, expecting evicted orders left out
+	report, err := rc.Once(ctx)
+	require.NoError(t, err)
+	require.Zero(t, report.Missing)
+	require.Equal(t, 1, report.Stale)
+	require.Equal(t, []int{3}, cache.IDs())
+	require.Zero(t, cache.Stats().Hits)
+}
+
+func TestHash(t *testing.T) {
+	date := time.Date(2021, 11, 26, 9, 22, 19, 1500, time.FixedZone("MSK", 3*60*60))
+	stored := time.Date(2021, 11, 26, 6, 22, 19, 1000, time.UTC)
+
+	// Testing 'Hash', expecting dates compared as stored
+	require.Equal(t, Hash(&store.Model{Order_uid: "uid", Date_created: &date}), Hash(&store.Model{Order_uid: "uid", Date_created: &stored}))
+	require.Equal(t, time.Date(2021, 11, 26, 9, 22, 19, 1500, time.FixedZone("MSK", 3*60*60)), date)
+
+	// Testing 'Hash', expecting different orders to differ
+	require.NotEqual(t, Hash(order(1)), Hash(order(2)))
+}
@@ -0,0 +1,94 @@
+// Package retention periodically archives old orders and evicts them from the cache.
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+)
+
+const DefaultBatch = 1000
+
+// Archiver moves orders created before cutoff out of the main tables and
+// returns their ids. In dry-run mode it only returns the ids of all orders
+// it would move.
+type Archiver interface {
+	Archive(ctx context.Context, cutoff time.Time, limit int, dryRun bool) ([]int, error)
+}
+
+type Config struct {
+	// MaxAge is how long after date_created an order is kept.
+	MaxAge   time.Duration
+	Interval time.Duration
+	Batch    int
+	DryRun   bool
+}
+
+// Run archives old orders every cfg.Interval until ctx is done.
+func Run(ctx context.Context, archiver Archiver, cache store.CacheIface, cfg Config) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := Once(ctx, archiver, cache, cfg, time.Now()); err != nil {
+			log.Printf("[RETENTION] Error: %s\n", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Once archives the orders older than cfg.MaxAge at now in batches of
+// cfg.Batch and evicts them from cache. It returns how many orders were
+// archived, or would be in dry-run mode.
+func Once(ctx context.Context, archiver Archiver, cache store.CacheIface, cfg Config, now time.Time) (int, error) {
+	cutoff := now.Add(-cfg.MaxAge)
+	if cfg.DryRun {
+		ids, err := archiver.Archive(ctx, cutoff, 0, true)
+		if err != nil {
+			return 0, err
+		}
+		log.Printf("[RETENTION] Dry run: %d orders created before %s would be archived %s\n",
+			len(ids), cutoff.Format(time.RFC3339), preview(ids))
+		return len(ids), nil
+	}
+
+	batch := cfg.Batch
+	if batch < 1 {
+		batch = DefaultBatch
+	}
+	total := 0
+	for {
+		ids, err := archiver.Archive(ctx, cutoff, batch, false)
+		if err != nil {
+			return total, err
+		}
+		for _, id := range ids {
+			if err = cache.Delete(ctx, id); err != nil {
+				log.Printf("[RETENTION] Cache Error: %s\n", err.Error())
+			}
+		}
+		total += len(ids)
+		if len(ids) < batch {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("[RETENTION] Archived %d orders created before %s\n", total, cutoff.Format(time.RFC3339))
+	}
+	return total, nil
+}
+
+// preview formats the first ids for the dry-run report.
+func preview(ids []int) string {
+	const max = 10
+	if len(ids) > max {
+		return fmt.Sprintf("%v...", ids[:max])
+	}
+	return fmt.Sprint(ids)
+}
@@ -0,0 +1,79 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/ineverbee/wbl0/internal/store/mapstore"
+	"github.com/stretchr/testify/require"
+)
+
+type ArchiverMock struct {
+	batches [][]int
+	cutoffs []time.Time
+	dryRuns int
+}
+
+func (am *ArchiverMock) Archive(ctx context.Context, cutoff time.Time, limit int, dryRun bool) ([]int, error) {
+	am.cutoffs = append(am.cutoffs, cutoff)
+	if dryRun {
+		am.dryRuns++
+		return []int{1, 2, 3}, nil
+	}
+	if len(am.batches) == 0 {
+		return nil, fmt.Errorf("error")
+	}
+	ids := am.batches[0]
+	am.batches = am.batches[1:]
+	return ids, nil
+}
+
+func TestOnce(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2022, 1, 31, 0, 0, 0, 0, time.UTC)
+	cfg := Config{MaxAge: 30 * 24 * time.Hour, Batch: 2}
+	cache := mapstore.NewMapStore(map[int]*store.Model{
+		1: {Order_uid: "first"},
+		2: {Order_uid: "second"},
+		3: {Order_uid: "third"},
+		4: {Order_uid: "fourth"},
+	})
+
+	// Testing 'Once', expecting batches until a short one and evicted orders
+	am := &ArchiverMock{batches: [][]int{{1, 2}, {3}}}
+	n, err := Once(ctx, am, cache, cfg, now)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.Equal(t, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), am.cutoffs[0])
+	for _, id := range []int{1, 2, 3} {
+		_, err = cache.Get(ctx, id)
+		require.Error(t, err)
+	}
+	_, err = cache.Get(ctx, 4)
+	require.NoError(t, err)
+
+	// Testing 'Once', expecting the archiver's error
+	am = &ArchiverMock{batches: [][]int{{4, 5}}}
+	n, err = Once(ctx, am, cache, cfg, now)
+	require.Error(t, err)
+	require.Equal(t, 2, n)
+
+	// Testing 'Once' in dry-run mode, expecting nothing evicted
+	cache = mapstore.NewMapStore(map[int]*store.Model{1: {Order_uid: "first"}})
+	cfg.DryRun = true
+	am = &ArchiverMock{}
+	n, err = Once(ctx, am, cache, cfg, now)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.Equal(t, 1, am.dryRuns)
+	_, err = cache.Get(ctx, 1)
+	require.NoError(t, err)
+}
+
+func TestPreview(t *testing.T) {
+	require.Equal(t, "[1 2]", preview([]int{1, 2}))
+	require.Equal(t, "[0 1 2 3 4 5 6 7 8 9]...", preview([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}))
+}
@@ -0,0 +1,49 @@
+// Package retry retries transient failures with backoff and stops calling a
+// failing dependency with a circuit breaker.
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Backoff doubles the delay between attempts from Base up to Max. Attempts
+// counts the first try, so 1 means no retries.
+type Backoff struct {
+	Attempts int
+	Base     time.Duration
+	Max      time.Duration
+}
+
+var DefaultBackoff = Backoff{Attempts: 5, Base: 100 * time.Millisecond, Max: 5 * time.Second}
+
+// Delay returns how long to wait before retry n, starting at 1. The delay is
+// jittered between half and all of the exponential delay, so that retries
+// of many callers spread out.
+func (b Backoff) Delay(n int) time.Duration {
+	d := b.Base
+	for i := 1; i < n && d < b.Max; i++ {
+		d *= 2
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Sleep waits for the delay of retry n. It returns false if ctx is done
+// first.
+func (b Backoff) Sleep(ctx context.Context, n int) bool {
+	timer := time.NewTimer(b.Delay(n))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
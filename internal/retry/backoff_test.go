@@ -0,0 +1,38 @@
+package retry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Attempts: 5, Base: 100 * time.Millisecond, Max: time.Second}
+
+	// Testing 'Delay', expecting jittered doubling delays up to Max
+	for n, max := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+		4: 800 * time.Millisecond,
+		5: time.Second,
+		9: time.Second,
+	} {
+		for i := 0; i < 100; i++ {
+			d := b.Delay(n)
+			require.GreaterOrEqual(t, d, max/2)
+			require.LessOrEqual(t, d, max)
+		}
+	}
+
+	// Testing 'Delay' without a base, expecting no delay
+	require.Zero(t, Backoff{}.Delay(3))
+
+	// Testing 'Sleep', expecting false once ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.False(t, Backoff{Base: time.Hour, Max: time.Hour}.Sleep(ctx, 1))
+	require.True(t, Backoff{Base: time.Millisecond}.Sleep(context.Background(), 1))
+}
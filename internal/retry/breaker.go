@@ -0,0 +1,191 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	// Closed lets every call through.
+	Closed State = iota
+	// Open lets no call through until the cooldown is over.
+	Open
+	// HalfOpen lets one trial call through, which closes the breaker if it
+	// succeeds and opens it again if it fails.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *State) UnmarshalText(text []byte) error {
+	for _, state := range []State{Closed, Open, HalfOpen} {
+		if string(text) == state.String() {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("error: unknown breaker state '%s'", text)
+}
+
+// Status is a snapshot of a Breaker.
+type Status struct {
+	Name  string `json:"name"`
+	State State  `json:"state"`
+	// Failures is the number of failures in a row.
+	Failures int       `json:"failures"`
+	Since    time.Time `json:"since"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Breaker opens after a number of failures in a row, and stays open for a
+// cooldown before it lets a trial call through.
+type Breaker struct {
+	name     string
+	failures int
+	cooldown time.Duration
+
+	mu      sync.Mutex
+	state   State
+	fails   int
+	since   time.Time
+	lastErr error
+	trial   bool
+	// changed is closed and replaced on every state change.
+	changed chan struct{}
+}
+
+// NewBreaker returns a closed breaker that opens after failures in a row and
+// stays open for cooldown. name is used in logs and in Status.
+func NewBreaker(name string, failures int, cooldown time.Duration) *Breaker {
+	if failures < 1 {
+		failures = 1
+	}
+	return &Breaker{
+		name:     name,
+		failures: failures,
+		cooldown: cooldown,
+		since:    time.Now(),
+		changed:  make(chan struct{}),
+	}
+}
+
+// Wait blocks while the breaker is open or a trial call is running, until
+// ctx is done. Every Wait that returns nil must be followed by a Record of
+// the outcome of the call, or by Cancel if there was none.
+func (b *Breaker) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		left := time.Duration(0)
+		switch b.state {
+		case Closed:
+			b.mu.Unlock()
+			return nil
+		case Open:
+			if left = time.Until(b.since.Add(b.cooldown)); left > 0 {
+				break
+			}
+			b.set(HalfOpen)
+			fallthrough
+		case HalfOpen:
+			if !b.trial {
+				b.trial = true
+				b.mu.Unlock()
+				return nil
+			}
+		}
+		changed := b.changed
+		b.mu.Unlock()
+		if err := sleep(ctx, changed, left); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep waits until changed is closed or, if d is positive, d is over.
+func sleep(ctx context.Context, changed <-chan struct{}, d time.Duration) error {
+	var timeout <-chan time.Time
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-changed:
+	case <-timeout:
+	}
+	return nil
+}
+
+// Cancel gives up a call let through by Wait that wasn't made, so that its
+// outcome tells nothing.
+func (b *Breaker) Cancel() {
+	defer b.mu.Unlock()
+	b.mu.Lock()
+	if b.trial {
+		b.trial = false
+		b.notify()
+	}
+}
+
+// Record reports the outcome of a call let through by Wait. Only failures
+// that tell the dependency is down should be recorded as errors.
+func (b *Breaker) Record(err error) {
+	defer b.mu.Unlock()
+	b.mu.Lock()
+	b.trial = false
+	if err == nil {
+		b.fails, b.lastErr = 0, nil
+		if b.state != Closed {
+			b.set(Closed)
+			log.Printf("[BREAKER] %s closed\n", b.name)
+		}
+		return
+	}
+	b.fails++
+	b.lastErr = err
+	if b.state == HalfOpen || b.state == Closed && b.fails >= b.failures {
+		b.set(Open)
+		log.Printf("[BREAKER] %s open for %s after %d failures: %s\n", b.name, b.cooldown, b.fails, err.Error())
+	}
+}
+
+// Status returns the current state of the breaker.
+func (b *Breaker) Status() Status {
+	defer b.mu.Unlock()
+	b.mu.Lock()
+	s := Status{Name: b.name, State: b.state, Failures: b.fails, Since: b.since}
+	if b.lastErr != nil {
+		s.Error = b.lastErr.Error()
+	}
+	return s
+}
+
+func (b *Breaker) set(state State) {
+	b.state, b.since = state, time.Now()
+	b.notify()
+}
+
+// notify wakes up the callers waiting in Wait.
+func (b *Breaker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
@@ -0,0 +1,86 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	ctx := context.Background()
+	b := NewBreaker("db", 2, 50*time.Millisecond)
+	down := errors.New("connection refused")
+
+	// Testing 'Record' with fewer failures than the limit, expecting closed
+	require.NoError(t, b.Wait(ctx))
+	b.Record(down)
+	require.Equal(t, Closed, b.Status().State)
+	require.NoError(t, b.Wait(ctx))
+	b.Record(nil)
+	require.Equal(t, 0, b.Status().Failures)
+
+	// Testing 'Record' with failures in a row, expecting open
+	for i := 0; i < 2; i++ {
+		require.NoError(t, b.Wait(ctx))
+		b.Record(down)
+	}
+	status := b.Status()
+	require.Equal(t, Open, status.State)
+	require.Equal(t, 2, status.Failures)
+	require.Equal(t, "connection refused", status.Error)
+
+	// Testing 'Wait' while open, expecting it to block until ctx is done
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, b.Wait(short), context.DeadlineExceeded)
+
+	// Testing 'Wait' after the cooldown, expecting one trial let through
+	start := time.Now()
+	require.NoError(t, b.Wait(ctx))
+	require.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+	require.Equal(t, HalfOpen, b.Status().State)
+	waited := make(chan error)
+	go func() { waited <- b.Wait(ctx) }()
+	select {
+	case <-waited:
+		t.Fatal("a second trial was let through")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Testing a failed trial, expecting open again
+	b.Record(down)
+	require.Equal(t, Open, b.Status().State)
+
+	// Testing a successful trial, expecting closed and the waiter let through
+	require.NoError(t, <-waited)
+	require.Equal(t, HalfOpen, b.Status().State)
+	b.Record(nil)
+	require.Equal(t, Closed, b.Status().State)
+
+	// Testing 'Cancel' of a trial, expecting another one let through
+	for i := 0; i < 2; i++ {
+		require.NoError(t, b.Wait(ctx))
+		b.Record(down)
+	}
+	require.NoError(t, b.Wait(ctx))
+	go func() { waited <- b.Wait(ctx) }()
+	b.Cancel()
+	require.NoError(t, <-waited)
+	require.Equal(t, HalfOpen, b.Status().State)
+}
+
+func TestState(t *testing.T) {
+	// Testing 'MarshalText' and 'UnmarshalText', expecting the same state
+	for _, s := range []State{Closed, Open, HalfOpen} {
+		text, err := s.MarshalText()
+		require.NoError(t, err)
+		var got State
+		require.NoError(t, got.UnmarshalText(text))
+		require.Equal(t, s, got)
+	}
+	var s State
+	require.Error(t, s.UnmarshalText([]byte("ajar")))
+}
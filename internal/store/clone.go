@@ -0,0 +1,32 @@
+package store
+
+// Clone returns a deep copy of m, so that the copy can be changed without
+// changing m. Clone of nil is nil.
+func (m *Model) Clone() *Model {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	if m.Date_created != nil {
+		date := *m.Date_created
+		c.Date_created = &date
+	}
+	if m.Delivery != nil {
+		delivery := *m.Delivery
+		c.Delivery = &delivery
+	}
+	if m.Payment != nil {
+		payment := *m.Payment
+		c.Payment = &payment
+	}
+	if m.Items != nil {
+		c.Items = make([]*Item, len(m.Items))
+		for i, item := range m.Items {
+			if item != nil {
+				copied := *item
+				c.Items[i] = &copied
+			}
+		}
+	}
+	return &c
+}
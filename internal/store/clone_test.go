@@ -0,0 +1,36 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClone(t *testing.T) {
+	b, err := os.ReadFile("../../model.json")
+	require.NoError(t, err)
+	m := new(Model)
+	require.NoError(t, json.Unmarshal(b, m))
+	m.Items = append(m.Items, nil)
+
+	// Testing 'Clone', expecting an equal model
+	c := m.Clone()
+	require.Equal(t, m, c)
+
+	// Testing 'Clone', expecting the copy not to share anything with m
+	*c.Date_created = c.Date_created.AddDate(1, 0, 0)
+	c.Delivery.Name = "changed"
+	c.Payment.Amount++
+	c.Items[0].Rid = "changed"
+	c.Items[1] = &Item{}
+	require.NotEqual(t, m.Date_created, c.Date_created)
+	require.NotEqual(t, "changed", m.Delivery.Name)
+	require.NotEqual(t, c.Payment.Amount, m.Payment.Amount)
+	require.NotEqual(t, "changed", m.Items[0].Rid)
+	require.Nil(t, m.Items[1])
+
+	// Testing 'Clone' of nil, expecting nil
+	require.Nil(t, (*Model)(nil).Clone())
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	archiveColumns = "id,order_uid,track_number,entry,delivery,payment,items,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard,version,nats_seq"
+
+	// Orders locked by a concurrent write are skipped and archived next time.
+	ArchiveCandidatesQuery = "SELECT id FROM %s WHERE date_created < $1 ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED"
+	ArchiveQuery           = `
+WITH moved AS (
+DELETE FROM wb_data WHERE id IN (` + fmt.Sprintf(ArchiveCandidatesQuery, "wb_data") + `)
+RETURNING ` + archiveColumns + `
+)
+INSERT INTO order_archive (` + archiveColumns + `)
+SELECT ` + archiveColumns + ` FROM moved
+RETURNING id`
+	// Children are deleted by ON DELETE CASCADE at the end of the statement,
+	// so the subqueries still see them.
+	NormalizedArchiveQuery = `
+WITH moved AS (
+DELETE FROM orders WHERE id IN (` + fmt.Sprintf(ArchiveCandidatesQuery, "orders") + `)
+RETURNING id,order_uid,track_number,entry,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard,version,nats_seq
+)
+INSERT INTO order_archive (` + archiveColumns + `)
+SELECT m.id,m.order_uid,m.track_number,m.entry,
+(SELECT to_jsonb(d) - 'order_id' FROM deliveries d WHERE d.order_id=m.id),
+(SELECT to_jsonb(p) - 'order_id' FROM payments p WHERE p.order_id=m.id),
+COALESCE((SELECT jsonb_agg(to_jsonb(i) - 'order_id' - 'position' ORDER BY i.position) FROM order_items i WHERE i.order_id=m.id), '[]'),
+m.locale,m.internal_signature,m.customer_id,m.delivery_service,m.shardkey,m.sm_id,m.date_created,m.oof_shard,m.version,m.nats_seq
+FROM moved m
+RETURNING id`
+	ArchiveDryRunQuery = "SELECT id FROM %s WHERE date_created < $1 ORDER BY id"
+	GetArchivedQuery   = "SELECT " + JSONColumns + " FROM order_archive WHERE id=$1"
+)
+
+// Archive moves up to limit orders created before cutoff to order_archive
+// and returns their ids. With dryRun nothing is moved and the ids of all
+// orders that would be archived are returned, limit is ignored.
+func (db *DBStore) Archive(ctx context.Context, cutoff time.Time, limit int, dryRun bool) ([]int, error) {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Archive)
+	defer cancel()
+	query, args := ArchiveQuery, []interface{}{cutoff, limit}
+	if dryRun {
+		query, args = fmt.Sprintf(ArchiveDryRunQuery, db.table()), args[:1]
+	} else if db.mode == ModeNormalized {
+		query = NormalizedArchiveQuery
+	}
+	ids := make([]int, 0)
+	err := queryEach(ctx, db.connPool, func(rows pgx.Rows) error {
+		id := 0
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		return nil
+	}, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetArchived returns an order from order_archive.
+func (db *DBStore) GetArchived(ctx context.Context, id int) (*store.Model, error) {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Get)
+	defer cancel()
+	ids, models, err := selectJSON(ctx, db.connPool, GetArchivedQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, Error404NotFound
+	}
+	return models[ids[0]], nil
+}
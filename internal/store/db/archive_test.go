@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBStoreArchive(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Errorf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	ctx := context.Background()
+	cutoff := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	dbStore := &DBStore{connPool: mock}
+
+	// Testing 'Archive', expecting the ids of the moved orders
+	mock.ExpectQuery("WITH moved AS \\(\\s*DELETE FROM wb_data (.+) INSERT INTO order_archive").
+		WithArgs(cutoff, 2).WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(1).AddRow(3))
+	ids, err := dbStore.Archive(ctx, cutoff, 2, false)
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 3}, ids)
+
+	// Testing 'Archive' in dry-run mode, expecting a plain select
+	mock.ExpectQuery("SELECT id FROM wb_data WHERE date_created < \\$1 ORDER BY id$").
+		WithArgs(cutoff).WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(1))
+	ids, err = dbStore.Archive(ctx, cutoff, 2, true)
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, ids)
+
+	// Testing 'Archive' in normalized mode, expecting children folded into JSON
+	dbStore.mode = ModeNormalized
+	mock.ExpectQuery("DELETE FROM orders (.+) jsonb_agg").
+		WithArgs(cutoff, 2).WillReturnError(pgx.ErrTxClosed)
+	ids, err = dbStore.Archive(ctx, cutoff, 2, false)
+	require.Nil(t, ids)
+	require.ErrorIs(t, err, pgx.ErrTxClosed)
+
+	// Testing 'GetArchived', not expecting any error, expecting 1 order
+	mock.ExpectQuery("SELECT (.+) FROM order_archive WHERE id").WithArgs(1).WillReturnRows(jsonRows(1, exampleModel))
+	res, err := dbStore.GetArchived(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, exampleModel, res)
+
+	// Testing 'GetArchived', expecting Error404NotFound error
+	mock.ExpectQuery("SELECT (.+) FROM order_archive WHERE id").WithArgs(2).WillReturnRows(pgxmock.NewRows([]string{"id"}))
+	res, err = dbStore.GetArchived(ctx, 2)
+	require.Nil(t, res)
+	require.ErrorIs(t, err, Error404NotFound)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// backfillLockID is the pg_advisory_xact_lock key that serializes backfills
+// of instances started together.
+const backfillLockID int64 = 0x77626c31
+
+var (
+	// The sequence is moved past every id of both tables, so that orders
+	// whose wb_data id is taken in orders get an id no other order has.
+	BackfillSequenceQuery = `
+SELECT setval(pg_get_serial_sequence('orders', 'id'),
+GREATEST((SELECT MAX(id) FROM orders), (SELECT MAX(id) FROM wb_data), 1))`
+	BackfillHistoryQuery = `
+INSERT INTO order_history (order_id,version,nats_seq,order_uid,track_number,entry,delivery,payment,items,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard)
+SELECT o.id,o.version,o.nats_seq,o.order_uid,o.track_number,o.entry,
+(SELECT to_jsonb(d) - 'order_id' FROM deliveries d WHERE d.order_id=o.id),
+(SELECT to_jsonb(p) - 'order_id' FROM payments p WHERE p.order_id=o.id),
+COALESCE((SELECT jsonb_agg(to_jsonb(i) - 'order_id' - 'position' ORDER BY i.position) FROM order_items i WHERE i.order_id=o.id), '[]'),
+o.locale,o.internal_signature,o.customer_id,o.delivery_service,o.shardkey,o.sm_id,o.date_created,o.oof_shard
+FROM orders o JOIN wb_data w ON w.order_uid=o.order_uid
+WHERE w.version > o.version
+ON CONFLICT (order_id,version) DO NOTHING`
+	BackfillUpdateQuery = `
+UPDATE orders o SET
+track_number=w.track_number,entry=w.entry,locale=w.locale,internal_signature=w.internal_signature,
+customer_id=w.customer_id,delivery_service=w.delivery_service,shardkey=w.shardkey,sm_id=w.sm_id,
+date_created=w.date_created,oof_shard=w.oof_shard,version=w.version,nats_seq=w.nats_seq
+FROM wb_data w
+WHERE w.order_uid=o.order_uid AND w.version > o.version
+RETURNING o.id`
+	// Orders archived since, at the same version or a later one, stay in
+	// order_archive.
+	BackfillInsertQuery = `
+INSERT INTO orders (id,order_uid,track_number,entry,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard,version,nats_seq)
+SELECT CASE WHEN EXISTS (SELECT 1 FROM orders o WHERE o.id=w.id) THEN nextval(pg_get_serial_sequence('orders', 'id')) ELSE w.id END,
+w.order_uid,w.track_number,w.entry,w.locale,w.internal_signature,w.customer_id,w.delivery_service,w.shardkey,w.sm_id,w.date_created,w.oof_shard,w.version,w.nats_seq
+FROM wb_data w
+WHERE w.order_uid IS NOT NULL
+AND NOT EXISTS (SELECT 1 FROM orders o WHERE o.order_uid=w.order_uid)
+AND NOT EXISTS (SELECT 1 FROM order_archive a WHERE a.order_uid=w.order_uid AND a.version >= w.version)
+ORDER BY w.id
+RETURNING id`
+
+	BackfillDeleteQueries = []string{
+		"DELETE FROM deliveries WHERE order_id = ANY($1)",
+		"DELETE FROM payments WHERE order_id = ANY($1)",
+		"DELETE FROM order_items WHERE order_id = ANY($1)",
+	}
+	BackfillChildQueries = []string{`
+INSERT INTO deliveries (order_id,name,phone,zip,city,address,region,email)
+SELECT o.id,
+COALESCE(w.delivery->>'name', ''),COALESCE(w.delivery->>'phone', ''),COALESCE(w.delivery->>'zip', ''),
+COALESCE(w.delivery->>'city', ''),COALESCE(w.delivery->>'address', ''),COALESCE(w.delivery->>'region', ''),
+COALESCE(w.delivery->>'email', '')
+FROM wb_data w JOIN orders o ON o.order_uid=w.order_uid
+WHERE o.id = ANY($1) AND jsonb_typeof(w.delivery) = 'object'`, `
+INSERT INTO payments (order_id,transaction,request_id,currency,provider,bank,amount,payment_dt,delivery_cost,goods_total,custom_fee)
+SELECT o.id,
+COALESCE(w.payment->>'transaction', ''),COALESCE(w.payment->>'request_id', ''),COALESCE(w.payment->>'currency', ''),
+COALESCE(w.payment->>'provider', ''),COALESCE(w.payment->>'bank', ''),
+COALESCE((w.payment->>'amount')::BIGINT, 0),COALESCE((w.payment->>'payment_dt')::BIGINT, 0),
+COALESCE((w.payment->>'delivery_cost')::BIGINT, 0),COALESCE((w.payment->>'goods_total')::BIGINT, 0),
+COALESCE((w.payment->>'custom_fee')::BIGINT, 0)
+FROM wb_data w JOIN orders o ON o.order_uid=w.order_uid
+WHERE o.id = ANY($1) AND jsonb_typeof(w.payment) = 'object'`, `
+INSERT INTO order_items (order_id,position,track_number,rid,name,size,brand,chrt_id,price,sale,total_price,nm_id,status)
+SELECT o.id,i.position,
+COALESCE(i.item->>'track_number', ''),COALESCE(i.item->>'rid', ''),COALESCE(i.item->>'name', ''),
+COALESCE(i.item->>'size', ''),COALESCE(i.item->>'brand', ''),
+COALESCE((i.item->>'chrt_id')::BIGINT, 0),COALESCE((i.item->>'price')::BIGINT, 0),
+COALESCE((i.item->>'sale')::BIGINT, 0),COALESCE((i.item->>'total_price')::BIGINT, 0),
+COALESCE((i.item->>'nm_id')::BIGINT, 0),COALESCE((i.item->>'status')::INT, 0)
+FROM wb_data w JOIN orders o ON o.order_uid=w.order_uid
+CROSS JOIN LATERAL jsonb_array_elements(
+CASE WHEN jsonb_typeof(w.items) = 'array' THEN w.items ELSE '[]'::JSONB END
+) WITH ORDINALITY AS i(item, position)
+WHERE o.id = ANY($1)`,
+	}
+)
+
+// Backfill copies the orders of wb_data into the normalized tables: the ones
+// they miss, with their ids when free, and the ones with a later version in
+// wb_data, whose replaced version goes to order_history. Orders are matched
+// by order_uid, so a backfill can be run any number of times; it runs on
+// every start in normalized mode, so that the orders stored in JSON mode
+// since the migration or the last switch aren't lost. It returns the number
+// of orders copied.
+func (db *DBStore) Backfill(ctx context.Context) (int, error) {
+	tx, err := db.connPool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	if _, err = tx.Exec(ctx, LockMigrationsQuery, backfillLockID); err != nil {
+		return 0, err
+	}
+	if _, err = tx.Exec(ctx, BackfillSequenceQuery); err != nil {
+		return 0, err
+	}
+	if _, err = tx.Exec(ctx, BackfillHistoryQuery); err != nil {
+		return 0, err
+	}
+	ids := make([]int, 0)
+	collect := func(rows pgx.Rows) error {
+		id := 0
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		return nil
+	}
+	if err = queryEach(ctx, tx, collect, BackfillUpdateQuery); err != nil {
+		return 0, err
+	}
+	if err = queryEach(ctx, tx, collect, BackfillInsertQuery); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, tx.Commit(ctx)
+	}
+	for _, query := range append(BackfillDeleteQueries, BackfillChildQueries...) {
+		if _, err = tx.Exec(ctx, query, ids); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), tx.Commit(ctx)
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBStoreBackfill(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Errorf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	ctx := context.Background()
+	dbStore := &DBStore{connPool: mock, mode: ModeNormalized}
+	expectCopy := func(updated, inserted *pgxmock.Rows) {
+		mock.ExpectBegin()
+		mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(backfillLockID).WillReturnResult(pgxmock.NewResult("SELECT", 1))
+		mock.ExpectExec("SELECT setval").WillReturnResult(pgxmock.NewResult("SELECT", 1))
+		mock.ExpectExec("INSERT INTO order_history (.+) WHERE w.version > o.version").WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		mock.ExpectQuery("UPDATE orders o SET (.+) WHERE w.order_uid=o.order_uid AND w.version > o.version").WillReturnRows(updated)
+		mock.ExpectQuery("INSERT INTO orders (.+) NOT EXISTS (.+) order_archive").WillReturnRows(inserted)
+	}
+
+	// Testing 'Backfill', expecting the children of updated and inserted
+	// orders replaced
+	expectCopy(pgxmock.NewRows([]string{"id"}).AddRow(1), pgxmock.NewRows([]string{"id"}).AddRow(7))
+	for _, table := range []string{"deliveries", "payments", "order_items"} {
+		mock.ExpectExec("DELETE FROM " + table).WithArgs([]int{1, 7}).WillReturnResult(pgxmock.NewResult("DELETE", 2))
+	}
+	for _, table := range []string{"deliveries", "payments", "order_items"} {
+		mock.ExpectExec("INSERT INTO " + table).WithArgs([]int{1, 7}).WillReturnResult(pgxmock.NewResult("INSERT", 2))
+	}
+	mock.ExpectCommit()
+	n, err := dbStore.Backfill(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	// Testing 'Backfill' again, expecting nothing left to copy
+	expectCopy(pgxmock.NewRows([]string{"id"}), pgxmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+	n, err = dbStore.Backfill(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// Testing 'Backfill' with a failing copy, expecting a rollback
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(backfillLockID).WillReturnError(pgx.ErrTxClosed)
+	mock.ExpectRollback()
+	_, err = dbStore.Backfill(ctx)
+	require.ErrorIs(t, err, pgx.ErrTxClosed)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,219 @@
+package db
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	BatchCreateQuery = `
+CREATE TEMP TABLE wb_data_batch ON COMMIT DROP AS
+SELECT 0 AS batch_pos,order_uid,track_number,entry,delivery,payment,items,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard,nats_seq
+FROM wb_data WITH NO DATA`
+	// Redeliveries of the same order may land in one batch, the last one wins
+	// and only it makes a new version.
+	BatchHistoryQuery = `
+INSERT INTO order_history (order_id,version,nats_seq,order_uid,track_number,entry,delivery,payment,items,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard)
+SELECT w.id,w.version,w.nats_seq,w.order_uid,w.track_number,w.entry,w.delivery,w.payment,w.items,w.locale,w.internal_signature,w.customer_id,w.delivery_service,w.shardkey,w.sm_id,w.date_created,w.oof_shard
+FROM wb_data w JOIN (SELECT DISTINCT ON (order_uid) * FROM wb_data_batch ORDER BY order_uid,batch_pos DESC) b ON b.order_uid=w.order_uid
+WHERE ` + batchChanged("w", "b") + `
+FOR UPDATE OF w`
+	BatchInsertQuery = `
+INSERT INTO wb_data (order_uid,track_number,entry,delivery,payment,items,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard,nats_seq)
+SELECT DISTINCT ON (order_uid) order_uid,track_number,entry,delivery,payment,items,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard,nats_seq
+FROM wb_data_batch ORDER BY order_uid,batch_pos DESC
+ON CONFLICT (order_uid) DO UPDATE SET
+track_number=EXCLUDED.track_number,entry=EXCLUDED.entry,delivery=EXCLUDED.delivery,payment=EXCLUDED.payment,
+items=EXCLUDED.items,locale=EXCLUDED.locale,internal_signature=EXCLUDED.internal_signature,customer_id=EXCLUDED.customer_id,
+delivery_service=EXCLUDED.delivery_service,shardkey=EXCLUDED.shardkey,sm_id=EXCLUDED.sm_id,date_created=EXCLUDED.date_created,
+oof_shard=EXCLUDED.oof_shard,version=wb_data.version+1,nats_seq=EXCLUDED.nats_seq
+WHERE ` + batchChanged("wb_data", "EXCLUDED")
+	BatchIDsQuery = "SELECT id,order_uid FROM wb_data WHERE order_uid IN (SELECT order_uid FROM wb_data_batch)"
+
+	batchTable   = pgx.Identifier{"wb_data_batch"}
+	batchColumns = []string{
+		"batch_pos", "order_uid", "track_number", "entry", "delivery", "payment", "items", "locale",
+		"internal_signature", "customer_id", "delivery_service", "shardkey", "sm_id", "date_created", "oof_shard",
+		"nats_seq",
+	}
+)
+
+// batchChanged is the condition under which row b makes a new version of the
+// stored row a.
+func batchChanged(a, b string) string {
+	cols := []string{
+		"track_number", "entry", "delivery", "payment", "items", "locale", "internal_signature",
+		"customer_id", "delivery_service", "shardkey", "sm_id", "date_created", "oof_shard",
+	}
+	left, right := make([]string, len(cols)), make([]string, len(cols))
+	for i, c := range cols {
+		left[i], right[i] = a+"."+c, b+"."+c
+	}
+	return "(" + strings.Join(left, ",") + ") IS DISTINCT FROM (" + strings.Join(right, ",") + ")"
+}
+
+type batchEntry struct {
+	model *store.Model
+	seq   *int64
+	done  func(int, error)
+}
+
+// BatchWriter collects models and writes them to the DBStore in batches,
+// flushing when size models are pending or interval has passed since the
+// first pending one. It satisfies store.BatchIface.
+type BatchWriter struct {
+	db       *DBStore
+	size     int
+	interval time.Duration
+	queue    chan batchEntry
+}
+
+func NewBatchWriter(db *DBStore, size int, interval time.Duration) *BatchWriter {
+	if size < 1 {
+		size = 1
+	}
+	return &BatchWriter{db, size, interval, make(chan batchEntry, size)}
+}
+
+// Add queues m for the next batch. done is called from the writer goroutine
+// with the stored id, or with the error if m could not be stored. Add blocks
+// while the queue is full, so Run should already be running; if ctx is done
+// first, done is called with ctx's error.
+func (bw *BatchWriter) Add(ctx context.Context, m *store.Model, done func(int, error)) {
+	select {
+	case bw.queue <- batchEntry{m, sequence(ctx), done}:
+	case <-ctx.Done():
+		done(-1, ctx.Err())
+	}
+}
+
+// Run flushes batches until ctx is done, then flushes whatever is queued.
+func (bw *BatchWriter) Run(ctx context.Context) {
+	pending := make([]batchEntry, 0, bw.size)
+	var deadline <-chan time.Time
+	for {
+		select {
+		case e := <-bw.queue:
+			if len(pending) == 0 {
+				deadline = time.After(bw.interval)
+			}
+			pending = append(pending, e)
+			if len(pending) < bw.size {
+				continue
+			}
+		case <-deadline:
+		case <-ctx.Done():
+			for len(bw.queue) > 0 {
+				pending = append(pending, <-bw.queue)
+			}
+			bw.flush(context.Background(), pending)
+			return
+		}
+		bw.flush(ctx, pending)
+		pending, deadline = pending[:0], nil
+	}
+}
+
+// flush writes the batch in one transaction. If that fails, every model is
+// written on its own so a single bad order doesn't fail the whole batch.
+func (bw *BatchWriter) flush(ctx context.Context, batch []batchEntry) {
+	if len(batch) == 0 {
+		return
+	}
+	ids, err := bw.write(ctx, batch)
+	if err == nil {
+		for i, e := range batch {
+			e.done(ids[i], nil)
+		}
+		return
+	}
+	log.Printf("[BATCH] Batch of %d failed, writing one by one: %s\n", len(batch), err.Error())
+	for _, e := range batch {
+		id := -1
+		err = bw.db.set(ctx, &id, e.model, e.seq)
+		e.done(id, err)
+	}
+}
+
+func (bw *BatchWriter) write(ctx context.Context, batch []batchEntry) ([]int, error) {
+	ctx, cancel := withTimeout(ctx, bw.db.timeouts.Set)
+	defer cancel()
+	tx, err := bw.db.connPool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	ids := make([]int, len(batch))
+	if bw.db.mode == ModeNormalized {
+		for i, e := range batch {
+			if err = bw.db.write(ctx, tx, &ids[i], e.model, e.seq); err != nil {
+				return nil, err
+			}
+		}
+		return ids, tx.Commit(ctx)
+	}
+
+	if _, err = tx.Exec(ctx, BatchCreateQuery); err != nil {
+		return nil, err
+	}
+	rows := make([][]interface{}, 0, len(batch))
+	for i, e := range batch {
+		m := e.model
+		rows = append(rows, []interface{}{
+			i,
+			m.Order_uid,
+			m.Track_number,
+			m.Entry,
+			m.Delivery,
+			m.Payment,
+			m.Items,
+			m.Locale,
+			m.Internal_signature,
+			m.Customer_id,
+			m.Delivery_service,
+			m.Shardkey,
+			m.Sm_id,
+			m.Date_created,
+			m.Oof_shard,
+			e.seq,
+		})
+	}
+	if _, err = tx.CopyFrom(ctx, batchTable, batchColumns, pgx.CopyFromRows(rows)); err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec(ctx, BatchHistoryQuery); err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec(ctx, BatchInsertQuery); err != nil {
+		return nil, err
+	}
+	uids := make(map[string]int, len(batch))
+	err = func() error {
+		res, err := tx.Query(ctx, BatchIDsQuery)
+		if err != nil {
+			return err
+		}
+		defer res.Close()
+		for res.Next() {
+			id, uid := 0, ""
+			if err = res.Scan(&id, &uid); err != nil {
+				return err
+			}
+			uids[uid] = id
+		}
+		return res.Err()
+	}()
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range batch {
+		ids[i] = uids[e.model.Order_uid]
+	}
+	return ids, tx.Commit(ctx)
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stretchr/testify/require"
+)
+
+type batchResult struct {
+	id  int
+	err error
+}
+
+func TestBatchWriter(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Errorf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	model := exampleModel
+	other := *exampleModel
+	other.Order_uid = "other_uid"
+	dbStore := &DBStore{connPool: mock}
+	bw := NewBatchWriter(dbStore, 2, time.Hour)
+	results := make(chan batchResult, 2)
+	done := func(id int, err error) { results <- batchResult{id, err} }
+
+	// Testing 'flush', writing the whole batch with COPY
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMP TABLE wb_data_batch").WillReturnResult(pgxmock.NewResult("SELECT", 0))
+	mock.ExpectCopyFrom(`"wb_data_batch"`, batchColumns).WillReturnResult(2)
+	mock.ExpectExec("INSERT INTO order_history (.+) FROM wb_data w JOIN").WillReturnResult(pgxmock.NewResult("INSERT", 0))
+	mock.ExpectExec("INSERT INTO wb_data (.+) FROM wb_data_batch").WillReturnResult(pgxmock.NewResult("INSERT", 2))
+	mock.ExpectQuery("SELECT id,order_uid FROM wb_data").WillReturnRows(
+		pgxmock.NewRows([]string{"id", "order_uid"}).AddRow(3, "other_uid").AddRow(4, model.Order_uid))
+	mock.ExpectCommit()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		bw.Run(ctx)
+		close(stopped)
+	}()
+	bw.Add(ctx, model, done)
+	bw.Add(ctx, &other, done)
+	require.Equal(t, batchResult{4, nil}, <-results)
+	require.Equal(t, batchResult{3, nil}, <-results)
+
+	// Testing 'flush' on shutdown, falling back to single writes after a failed batch
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMP TABLE wb_data_batch").WillReturnError(pgx.ErrTxClosed)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id,version,nats_seq FROM wb_data").WithArgs(model.Order_uid).WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery("INSERT INTO wb_data").WillReturnError(pgx.ErrTxClosed)
+	mock.ExpectRollback()
+	bw.Add(ctx, model, done)
+	cancel()
+	<-stopped
+	require.Equal(t, batchResult{-1, pgx.ErrTxClosed}, <-results)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBatchWriterInterval(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Errorf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	dbStore := &DBStore{connPool: mock, mode: ModeNormalized}
+	bw := NewBatchWriter(dbStore, 100, 10*time.Millisecond)
+	results := make(chan batchResult, 1)
+
+	// Testing 'flush' by interval, writing normalized orders in one transaction
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id,version,nats_seq FROM orders").WithArgs("no_children").WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery("INSERT INTO orders").WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectExec("DELETE FROM order_items").WithArgs(5).WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	mock.ExpectCommit()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bw.Run(ctx)
+	bw.Add(ctx, &store.Model{Order_uid: "no_children"}, func(id int, err error) { results <- batchResult{id, err} })
+	select {
+	case res := <-results:
+		require.Equal(t, batchResult{5, nil}, res)
+	case <-time.After(time.Second):
+		t.Fatal("batch wasn't flushed by interval")
+	}
+	require.NoError(t, mock.ExpectationsWereMet())
+}
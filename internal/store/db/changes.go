@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	// NowQuery returns the time in the time zone of the timestamps the db
+	// keeps, like replaced_at and archived_at.
+	NowQuery      = "SELECT localtimestamp"
+	ChangedQuery  = "%s WHERE id <= $2 AND id IN (SELECT order_id FROM order_history WHERE replaced_at >= $1) ORDER BY id"
+	ArchivedQuery = "SELECT id FROM order_archive WHERE archived_at >= $1 AND id <= $2 ORDER BY id"
+)
+
+// Now returns the current time of the db.
+func (db *DBStore) Now(ctx context.Context) (time.Time, error) {
+	t := time.Time{}
+	err := db.connPool.QueryRow(ctx, NowQuery).Scan(&t)
+	return t, err
+}
+
+// Changed returns the orders with ids up to upTo that were replaced since,
+// and the ids up to upTo of the orders archived since. It only reads the
+// orders that changed, in one query for each.
+func (db *DBStore) Changed(ctx context.Context, since time.Time, upTo int) ([]store.Record, []int, error) {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Fetch)
+	defer cancel()
+	query, selectOrders := fmt.Sprintf(ChangedQuery, GetAllQuery), selectJSON
+	if db.mode == ModeNormalized {
+		query, selectOrders = fmt.Sprintf(ChangedQuery, NormalizedGetAllQuery), selectNormalized
+	}
+	ids, models, err := selectOrders(ctx, db.connPool, query, since, upTo)
+	if err != nil {
+		return nil, nil, err
+	}
+	records := make([]store.Record, len(ids))
+	for i, id := range ids {
+		records[i] = store.Record{ID: id, Model: models[id]}
+	}
+	archived := make([]int, 0)
+	err = queryEach(ctx, db.connPool, func(rows pgx.Rows) error {
+		id := 0
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		archived = append(archived, id)
+		return nil
+	}, ArchivedQuery, since, upTo)
+	if err != nil {
+		return nil, nil, err
+	}
+	return records, archived, nil
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBStoreChanged(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Errorf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	ctx := context.Background()
+	dbStore := &DBStore{connPool: mock}
+	since := time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+
+	// Testing 'Now', expecting the time of the db
+	mock.ExpectQuery("SELECT localtimestamp").WillReturnRows(pgxmock.NewRows([]string{"localtimestamp"}).AddRow(since))
+	now, err := dbStore.Now(ctx)
+	require.NoError(t, err)
+	require.Equal(t, since, now)
+
+	// Testing 'Changed', expecting the replaced orders and the archived ids
+	mock.ExpectQuery("SELECT (.+) FROM wb_data WHERE id <= \\$2 AND id IN \\(SELECT order_id FROM order_history WHERE replaced_at >= \\$1\\) ORDER BY id").
+		WithArgs(since, 42).WillReturnRows(jsonRows(7, exampleModel))
+	mock.ExpectQuery("SELECT id FROM order_archive WHERE archived_at >= \\$1 AND id <= \\$2").
+		WithArgs(since, 42).WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(3).AddRow(9))
+	records, archived, err := dbStore.Changed(ctx, since, 42)
+	require.NoError(t, err)
+	require.Equal(t, []store.Record{{ID: 7, Model: exampleModel}}, records)
+	require.Equal(t, []int{3, 9}, archived)
+
+	// Testing 'Changed' in normalized mode, expecting the orders table
+	dbStore.mode = ModeNormalized
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id <= \\$2 AND id IN").
+		WithArgs(since, 42).WillReturnRows(pgxmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("SELECT id FROM order_archive").
+		WithArgs(since, 42).WillReturnRows(pgxmock.NewRows([]string{"id"}))
+	records, archived, err = dbStore.Changed(ctx, since, 42)
+	require.NoError(t, err)
+	require.Empty(t, records)
+	require.Empty(t, archived)
+	dbStore.mode = ModeJSON
+
+	// Testing 'Changed', expecting error
+	mock.ExpectQuery("SELECT (.+) FROM wb_data").WillReturnError(pgx.ErrTxClosed)
+	_, _, err = dbStore.Changed(ctx, since, 42)
+	require.ErrorIs(t, err, pgx.ErrTxClosed)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -8,31 +8,84 @@ import (
 	"time"
 
 	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 var (
-	Error404NotFound     = fmt.Errorf("error: 404 not found")
+	Error404NotFound     = store.Error404NotFound
 	ErrorTimeoutExceeded = fmt.Errorf("db connection failed after timeout")
 
 	SetQuery = `
-INSERT INTO wb_data (order_uid,track_number,entry,delivery,payment,items,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard) 
-VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14) RETURNING id`
-	GetQuery    = "SELECT * FROM wb_data WHERE id=%d"
-	GetAllQuery = "SELECT * FROM wb_data"
+INSERT INTO wb_data (order_uid,track_number,entry,delivery,payment,items,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard,nats_seq) 
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)
+ON CONFLICT (order_uid) DO UPDATE SET
+track_number=EXCLUDED.track_number,entry=EXCLUDED.entry,delivery=EXCLUDED.delivery,payment=EXCLUDED.payment,
+items=EXCLUDED.items,locale=EXCLUDED.locale,internal_signature=EXCLUDED.internal_signature,customer_id=EXCLUDED.customer_id,
+delivery_service=EXCLUDED.delivery_service,shardkey=EXCLUDED.shardkey,sm_id=EXCLUDED.sm_id,date_created=EXCLUDED.date_created,
+oof_shard=EXCLUDED.oof_shard,version=wb_data.version+1,nats_seq=EXCLUDED.nats_seq
+RETURNING id`
+
+	JSONColumns   = "id,order_uid,track_number,entry,delivery,payment,items,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard"
+	GetQuery      = "SELECT " + JSONColumns + " FROM wb_data WHERE id=%d"
+	GetByUIDQuery = "SELECT " + JSONColumns + " FROM wb_data WHERE order_uid=$1"
+	GetAllQuery   = "SELECT " + JSONColumns + " FROM wb_data"
 )
 
+// querier is the part of PoolIface shared with pgx.Tx.
+type querier interface {
+	Query(context.Context, string, ...interface{}) (pgx.Rows, error)
+	QueryRow(context.Context, string, ...interface{}) pgx.Row
+}
+
 type PoolIface interface {
+	Begin(context.Context) (pgx.Tx, error)
+	Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error)
 	Query(context.Context, string, ...interface{}) (pgx.Rows, error)
 	QueryRow(context.Context, string, ...interface{}) pgx.Row
 }
 
+// StorageMode selects the tables a DBStore reads and writes.
+type StorageMode int
+
+const (
+	// ModeJSON keeps delivery, payment and items as JSON columns of wb_data.
+	ModeJSON StorageMode = iota
+	// ModeNormalized splits an order into the orders, deliveries, payments
+	// and order_items tables.
+	ModeNormalized
+)
+
+func ParseStorageMode(s string) (StorageMode, error) {
+	switch s {
+	case "", "json":
+		return ModeJSON, nil
+	case "normalized":
+		return ModeNormalized, nil
+	}
+	return ModeJSON, fmt.Errorf("error: unknown storage mode '%s'", s)
+}
+
+// Timeouts bound each kind of DBStore operation on top of the caller's
+// context. A zero duration leaves the operation to the caller's deadline.
+// Fetch bounds every chunk read by Stream rather than the whole stream, and
+// the orders read by Changed.
+type Timeouts struct {
+	Set     time.Duration
+	Get     time.Duration
+	Fetch   time.Duration
+	Find    time.Duration
+	Archive time.Duration
+}
+
 type DBStore struct {
 	connPool PoolIface
+	mode     StorageMode
+	timeouts Timeouts
 }
 
-func NewDBStore(ctx context.Context, connStr string, timeout time.Duration) (*DBStore, error) {
+func NewDBStore(ctx context.Context, connStr string, timeout time.Duration, mode StorageMode, timeouts Timeouts) (*DBStore, error) {
 	log.Printf("Trying to connect to %s\n", connStr)
 	var (
 		conn *pgxpool.Pool
@@ -65,12 +118,40 @@ LOOP:
 
 	log.Println("Connect success!")
 
-	return &DBStore{conn}, nil
+	return &DBStore{conn, mode, timeouts}, nil
 }
 
-func (db *DBStore) Set(id *int, m *store.Model) error {
-	err := db.connPool.QueryRow(
-		context.Background(), SetQuery,
+// Migrator returns a Migrator for the embedded schema migrations that runs
+// on the store's connection pool.
+func (db *DBStore) Migrator() (*Migrator, error) {
+	return NewMigrator(db.connPool)
+}
+
+// Set stores m as a new order or, if an order with the same order_uid
+// exists and differs from m, as its next version. The NATS sequence of the
+// message is taken from ctx, see store.WithSequence.
+func (db *DBStore) Set(ctx context.Context, id *int, m *store.Model) error {
+	return db.set(ctx, id, m, sequence(ctx))
+}
+
+func (db *DBStore) set(ctx context.Context, id *int, m *store.Model, seq *int64) error {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Set)
+	defer cancel()
+	tx, err := db.connPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = db.write(ctx, tx, id, m, seq); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func writeJSON(ctx context.Context, tx pgx.Tx, id *int, m *store.Model, seq *int64) error {
+	return tx.QueryRow(
+		ctx, SetQuery,
 		m.Order_uid,
 		m.Track_number,
 		m.Entry,
@@ -85,17 +166,19 @@ func (db *DBStore) Set(id *int, m *store.Model) error {
 		m.Sm_id,
 		m.Date_created,
 		m.Oof_shard,
+		seq,
 	).Scan(id)
-	if err != nil {
-		return err
-	}
-	return nil
 }
 
-func (db *DBStore) Get(id int) (*store.Model, error) {
+func (db *DBStore) Get(ctx context.Context, id int) (*store.Model, error) {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Get)
+	defer cancel()
+	if db.mode == ModeNormalized {
+		return db.getNormalized(ctx, NormalizedGetQuery, id)
+	}
 	res := new(store.Model)
 	q := fmt.Sprintf(GetQuery, id)
-	err := db.connPool.QueryRow(context.Background(), q).Scan(
+	err := db.connPool.QueryRow(ctx, q).Scan(
 		&id,
 		&res.Order_uid,
 		&res.Track_number,
@@ -121,19 +204,54 @@ func (db *DBStore) Get(id int) (*store.Model, error) {
 	return res, nil
 }
 
-func (db *DBStore) GetAll() (map[int]*store.Model, error) {
-	m := make(map[int]*store.Model)
-	rows, err := db.connPool.Query(context.Background(), GetAllQuery)
+func (db *DBStore) GetByUID(ctx context.Context, uid string) (*store.Model, error) {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Get)
+	defer cancel()
+	if db.mode == ModeNormalized {
+		return db.getNormalized(ctx, NormalizedGetByUIDQuery, uid)
+	}
+	id, res := 0, new(store.Model)
+	err := db.connPool.QueryRow(ctx, GetByUIDQuery, uid).Scan(
+		&id,
+		&res.Order_uid,
+		&res.Track_number,
+		&res.Entry,
+		&res.Delivery,
+		&res.Payment,
+		&res.Items,
+		&res.Locale,
+		&res.Internal_signature,
+		&res.Customer_id,
+		&res.Delivery_service,
+		&res.Shardkey,
+		&res.Sm_id,
+		&res.Date_created,
+		&res.Oof_shard,
+	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, Error404NotFound
 		}
 		return nil, err
 	}
-	defer rows.Close()
-	for rows.Next() {
+	return res, nil
+}
+
+// withTimeout is context.WithTimeout that leaves ctx as is for a zero timeout.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// selectJSON runs a query for JSONColumns over wb_data. The ids are returned in the
+// order of the query.
+func selectJSON(ctx context.Context, q querier, query string, args ...interface{}) ([]int, map[int]*store.Model, error) {
+	ids, models := make([]int, 0), make(map[int]*store.Model)
+	err := queryEach(ctx, q, func(rows pgx.Rows) error {
 		id, temp := 0, new(store.Model)
-		rows.Scan(
+		err := rows.Scan(
 			&id,
 			&temp.Order_uid,
 			&temp.Track_number,
@@ -150,7 +268,15 @@ func (db *DBStore) GetAll() (map[int]*store.Model, error) {
 			&temp.Date_created,
 			&temp.Oof_shard,
 		)
-		m[id] = temp
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		models[id] = temp
+		return nil
+	}, query, args...)
+	if err != nil {
+		return nil, nil, err
 	}
-	return m, nil
+	return ids, models, nil
 }
@@ -64,7 +64,7 @@ var (
 )
 
 func TestNewDBStore(t *testing.T) {
-	res, err := NewDBStore(context.Background(), "", 11*time.Second)
+	res, err := NewDBStore(context.Background(), "", 11*time.Second, ModeJSON, Timeouts{})
 	require.Nil(t, res)
 	require.ErrorIs(t, ErrorTimeoutExceeded, err)
 }
@@ -75,12 +75,16 @@ func TestDBStore(t *testing.T) {
 		t.Errorf("An error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer mock.Close()
+	ctx := context.Background()
 	id, model := 1, exampleModel
-	model_map := map[int]*store.Model{id: model}
-	dbStore := &DBStore{mock}
+	dbStore := &DBStore{connPool: mock}
 
 	// Testing 'Set', not expecting any error, expecting 1 row
-	mock.ExpectQuery("INSERT INTO wb_data").WithArgs(
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id,version,nats_seq FROM wb_data WHERE order_uid(.+) FOR UPDATE").WithArgs(
+		model.Order_uid,
+	).WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery("INSERT INTO wb_data (.+) ON CONFLICT \\(order_uid\\) DO UPDATE").WithArgs(
 		model.Order_uid,
 		model.Track_number,
 		model.Entry,
@@ -95,11 +99,15 @@ func TestDBStore(t *testing.T) {
 		model.Sm_id,
 		model.Date_created,
 		model.Oof_shard,
+		(*int64)(nil),
 	).WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(1))
-	err = dbStore.Set(&id, model)
+	mock.ExpectCommit()
+	err = dbStore.Set(ctx, &id, model)
 	require.NoError(t, err)
 
-	// Testing 'Set', expecting ErrNoRows error
+	// Testing 'Set', expecting ErrNoRows error and rollback
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id,version,nats_seq FROM wb_data").WithArgs(model.Order_uid).WillReturnError(pgx.ErrNoRows)
 	mock.ExpectQuery("INSERT INTO wb_data").WithArgs(
 		model.Order_uid,
 		model.Track_number,
@@ -115,8 +123,10 @@ func TestDBStore(t *testing.T) {
 		model.Sm_id,
 		model.Date_created,
 		model.Oof_shard,
+		(*int64)(nil),
 	).WillReturnError(pgx.ErrNoRows)
-	err = dbStore.Set(&id, model)
+	mock.ExpectRollback()
+	err = dbStore.Set(ctx, &id, model)
 	require.ErrorIs(t, pgx.ErrNoRows, err)
 
 	// Testing 'Get', not expecting any error, expecting 1 row
@@ -154,27 +164,27 @@ func TestDBStore(t *testing.T) {
 		model.Date_created,
 		model.Oof_shard,
 	))
-	res, err := dbStore.Get(id)
+	res, err := dbStore.Get(ctx, id)
 	require.NoError(t, err)
 	require.IsType(t, &store.Model{}, res)
 	require.Equal(t, model, res)
 
 	// Testing 'Get', expecting Error404NotFound error
 	mock.ExpectQuery("SELECT (.+) FROM wb_data").WillReturnError(pgx.ErrNoRows)
-	res, err = dbStore.Get(id)
+	res, err = dbStore.Get(ctx, id)
 	require.Nil(t, res)
 	require.ErrorIs(t, Error404NotFound, err)
 
 	// Testing 'Get', expecting any other error except ErrNoRows, Error404NotFound
 	mock.ExpectQuery("SELECT (.+) FROM wb_data").WillReturnError(pgx.ErrTxClosed)
-	res, err = dbStore.Get(id)
+	res, err = dbStore.Get(ctx, id)
 	require.Nil(t, res)
 	require.Error(t, err)
 	require.NotErrorIs(t, pgx.ErrNoRows, err)
 	require.NotErrorIs(t, Error404NotFound, err)
 
-	// Testing 'GetAll', not expecting any error, expecting 1 row
-	mock.ExpectQuery("SELECT (.+) FROM wb_data").WillReturnRows(pgxmock.NewRows(
+	// Testing 'GetByUID', not expecting any error, expecting 1 row
+	mock.ExpectQuery("SELECT (.+) FROM wb_data WHERE order_uid").WithArgs(model.Order_uid).WillReturnRows(pgxmock.NewRows(
 		[]string{
 			"id",
 			"order_uid",
@@ -208,22 +218,36 @@ func TestDBStore(t *testing.T) {
 		model.Date_created,
 		model.Oof_shard,
 	))
-	res_map, err := dbStore.GetAll()
+	res, err = dbStore.GetByUID(ctx, model.Order_uid)
 	require.NoError(t, err)
-	require.IsType(t, model_map, res_map)
-	require.Equal(t, model_map, res_map)
+	require.Equal(t, model, res)
 
-	// Testing 'GetAll', expecting Error404NotFound error
-	mock.ExpectQuery("SELECT (.+) FROM wb_data").WillReturnError(pgx.ErrNoRows)
-	res_map, err = dbStore.GetAll()
-	require.Nil(t, res_map)
+	// Testing 'GetByUID', expecting Error404NotFound error
+	mock.ExpectQuery("SELECT (.+) FROM wb_data WHERE order_uid").WithArgs("unknown").WillReturnError(pgx.ErrNoRows)
+	res, err = dbStore.GetByUID(ctx, "unknown")
+	require.Nil(t, res)
 	require.ErrorIs(t, Error404NotFound, err)
+}
 
-	// Testing 'GetAll', expecting any other error except ErrNoRows, Error404NotFound
-	mock.ExpectQuery("SELECT (.+) FROM wb_data").WillReturnError(pgx.ErrTxClosed)
-	res_map, err = dbStore.GetAll()
-	require.Nil(t, res_map)
+func TestDBStoreTimeouts(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Errorf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	dbStore := &DBStore{connPool: mock, timeouts: Timeouts{Get: 10 * time.Millisecond}}
+
+	// Testing 'Get', expecting the operation timeout to cancel the query
+	mock.ExpectQuery("SELECT (.+) FROM wb_data").WillDelayFor(time.Second).WillReturnError(pgx.ErrNoRows)
+	res, err := dbStore.Get(context.Background(), 1)
+	require.Nil(t, res)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Testing 'Get', expecting the caller's context to cancel the query
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mock.ExpectQuery("SELECT (.+) FROM wb_data").WillDelayFor(time.Second).WillReturnError(pgx.ErrNoRows)
+	res, err = dbStore.Get(ctx, 1)
+	require.Nil(t, res)
 	require.Error(t, err)
-	require.NotErrorIs(t, pgx.ErrNoRows, err)
-	require.NotErrorIs(t, Error404NotFound, err)
 }
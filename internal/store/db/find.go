@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ineverbee/wbl0/internal/store"
+)
+
+// orderFilter describes how to find orders by a nested field in both storage
+// modes: a JSONB containment check on a wb_data column, or a subquery that
+// returns matching ids from the normalized tables.
+type orderFilter struct {
+	column     string
+	key        string
+	array      bool
+	normalized string
+}
+
+var (
+	filterNmID = orderFilter{
+		"items", "nm_id", true,
+		"SELECT order_id FROM order_items WHERE nm_id=$1",
+	}
+	filterChrtID = orderFilter{
+		"items", "chrt_id", true,
+		"SELECT order_id FROM order_items WHERE chrt_id=$1",
+	}
+	filterBrand = orderFilter{
+		"items", "brand", true,
+		"SELECT order_id FROM order_items WHERE brand=$1",
+	}
+	filterRid = orderFilter{
+		"items", "rid", true,
+		"SELECT order_id FROM order_items WHERE rid=$1",
+	}
+	filterTransaction = orderFilter{
+		"payment", "transaction", false,
+		"SELECT order_id FROM payments WHERE transaction=$1",
+	}
+	filterEmail = orderFilter{
+		"delivery", "email", false,
+		"SELECT order_id FROM deliveries WHERE email=$1",
+	}
+
+	FindQuery           = "SELECT " + JSONColumns + " FROM wb_data WHERE %s @> $1::jsonb ORDER BY id LIMIT $2"
+	NormalizedFindQuery = "SELECT " + NormalizedOrderColumns + " FROM orders WHERE id IN (%s) ORDER BY id LIMIT $2"
+)
+
+// FindByNmID returns up to limit orders that contain an item with nm_id.
+func (db *DBStore) FindByNmID(ctx context.Context, nmID uint, limit int) (map[int]*store.Model, error) {
+	return db.find(ctx, filterNmID, nmID, limit)
+}
+
+// FindByChrtID returns up to limit orders that contain an item with chrt_id.
+func (db *DBStore) FindByChrtID(ctx context.Context, chrtID uint, limit int) (map[int]*store.Model, error) {
+	return db.find(ctx, filterChrtID, chrtID, limit)
+}
+
+// FindByBrand returns up to limit orders that contain an item of brand.
+func (db *DBStore) FindByBrand(ctx context.Context, brand string, limit int) (map[int]*store.Model, error) {
+	return db.find(ctx, filterBrand, brand, limit)
+}
+
+// FindByRid returns up to limit orders that contain an item with rid.
+func (db *DBStore) FindByRid(ctx context.Context, rid string, limit int) (map[int]*store.Model, error) {
+	return db.find(ctx, filterRid, rid, limit)
+}
+
+// FindByTransaction returns up to limit orders paid with transaction.
+func (db *DBStore) FindByTransaction(ctx context.Context, transaction string, limit int) (map[int]*store.Model, error) {
+	return db.find(ctx, filterTransaction, transaction, limit)
+}
+
+// FindByEmail returns up to limit orders delivered to email.
+func (db *DBStore) FindByEmail(ctx context.Context, email string, limit int) (map[int]*store.Model, error) {
+	return db.find(ctx, filterEmail, email, limit)
+}
+
+func (db *DBStore) find(ctx context.Context, f orderFilter, value interface{}, limit int) (map[int]*store.Model, error) {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Find)
+	defer cancel()
+	if db.mode == ModeNormalized {
+		_, models, err := selectNormalized(ctx, db.connPool, fmt.Sprintf(NormalizedFindQuery, f.normalized), value, limit)
+		return models, err
+	}
+	var doc interface{} = map[string]interface{}{f.key: value}
+	if f.array {
+		doc = []interface{}{doc}
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	_, models, err := selectJSON(ctx, db.connPool, fmt.Sprintf(FindQuery, f.column), string(b), limit)
+	return models, err
+}
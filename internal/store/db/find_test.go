@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBStoreFind(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Errorf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	ctx := context.Background()
+	id, model := 1, exampleModel
+	dbStore := &DBStore{connPool: mock}
+	rows := func() *pgxmock.Rows {
+		return pgxmock.NewRows([]string{
+			"id", "order_uid", "track_number", "entry", "delivery", "payment", "items", "locale",
+			"internal_signature", "customer_id", "delivery_service", "shardkey", "sm_id", "date_created", "oof_shard",
+		}).AddRow(
+			id,
+			model.Order_uid,
+			model.Track_number,
+			model.Entry,
+			model.Delivery,
+			model.Payment,
+			model.Items,
+			model.Locale,
+			model.Internal_signature,
+			model.Customer_id,
+			model.Delivery_service,
+			model.Shardkey,
+			model.Sm_id,
+			model.Date_created,
+			model.Oof_shard,
+		)
+	}
+
+	tc := []struct {
+		find   func() (map[int]*store.Model, error)
+		column string
+		doc    string
+	}{
+		{func() (map[int]*store.Model, error) { return dbStore.FindByNmID(ctx, 2389212, 10) }, "items", `[{"nm_id":2389212}]`},
+		{func() (map[int]*store.Model, error) { return dbStore.FindByChrtID(ctx, 9934930, 10) }, "items", `[{"chrt_id":9934930}]`},
+		{func() (map[int]*store.Model, error) { return dbStore.FindByBrand(ctx, "Vivienne Sabo", 10) }, "items", `[{"brand":"Vivienne Sabo"}]`},
+		{func() (map[int]*store.Model, error) { return dbStore.FindByRid(ctx, "ab4219087a764ae0btest", 10) }, "items", `[{"rid":"ab4219087a764ae0btest"}]`},
+		{func() (map[int]*store.Model, error) { return dbStore.FindByTransaction(ctx, "b563feb7b2b84b6test", 10) }, "payment", `{"transaction":"b563feb7b2b84b6test"}`},
+		{func() (map[int]*store.Model, error) { return dbStore.FindByEmail(ctx, "test@gmail.com", 10) }, "delivery", `{"email":"test@gmail.com"}`},
+	}
+	for _, c := range tc {
+		mock.ExpectQuery("SELECT (.+) FROM wb_data WHERE "+c.column+" @>").WithArgs(c.doc, 10).WillReturnRows(rows())
+		res, err := c.find()
+		require.NoError(t, err)
+		require.Equal(t, map[int]*store.Model{id: model}, res)
+	}
+
+	// Testing 'FindByNmID', expecting any error
+	mock.ExpectQuery("SELECT (.+) FROM wb_data WHERE items @>").WillReturnError(pgx.ErrTxClosed)
+	res, err := dbStore.FindByNmID(ctx, 1, 10)
+	require.Nil(t, res)
+	require.ErrorIs(t, err, pgx.ErrTxClosed)
+
+	// Testing 'FindByNmID' in normalized mode, expecting no rows
+	dbStore.mode = ModeNormalized
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id IN \\(SELECT order_id FROM order_items WHERE nm_id=\\$1\\)").
+		WithArgs(uint(1), 10).WillReturnRows(pgxmock.NewRows([]string{"id"}))
+	res, err = dbStore.FindByNmID(ctx, 1, 10)
+	require.NoError(t, err)
+	require.Empty(t, res)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
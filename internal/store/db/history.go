@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	LockOrderQuery     = "SELECT id,version,nats_seq FROM %s WHERE order_uid=$1 FOR UPDATE"
+	VersionQuery       = "SELECT version,nats_seq FROM %s WHERE id=$1"
+	InsertHistoryQuery = `
+INSERT INTO order_history (order_id,version,nats_seq,order_uid,track_number,entry,delivery,payment,items,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17)`
+	HistoryQuery = `
+SELECT version,nats_seq,replaced_at,order_uid,track_number,entry,delivery,payment,items,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard
+FROM order_history WHERE order_id=$1 ORDER BY version DESC`
+)
+
+// History returns every stored version of the order with id, newest first.
+// The first one is the current version.
+func (db *DBStore) History(ctx context.Context, id int) ([]store.Version, error) {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Get)
+	defer cancel()
+
+	cur := store.Version{}
+	seq := (*int64)(nil)
+	err := db.connPool.QueryRow(ctx, fmt.Sprintf(VersionQuery, db.table()), id).Scan(&cur.Version, &seq)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, Error404NotFound
+		}
+		return nil, err
+	}
+	cur.Nats_seq = unsequence(seq)
+	if cur.Model, err = db.getByID(ctx, db.connPool, id); err != nil {
+		return nil, err
+	}
+
+	res := []store.Version{cur}
+	err = queryEach(ctx, db.connPool, func(rows pgx.Rows) error {
+		v, m, replaced := store.Version{}, new(store.Model), time.Time{}
+		err := rows.Scan(
+			&v.Version,
+			&seq,
+			&replaced,
+			&m.Order_uid,
+			&m.Track_number,
+			&m.Entry,
+			&m.Delivery,
+			&m.Payment,
+			&m.Items,
+			&m.Locale,
+			&m.Internal_signature,
+			&m.Customer_id,
+			&m.Delivery_service,
+			&m.Shardkey,
+			&m.Sm_id,
+			&m.Date_created,
+			&m.Oof_shard,
+		)
+		if err != nil {
+			return err
+		}
+		v.Nats_seq, v.Replaced_at, v.Model = unsequence(seq), &replaced, m
+		res = append(res, v)
+		return nil
+	}, HistoryQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// write stores m in tx. An existing order is locked first and, unless m is
+// a redelivery of it, copied to order_history before it is replaced.
+func (db *DBStore) write(ctx context.Context, tx pgx.Tx, id *int, m *store.Model, seq *int64) error {
+	cur, version, curSeq := 0, 0, (*int64)(nil)
+	err := tx.QueryRow(ctx, fmt.Sprintf(LockOrderQuery, db.table()), m.Order_uid).Scan(&cur, &version, &curSeq)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+	case err != nil:
+		return err
+	default:
+		old, err := db.getByID(ctx, tx, cur)
+		if err != nil {
+			return err
+		}
+		if len(store.Diff(old, asStored(m))) == 0 {
+			*id = cur
+			return nil
+		}
+		_, err = tx.Exec(ctx, InsertHistoryQuery,
+			cur,
+			version,
+			curSeq,
+			old.Order_uid,
+			old.Track_number,
+			old.Entry,
+			old.Delivery,
+			old.Payment,
+			old.Items,
+			old.Locale,
+			old.Internal_signature,
+			old.Customer_id,
+			old.Delivery_service,
+			old.Shardkey,
+			old.Sm_id,
+			old.Date_created,
+			old.Oof_shard,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if db.mode == ModeNormalized {
+		return writeNormalized(ctx, tx, id, m, seq)
+	}
+	return writeJSON(ctx, tx, id, m, seq)
+}
+
+func (db *DBStore) getByID(ctx context.Context, q querier, id int) (*store.Model, error) {
+	var (
+		ids    []int
+		models map[int]*store.Model
+		err    error
+	)
+	if db.mode == ModeNormalized {
+		ids, models, err = selectNormalized(ctx, q, NormalizedGetQuery, id)
+	} else {
+		ids, models, err = selectJSON(ctx, q, fmt.Sprintf(GetQuery, id))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, Error404NotFound
+	}
+	return models[ids[0]], nil
+}
+
+// table is the table of the current order versions.
+func (db *DBStore) table() string {
+	if db.mode == ModeNormalized {
+		return "orders"
+	}
+	return "wb_data"
+}
+
+// asStored returns m as it reads back from the database: a TIMESTAMP column
+// keeps the wall clock of date_created in microseconds and drops the zone.
+func asStored(m *store.Model) *store.Model {
+	if m.Date_created == nil {
+		return m
+	}
+	t := m.Date_created.Truncate(time.Microsecond)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	res := *m
+	res.Date_created = &t
+	return &res
+}
+
+// sequence returns the NATS sequence stored in ctx, or nil if there is none.
+func sequence(ctx context.Context) *int64 {
+	seq, ok := store.SequenceFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	res := int64(seq)
+	return &res
+}
+
+func unsequence(seq *int64) uint64 {
+	if seq == nil {
+		return 0
+	}
+	return uint64(*seq)
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stretchr/testify/require"
+)
+
+func jsonRows(id int, m *store.Model) *pgxmock.Rows {
+	return pgxmock.NewRows([]string{
+		"id", "order_uid", "track_number", "entry", "delivery", "payment", "items", "locale",
+		"internal_signature", "customer_id", "delivery_service", "shardkey", "sm_id", "date_created", "oof_shard",
+	}).AddRow(
+		id,
+		m.Order_uid,
+		m.Track_number,
+		m.Entry,
+		m.Delivery,
+		m.Payment,
+		m.Items,
+		m.Locale,
+		m.Internal_signature,
+		m.Customer_id,
+		m.Delivery_service,
+		m.Shardkey,
+		m.Sm_id,
+		m.Date_created,
+		m.Oof_shard,
+	)
+}
+
+func TestDBStoreVersions(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Errorf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	dbStore := &DBStore{connPool: mock}
+	old := exampleModel
+	model := *exampleModel
+	model.Locale = "ru"
+	ctx := store.WithSequence(context.Background(), 42)
+	oldSeq, seq := int64(41), int64(42)
+
+	// Testing 'Set' of a changed order, expecting the old version in order_history
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id,version,nats_seq FROM wb_data").WithArgs(model.Order_uid).WillReturnRows(
+		pgxmock.NewRows([]string{"id", "version", "nats_seq"}).AddRow(3, 1, &oldSeq))
+	mock.ExpectQuery("SELECT (.+) FROM wb_data WHERE id=3").WillReturnRows(jsonRows(3, old))
+	mock.ExpectExec("INSERT INTO order_history").WithArgs(
+		3, 1, &oldSeq,
+		old.Order_uid,
+		old.Track_number,
+		old.Entry,
+		old.Delivery,
+		old.Payment,
+		old.Items,
+		old.Locale,
+		old.Internal_signature,
+		old.Customer_id,
+		old.Delivery_service,
+		old.Shardkey,
+		old.Sm_id,
+		old.Date_created,
+		old.Oof_shard,
+	).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectQuery("INSERT INTO wb_data").WithArgs(
+		model.Order_uid,
+		model.Track_number,
+		model.Entry,
+		model.Delivery,
+		model.Payment,
+		model.Items,
+		model.Locale,
+		model.Internal_signature,
+		model.Customer_id,
+		model.Delivery_service,
+		model.Shardkey,
+		model.Sm_id,
+		model.Date_created,
+		model.Oof_shard,
+		&seq,
+	).WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectCommit()
+	id := -1
+	require.NoError(t, dbStore.Set(ctx, &id, &model))
+	require.Equal(t, 3, id)
+
+	// Testing 'Set' of a redelivered order, expecting no new version
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id,version,nats_seq FROM wb_data").WithArgs(model.Order_uid).WillReturnRows(
+		pgxmock.NewRows([]string{"id", "version", "nats_seq"}).AddRow(3, 2, &seq))
+	mock.ExpectQuery("SELECT (.+) FROM wb_data WHERE id=3").WillReturnRows(jsonRows(3, &model))
+	mock.ExpectCommit()
+	id = -1
+	require.NoError(t, dbStore.Set(ctx, &id, &model))
+	require.Equal(t, 3, id)
+
+	// Testing 'History', expecting the current version first
+	replaced := time.Date(2021, 11, 27, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT version,nats_seq FROM wb_data WHERE id").WithArgs(3).WillReturnRows(
+		pgxmock.NewRows([]string{"version", "nats_seq"}).AddRow(2, &seq))
+	mock.ExpectQuery("SELECT (.+) FROM wb_data WHERE id=3").WillReturnRows(jsonRows(3, &model))
+	mock.ExpectQuery("SELECT (.+) FROM order_history WHERE order_id").WithArgs(3).WillReturnRows(pgxmock.NewRows([]string{
+		"version", "nats_seq", "replaced_at", "order_uid", "track_number", "entry", "delivery", "payment", "items",
+		"locale", "internal_signature", "customer_id", "delivery_service", "shardkey", "sm_id", "date_created", "oof_shard",
+	}).AddRow(
+		1, &oldSeq, replaced,
+		old.Order_uid,
+		old.Track_number,
+		old.Entry,
+		old.Delivery,
+		old.Payment,
+		old.Items,
+		old.Locale,
+		old.Internal_signature,
+		old.Customer_id,
+		old.Delivery_service,
+		old.Shardkey,
+		old.Sm_id,
+		old.Date_created,
+		old.Oof_shard,
+	))
+	res, err := dbStore.History(context.Background(), 3)
+	require.NoError(t, err)
+	require.Equal(t, []store.Version{
+		{Version: 2, Nats_seq: 42, Model: &model},
+		{Version: 1, Nats_seq: 41, Replaced_at: &replaced, Model: old},
+	}, res)
+
+	// Testing 'History', expecting Error404NotFound error
+	mock.ExpectQuery("SELECT version,nats_seq FROM wb_data WHERE id").WithArgs(4).WillReturnError(pgx.ErrNoRows)
+	res, err = dbStore.History(context.Background(), 4)
+	require.Nil(t, res)
+	require.ErrorIs(t, err, Error404NotFound)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAsStored(t *testing.T) {
+	date := time.Date(2021, 11, 26, 6, 22, 19, 1500, time.FixedZone("MSK", 3*60*60))
+	m := &store.Model{Order_uid: "uid", Date_created: &date}
+
+	// Testing 'asStored', expecting the wall clock in UTC without nanoseconds
+	res := asStored(m)
+	require.Equal(t, time.Date(2021, 11, 26, 6, 22, 19, 1000, time.UTC), *res.Date_created)
+	require.Equal(t, date, *m.Date_created)
+
+	// Testing 'asStored', expecting models without date_created as is
+	m.Date_created = nil
+	require.Same(t, m, asStored(m))
+}
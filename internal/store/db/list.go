@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ineverbee/wbl0/internal/store"
+)
+
+const (
+	DefaultListLimit = 20
+	MaxListLimit     = 100
+)
+
+var (
+	ListQuery           = "SELECT " + JSONColumns + " FROM wb_data%s ORDER BY date_created DESC, id DESC LIMIT %d"
+	NormalizedListQuery = "SELECT " + NormalizedOrderColumns + " FROM orders%s ORDER BY date_created DESC, id DESC LIMIT %d"
+)
+
+// List returns a page of orders, newest first, using keyset pagination on
+// (date_created, id).
+func (db *DBStore) List(ctx context.Context, f store.ListFilter) (*store.Page, error) {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Find)
+	defer cancel()
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	} else if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+	where, args := listWhere(f)
+
+	var (
+		ids    []int
+		models map[int]*store.Model
+		err    error
+	)
+	// One extra row tells whether there is a next page.
+	if db.mode == ModeNormalized {
+		ids, models, err = selectNormalized(ctx, db.connPool, fmt.Sprintf(NormalizedListQuery, where, limit+1), args...)
+	} else {
+		ids, models, err = selectJSON(ctx, db.connPool, fmt.Sprintf(ListQuery, where, limit+1), args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	page := &store.Page{Records: make([]store.Record, 0, limit)}
+	for i, id := range ids {
+		if i == limit {
+			last := page.Records[limit-1]
+			page.Next = &store.Cursor{ID: last.ID}
+			if last.Model.Date_created != nil {
+				page.Next.Date_created = *last.Model.Date_created
+			}
+			break
+		}
+		page.Records = append(page.Records, store.Record{ID: id, Model: models[id]})
+	}
+	return page, nil
+}
+
+// listWhere builds the WHERE clause shared by both storage modes, whose
+// order tables have the same top-level columns.
+func listWhere(f store.ListFilter) (string, []interface{}) {
+	conds, args := make([]string, 0), make([]interface{}, 0)
+	add := func(cond string, vals ...interface{}) {
+		for _, v := range vals {
+			args = append(args, v)
+			cond = strings.Replace(cond, "?", "$"+strconv.Itoa(len(args)), 1)
+		}
+		conds = append(conds, cond)
+	}
+	if f.Customer_id != "" {
+		add("customer_id=?", f.Customer_id)
+	}
+	if f.Delivery_service != "" {
+		add("delivery_service=?", f.Delivery_service)
+	}
+	if f.Locale != "" {
+		add("locale=?", f.Locale)
+	}
+	if f.From != nil {
+		add("date_created>=?", *f.From)
+	}
+	if f.To != nil {
+		add("date_created<?", *f.To)
+	}
+	if f.After != nil {
+		add("(date_created, id) < (?, ?)", f.After.Date_created, f.After.ID)
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
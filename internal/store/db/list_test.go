@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListWhere(t *testing.T) {
+	where, args := listWhere(store.ListFilter{})
+	require.Empty(t, where)
+	require.Empty(t, args)
+
+	from, to := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	where, args = listWhere(store.ListFilter{
+		Customer_id:      "test",
+		Delivery_service: "meest",
+		Locale:           "en",
+		From:             &from,
+		To:               &to,
+		After:            &store.Cursor{Date_created: to, ID: 7},
+	})
+	require.Equal(t, " WHERE customer_id=$1 AND delivery_service=$2 AND locale=$3"+
+		" AND date_created>=$4 AND date_created<$5 AND (date_created, id) < ($6, $7)", where)
+	require.Equal(t, []interface{}{"test", "meest", "en", from, to, to, 7}, args)
+}
+
+func TestDBStoreList(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Errorf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	ctx := context.Background()
+	model := exampleModel
+	dbStore := &DBStore{connPool: mock}
+	rows := func(ids ...int) *pgxmock.Rows {
+		rows := pgxmock.NewRows([]string{
+			"id", "order_uid", "track_number", "entry", "delivery", "payment", "items", "locale",
+			"internal_signature", "customer_id", "delivery_service", "shardkey", "sm_id", "date_created", "oof_shard",
+		})
+		for _, id := range ids {
+			rows.AddRow(
+				id,
+				model.Order_uid,
+				model.Track_number,
+				model.Entry,
+				model.Delivery,
+				model.Payment,
+				model.Items,
+				model.Locale,
+				model.Internal_signature,
+				model.Customer_id,
+				model.Delivery_service,
+				model.Shardkey,
+				model.Sm_id,
+				model.Date_created,
+				model.Oof_shard,
+			)
+		}
+		return rows
+	}
+
+	// Testing 'List', expecting a full page and a cursor to the next one
+	mock.ExpectQuery("SELECT (.+) FROM wb_data WHERE customer_id=\\$1 ORDER BY date_created DESC, id DESC LIMIT 3").
+		WithArgs("test").WillReturnRows(rows(9, 8, 7))
+	page, err := dbStore.List(ctx, store.ListFilter{Customer_id: "test", Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []store.Record{{ID: 9, Model: model}, {ID: 8, Model: model}}, page.Records)
+	require.Equal(t, &store.Cursor{Date_created: *model.Date_created, ID: 8}, page.Next)
+
+	// Testing 'List', expecting the last page
+	mock.ExpectQuery("SELECT (.+) FROM wb_data WHERE \\(date_created, id\\) < \\(\\$1, \\$2\\) (.+) LIMIT 3").
+		WithArgs(*model.Date_created, 8).WillReturnRows(rows(7))
+	page, err = dbStore.List(ctx, store.ListFilter{After: page.Next, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []store.Record{{ID: 7, Model: model}}, page.Records)
+	require.Nil(t, page.Next)
+
+	// Testing 'List', expecting any error
+	mock.ExpectQuery("SELECT (.+) FROM wb_data ORDER BY (.+) LIMIT 21").WillReturnError(pgx.ErrTxClosed)
+	page, err = dbStore.List(ctx, store.ListFilter{})
+	require.Nil(t, page)
+	require.ErrorIs(t, err, pgx.ErrTxClosed)
+
+	// Testing 'List' in normalized mode, expecting the limit to be capped
+	dbStore.mode = ModeNormalized
+	mock.ExpectQuery("SELECT (.+) FROM orders ORDER BY (.+) LIMIT 101").WillReturnRows(pgxmock.NewRows([]string{"id"}))
+	page, err = dbStore.List(ctx, store.ListFilter{Limit: 1000})
+	require.NoError(t, err)
+	require.Empty(t, page.Records)
+	require.Nil(t, page.Next)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,230 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	ErrorSchemaMismatch = fmt.Errorf("error: database schema version mismatch")
+	ErrorNoDownStep     = fmt.Errorf("error: migration has no down step")
+
+	// migrationLockID is the pg_advisory_xact_lock key that serializes
+	// migrations between replicas started at the same time.
+	migrationLockID int64 = 0x77626c30
+
+	LockMigrationsQuery   = "SELECT pg_advisory_xact_lock($1)"
+	CreateMigrationsQuery = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    "version" INT NOT NULL PRIMARY KEY,
+    "name" VARCHAR(255) NOT NULL,
+    "applied_at" TIMESTAMP NOT NULL DEFAULT now()
+)`
+	MigrationsExistQuery  = "SELECT to_regclass('schema_migrations') IS NOT NULL"
+	SchemaVersionQuery    = "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"
+	InsertMigrationQuery  = "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)"
+	DeleteMigrationQuery  = "DELETE FROM schema_migrations WHERE version=$1"
+	migrationFileRegexp   = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+	embeddedMigrationsDir = "migrations"
+)
+
+//go:embed migrations/*.sql
+var embeddedMigrations embed.FS
+
+// Migration is a single versioned schema change. Down is empty when the
+// change can't be reverted.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrator applies the embedded migrations in version order and records
+// every applied version in the schema_migrations table.
+type Migrator struct {
+	connPool   PoolIface
+	migrations []Migration
+}
+
+func NewMigrator(connPool PoolIface) (*Migrator, error) {
+	sub, err := fs.Sub(embeddedMigrations, embeddedMigrationsDir)
+	if err != nil {
+		return nil, err
+	}
+	return newMigrator(connPool, sub)
+}
+
+func newMigrator(connPool PoolIface, fsys fs.FS) (*Migrator, error) {
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{connPool, migrations}, nil
+}
+
+// loadMigrations reads "<version>_<name>.(up|down).sql" files from the root
+// of fsys and returns them sorted by version.
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		match := migrationFileRegexp.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("error: bad migration file name '%s'", e.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		if version <= 0 {
+			return nil, fmt.Errorf("error: migration version should be positive in '%s'", e.Name())
+		}
+		data, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("error: duplicate migration version %d", version)
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+	res := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("error: migration %d has no up step", m.Version)
+		}
+		res = append(res, *m)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res, nil
+}
+
+// Latest returns the version the schema has after all known migrations.
+func (mg *Migrator) Latest() int {
+	if len(mg.migrations) == 0 {
+		return 0
+	}
+	return mg.migrations[len(mg.migrations)-1].Version
+}
+
+// Version returns the currently applied schema version, 0 for an empty
+// database.
+func (mg *Migrator) Version(ctx context.Context) (int, error) {
+	exists := false
+	err := mg.connPool.QueryRow(ctx, MigrationsExistQuery).Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+	version := 0
+	err = mg.connPool.QueryRow(ctx, SchemaVersionQuery).Scan(&version)
+	return version, err
+}
+
+// Check returns ErrorSchemaMismatch unless the database is exactly at the
+// latest known version.
+func (mg *Migrator) Check(ctx context.Context) error {
+	version, err := mg.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version != mg.Latest() {
+		return fmt.Errorf("%w: database at %d, expected %d", ErrorSchemaMismatch, version, mg.Latest())
+	}
+	return nil
+}
+
+// Up applies all pending migrations in one transaction and returns how many
+// were applied.
+func (mg *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := mg.inLockedTx(ctx, func(tx pgx.Tx, version int) error {
+		if version > mg.Latest() {
+			return fmt.Errorf("%w: database at %d is newer than %d", ErrorSchemaMismatch, version, mg.Latest())
+		}
+		for _, m := range mg.migrations {
+			if m.Version <= version {
+				continue
+			}
+			log.Printf("[MIGRATE] Applying %d_%s\n", m.Version, m.Name)
+			if _, err := tx.Exec(ctx, m.Up); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			if _, err := tx.Exec(ctx, InsertMigrationQuery, m.Version, m.Name); err != nil {
+				return err
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts up to steps of the most recently applied migrations in one
+// transaction and returns how many were reverted.
+func (mg *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	reverted := 0
+	err := mg.inLockedTx(ctx, func(tx pgx.Tx, version int) error {
+		for i := len(mg.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			m := mg.migrations[i]
+			if m.Version > version {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("%w: %d_%s", ErrorNoDownStep, m.Version, m.Name)
+			}
+			log.Printf("[MIGRATE] Reverting %d_%s\n", m.Version, m.Name)
+			if _, err := tx.Exec(ctx, m.Down); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			if _, err := tx.Exec(ctx, DeleteMigrationQuery, m.Version); err != nil {
+				return err
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+func (mg *Migrator) inLockedTx(ctx context.Context, f func(pgx.Tx, int) error) error {
+	tx, err := mg.connPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, LockMigrationsQuery, migrationLockID); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, CreateMigrationsQuery); err != nil {
+		return err
+	}
+	version := 0
+	if err = tx.QueryRow(ctx, SchemaVersionQuery).Scan(&version); err != nil {
+		return err
+	}
+	if err = f(tx, version); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
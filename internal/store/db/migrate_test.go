@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stretchr/testify/require"
+)
+
+var exampleMigrations = fstest.MapFS{
+	"0002_second.up.sql":   {Data: []byte("CREATE TABLE second")},
+	"0002_second.down.sql": {Data: []byte("DROP TABLE second")},
+	"0001_first.up.sql":    {Data: []byte("CREATE TABLE first")},
+}
+
+func TestLoadMigrations(t *testing.T) {
+	res, err := loadMigrations(exampleMigrations)
+	require.NoError(t, err)
+	require.Equal(t, []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE first"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE second", Down: "DROP TABLE second"},
+	}, res)
+
+	// Embedded migrations should always load
+	mg, err := NewMigrator(nil)
+	require.NoError(t, err)
+	require.Greater(t, mg.Latest(), 0)
+
+	tc := []fstest.MapFS{
+		{"first.up.sql": {}},
+		{"0001_first.sql": {}},
+		{"0000_zero.up.sql": {Data: []byte("SELECT 1")}},
+		{"0001_first.down.sql": {Data: []byte("SELECT 1")}},
+		{"0001_first.up.sql": {Data: []byte("SELECT 1")}, "0001_other.up.sql": {Data: []byte("SELECT 1")}},
+	}
+	for _, c := range tc {
+		res, err = loadMigrations(c)
+		require.Error(t, err)
+		require.Nil(t, res)
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Errorf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	mg, err := newMigrator(mock, exampleMigrations)
+	require.NoError(t, err)
+	require.Equal(t, 2, mg.Latest())
+	ctx := context.Background()
+
+	expectLockedTx := func(version int) {
+		mock.ExpectBegin()
+		mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(migrationLockID).WillReturnResult(pgxmock.NewResult("SELECT", 1))
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(pgxmock.NewResult("CREATE", 0))
+		mock.ExpectQuery("SELECT (.+) FROM schema_migrations").WillReturnRows(pgxmock.NewRows([]string{"version"}).AddRow(version))
+	}
+
+	// Testing 'Up', applying only the second migration
+	expectLockedTx(1)
+	mock.ExpectExec("CREATE TABLE second").WillReturnResult(pgxmock.NewResult("CREATE", 0))
+	mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(2, "second").WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectCommit()
+	n, err := mg.Up(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	// Testing 'Up', expecting migration error and rollback
+	expectLockedTx(0)
+	mock.ExpectExec("CREATE TABLE first").WillReturnError(pgx.ErrTxClosed)
+	mock.ExpectRollback()
+	_, err = mg.Up(ctx)
+	require.ErrorIs(t, err, pgx.ErrTxClosed)
+
+	// Testing 'Up', expecting ErrorSchemaMismatch on a newer database
+	expectLockedTx(3)
+	mock.ExpectRollback()
+	_, err = mg.Up(ctx)
+	require.ErrorIs(t, err, ErrorSchemaMismatch)
+
+	// Testing 'Down', reverting the second migration
+	expectLockedTx(2)
+	mock.ExpectExec("DROP TABLE second").WillReturnResult(pgxmock.NewResult("DROP", 0))
+	mock.ExpectExec("DELETE FROM schema_migrations").WithArgs(2).WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mock.ExpectCommit()
+	n, err = mg.Down(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	// Testing 'Down', expecting ErrorNoDownStep
+	expectLockedTx(1)
+	mock.ExpectRollback()
+	_, err = mg.Down(ctx, 1)
+	require.ErrorIs(t, err, ErrorNoDownStep)
+
+	// Testing 'Check', not expecting any error
+	mock.ExpectQuery("SELECT to_regclass").WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT (.+) FROM schema_migrations").WillReturnRows(pgxmock.NewRows([]string{"version"}).AddRow(2))
+	require.NoError(t, mg.Check(ctx))
+
+	// Testing 'Check', expecting ErrorSchemaMismatch on an empty database
+	mock.ExpectQuery("SELECT to_regclass").WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+	require.ErrorIs(t, mg.Check(ctx), ErrorSchemaMismatch)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS wb_data;
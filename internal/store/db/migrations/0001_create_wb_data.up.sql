@@ -1,8 +1,4 @@
-CREATE DATABASE wb_db;    
-
-\c wb_db; 
-
-CREATE TABLE wb_data (
+CREATE TABLE IF NOT EXISTS wb_data (
     "id" SERIAL NOT NULL PRIMARY KEY,
     "order_uid" VARCHAR(50),
     "track_number" VARCHAR(50),
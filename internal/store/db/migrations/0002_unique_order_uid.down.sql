@@ -0,0 +1,2 @@
+ALTER TABLE wb_data DROP CONSTRAINT IF EXISTS wb_data_order_uid_key;
+DROP INDEX IF EXISTS wb_data_order_uid_key;
@@ -0,0 +1,7 @@
+-- Older databases may already hold redelivered duplicates, keep the newest
+-- row, as the last redelivery wins like an upsert would.
+DELETE FROM wb_data a USING wb_data b
+WHERE a.order_uid = b.order_uid AND a.id < b.id;
+
+-- Databases created from the old init.sql already have this constraint.
+CREATE UNIQUE INDEX IF NOT EXISTS wb_data_order_uid_key ON wb_data (order_uid);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS order_items;
+DROP TABLE IF EXISTS payments;
+DROP TABLE IF EXISTS deliveries;
+DROP TABLE IF EXISTS orders;
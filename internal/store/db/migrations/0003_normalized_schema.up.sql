@@ -0,0 +1,63 @@
+CREATE TABLE IF NOT EXISTS orders (
+    "id" SERIAL NOT NULL PRIMARY KEY,
+    "order_uid" VARCHAR(50) NOT NULL UNIQUE,
+    "track_number" VARCHAR(50),
+    "entry" VARCHAR(50),
+    "locale" VARCHAR(10),
+    "internal_signature" VARCHAR(50),
+    "customer_id" VARCHAR(50),
+    "delivery_service" VARCHAR(50),
+    "shardkey" VARCHAR(50),
+    "sm_id" INT,
+    "date_created" TIMESTAMP,
+    "oof_shard" VARCHAR(50)
+);
+
+CREATE TABLE IF NOT EXISTS deliveries (
+    "order_id" INT NOT NULL PRIMARY KEY REFERENCES orders (id) ON DELETE CASCADE,
+    "name" VARCHAR(255) NOT NULL DEFAULT '',
+    "phone" VARCHAR(50) NOT NULL DEFAULT '',
+    "zip" VARCHAR(50) NOT NULL DEFAULT '',
+    "city" VARCHAR(255) NOT NULL DEFAULT '',
+    "address" VARCHAR(255) NOT NULL DEFAULT '',
+    "region" VARCHAR(255) NOT NULL DEFAULT '',
+    "email" VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS payments (
+    "order_id" INT NOT NULL PRIMARY KEY REFERENCES orders (id) ON DELETE CASCADE,
+    "transaction" VARCHAR(50) NOT NULL DEFAULT '',
+    "request_id" VARCHAR(50) NOT NULL DEFAULT '',
+    "currency" VARCHAR(10) NOT NULL DEFAULT '',
+    "provider" VARCHAR(255) NOT NULL DEFAULT '',
+    "bank" VARCHAR(255) NOT NULL DEFAULT '',
+    "amount" BIGINT NOT NULL DEFAULT 0,
+    "payment_dt" BIGINT NOT NULL DEFAULT 0,
+    "delivery_cost" BIGINT NOT NULL DEFAULT 0,
+    "goods_total" BIGINT NOT NULL DEFAULT 0,
+    "custom_fee" BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS order_items (
+    "order_id" INT NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    "position" INT NOT NULL,
+    "track_number" VARCHAR(50) NOT NULL DEFAULT '',
+    "rid" VARCHAR(50) NOT NULL DEFAULT '',
+    "name" VARCHAR(255) NOT NULL DEFAULT '',
+    "size" VARCHAR(50) NOT NULL DEFAULT '',
+    "brand" VARCHAR(255) NOT NULL DEFAULT '',
+    "chrt_id" BIGINT NOT NULL DEFAULT 0,
+    "price" BIGINT NOT NULL DEFAULT 0,
+    "sale" BIGINT NOT NULL DEFAULT 0,
+    "total_price" BIGINT NOT NULL DEFAULT 0,
+    "nm_id" BIGINT NOT NULL DEFAULT 0,
+    "status" INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (order_id, position)
+);
+
+CREATE INDEX IF NOT EXISTS order_items_nm_id_idx ON order_items (nm_id);
+CREATE INDEX IF NOT EXISTS order_items_chrt_id_idx ON order_items (chrt_id);
+CREATE INDEX IF NOT EXISTS order_items_rid_idx ON order_items (rid);
+
+-- The orders of wb_data are copied by DBStore.Backfill once the store runs in
+-- normalized mode.
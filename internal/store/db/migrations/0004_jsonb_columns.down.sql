@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS deliveries_email_idx;
+DROP INDEX IF EXISTS payments_transaction_idx;
+DROP INDEX IF EXISTS order_items_brand_idx;
+
+DROP INDEX IF EXISTS wb_data_items_gin_idx;
+DROP INDEX IF EXISTS wb_data_payment_gin_idx;
+DROP INDEX IF EXISTS wb_data_delivery_gin_idx;
+
+ALTER TABLE wb_data
+    ALTER COLUMN "delivery" TYPE JSON USING "delivery"::JSON,
+    ALTER COLUMN "payment" TYPE JSON USING "payment"::JSON,
+    ALTER COLUMN "items" TYPE JSON USING "items"::JSON;
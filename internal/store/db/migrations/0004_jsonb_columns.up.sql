@@ -0,0 +1,13 @@
+ALTER TABLE wb_data
+    ALTER COLUMN "delivery" TYPE JSONB USING "delivery"::JSONB,
+    ALTER COLUMN "payment" TYPE JSONB USING "payment"::JSONB,
+    ALTER COLUMN "items" TYPE JSONB USING "items"::JSONB;
+
+CREATE INDEX IF NOT EXISTS wb_data_delivery_gin_idx ON wb_data USING GIN ("delivery" jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS wb_data_payment_gin_idx ON wb_data USING GIN ("payment" jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS wb_data_items_gin_idx ON wb_data USING GIN ("items" jsonb_path_ops);
+
+-- Same lookups for the normalized storage mode.
+CREATE INDEX IF NOT EXISTS order_items_brand_idx ON order_items (brand);
+CREATE INDEX IF NOT EXISTS payments_transaction_idx ON payments (transaction);
+CREATE INDEX IF NOT EXISTS deliveries_email_idx ON deliveries (email);
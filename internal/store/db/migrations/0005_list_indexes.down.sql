@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS orders_customer_id_date_created_idx;
+DROP INDEX IF EXISTS orders_date_created_id_idx;
+
+DROP INDEX IF EXISTS wb_data_customer_id_date_created_idx;
+DROP INDEX IF EXISTS wb_data_date_created_id_idx;
@@ -0,0 +1,5 @@
+CREATE INDEX IF NOT EXISTS wb_data_date_created_id_idx ON wb_data (date_created DESC, id DESC);
+CREATE INDEX IF NOT EXISTS wb_data_customer_id_date_created_idx ON wb_data (customer_id, date_created DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS orders_date_created_id_idx ON orders (date_created DESC, id DESC);
+CREATE INDEX IF NOT EXISTS orders_customer_id_date_created_idx ON orders (customer_id, date_created DESC, id DESC);
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS order_history;
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS "nats_seq",
+    DROP COLUMN IF EXISTS "version";
+
+ALTER TABLE wb_data
+    DROP COLUMN IF EXISTS "nats_seq",
+    DROP COLUMN IF EXISTS "version";
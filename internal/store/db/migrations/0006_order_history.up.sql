@@ -0,0 +1,32 @@
+ALTER TABLE wb_data
+    ADD COLUMN IF NOT EXISTS "version" INT NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS "nats_seq" BIGINT;
+
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS "version" INT NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS "nats_seq" BIGINT;
+
+-- Replaced versions of an order in both storage modes. "order_id" is the id
+-- of the order in wb_data or orders.
+CREATE TABLE IF NOT EXISTS order_history (
+    "id" SERIAL NOT NULL PRIMARY KEY,
+    "order_id" INT NOT NULL,
+    "version" INT NOT NULL,
+    "nats_seq" BIGINT,
+    "replaced_at" TIMESTAMP NOT NULL DEFAULT now(),
+    "order_uid" VARCHAR(50) NOT NULL,
+    "track_number" VARCHAR(50),
+    "entry" VARCHAR(50),
+    "delivery" JSONB,
+    "payment" JSONB,
+    "items" JSONB,
+    "locale" VARCHAR(10),
+    "internal_signature" VARCHAR(50),
+    "customer_id" VARCHAR(50),
+    "delivery_service" VARCHAR(50),
+    "shardkey" VARCHAR(50),
+    "sm_id" INT,
+    "date_created" TIMESTAMP,
+    "oof_shard" VARCHAR(50),
+    UNIQUE ("order_id", "version")
+);
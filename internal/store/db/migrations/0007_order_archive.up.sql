@@ -0,0 +1,24 @@
+-- Orders moved out of wb_data or orders by the retention job, in the wb_data
+-- layout for both storage modes. "id" keeps the id the order had.
+CREATE TABLE IF NOT EXISTS order_archive (
+    "id" INT NOT NULL PRIMARY KEY,
+    "order_uid" VARCHAR(50) NOT NULL,
+    "track_number" VARCHAR(50),
+    "entry" VARCHAR(50),
+    "delivery" JSONB,
+    "payment" JSONB,
+    "items" JSONB,
+    "locale" VARCHAR(10),
+    "internal_signature" VARCHAR(50),
+    "customer_id" VARCHAR(50),
+    "delivery_service" VARCHAR(50),
+    "shardkey" VARCHAR(50),
+    "sm_id" INT,
+    "date_created" TIMESTAMP,
+    "oof_shard" VARCHAR(50),
+    "version" INT NOT NULL DEFAULT 1,
+    "nats_seq" BIGINT,
+    "archived_at" TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS order_archive_order_uid_idx ON order_archive (order_uid);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS orders_notify ON orders;
+DROP TRIGGER IF EXISTS wb_data_notify ON wb_data;
+DROP FUNCTION IF EXISTS notify_order_changed();
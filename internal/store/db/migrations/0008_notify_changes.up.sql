@@ -0,0 +1,23 @@
+-- Every change of an order is announced on the orders_changed channel with
+-- the order id, so that every instance can refresh its cache. Listeners get
+-- the notification once the transaction commits.
+CREATE OR REPLACE FUNCTION notify_order_changed() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('orders_changed', OLD.id::text);
+    ELSE
+        PERFORM pg_notify('orders_changed', NEW.id::text);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS wb_data_notify ON wb_data;
+CREATE TRIGGER wb_data_notify
+    AFTER INSERT OR UPDATE OR DELETE ON wb_data
+    FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
+
+DROP TRIGGER IF EXISTS orders_notify ON orders;
+CREATE TRIGGER orders_notify
+    AFTER INSERT OR UPDATE OR DELETE ON orders
+    FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
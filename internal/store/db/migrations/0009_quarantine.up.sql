@@ -0,0 +1,13 @@
+-- Messages rejected by the worker, kept to be fixed and resubmitted.
+-- Redelivered messages are recognized by their channel and NATS sequence.
+CREATE TABLE IF NOT EXISTS quarantine (
+    "id" SERIAL NOT NULL PRIMARY KEY,
+    "data" BYTEA NOT NULL,
+    "class" VARCHAR(20) NOT NULL,
+    "reason" TEXT NOT NULL,
+    "channel" VARCHAR(255) NOT NULL,
+    "nats_seq" BIGINT NOT NULL,
+    "published_at" TIMESTAMP NOT NULL,
+    "quarantined_at" TIMESTAMP NOT NULL DEFAULT now(),
+    UNIQUE ("channel", "nats_seq")
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS order_archive_archived_at_idx;
+DROP INDEX IF EXISTS order_history_replaced_at_idx;
@@ -0,0 +1,4 @@
+-- Find the orders replaced or archived since a cache snapshot, which are read
+-- again when it's restored.
+CREATE INDEX IF NOT EXISTS order_history_replaced_at_idx ON order_history (replaced_at);
+CREATE INDEX IF NOT EXISTS order_archive_archived_at_idx ON order_archive (archived_at);
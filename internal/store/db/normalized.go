@@ -0,0 +1,211 @@
+package db
+
+import (
+	"context"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	NormalizedSetOrderQuery = `
+INSERT INTO orders (order_uid,track_number,entry,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard,nats_seq)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+ON CONFLICT (order_uid) DO UPDATE SET
+track_number=EXCLUDED.track_number,entry=EXCLUDED.entry,locale=EXCLUDED.locale,internal_signature=EXCLUDED.internal_signature,
+customer_id=EXCLUDED.customer_id,delivery_service=EXCLUDED.delivery_service,shardkey=EXCLUDED.shardkey,sm_id=EXCLUDED.sm_id,
+date_created=EXCLUDED.date_created,oof_shard=EXCLUDED.oof_shard,version=orders.version+1,nats_seq=EXCLUDED.nats_seq
+RETURNING id`
+	NormalizedSetDeliveryQuery = `
+INSERT INTO deliveries (order_id,name,phone,zip,city,address,region,email)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+ON CONFLICT (order_id) DO UPDATE SET
+name=EXCLUDED.name,phone=EXCLUDED.phone,zip=EXCLUDED.zip,city=EXCLUDED.city,address=EXCLUDED.address,
+region=EXCLUDED.region,email=EXCLUDED.email`
+	NormalizedSetPaymentQuery = `
+INSERT INTO payments (order_id,transaction,request_id,currency,provider,bank,amount,payment_dt,delivery_cost,goods_total,custom_fee)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+ON CONFLICT (order_id) DO UPDATE SET
+transaction=EXCLUDED.transaction,request_id=EXCLUDED.request_id,currency=EXCLUDED.currency,provider=EXCLUDED.provider,
+bank=EXCLUDED.bank,amount=EXCLUDED.amount,payment_dt=EXCLUDED.payment_dt,delivery_cost=EXCLUDED.delivery_cost,
+goods_total=EXCLUDED.goods_total,custom_fee=EXCLUDED.custom_fee`
+	NormalizedDeleteItemsQuery = "DELETE FROM order_items WHERE order_id=$1"
+
+	NormalizedOrderColumns  = "id,order_uid,track_number,entry,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard"
+	NormalizedGetQuery      = "SELECT " + NormalizedOrderColumns + " FROM orders WHERE id=$1"
+	NormalizedGetByUIDQuery = "SELECT " + NormalizedOrderColumns + " FROM orders WHERE order_uid=$1"
+	NormalizedGetAllQuery   = "SELECT " + NormalizedOrderColumns + " FROM orders"
+
+	NormalizedDeliveriesQuery = "SELECT order_id,name,phone,zip,city,address,region,email FROM deliveries WHERE order_id = ANY($1)"
+	NormalizedPaymentsQuery   = `
+SELECT order_id,transaction,request_id,currency,provider,bank,amount,payment_dt,delivery_cost,goods_total,custom_fee
+FROM payments WHERE order_id = ANY($1)`
+	NormalizedItemsQuery = `
+SELECT order_id,track_number,rid,name,size,brand,chrt_id,price,sale,total_price,nm_id,status
+FROM order_items WHERE order_id = ANY($1) ORDER BY order_id,position`
+
+	orderItemsTable   = pgx.Identifier{"order_items"}
+	orderItemsColumns = []string{
+		"order_id", "position", "track_number", "rid", "name", "size", "brand",
+		"chrt_id", "price", "sale", "total_price", "nm_id", "status",
+	}
+)
+
+// writeNormalized upserts the order row and replaces its delivery, payment
+// and items.
+func writeNormalized(ctx context.Context, tx pgx.Tx, id *int, m *store.Model, seq *int64) error {
+	err := tx.QueryRow(
+		ctx, NormalizedSetOrderQuery,
+		m.Order_uid,
+		m.Track_number,
+		m.Entry,
+		m.Locale,
+		m.Internal_signature,
+		m.Customer_id,
+		m.Delivery_service,
+		m.Shardkey,
+		m.Sm_id,
+		m.Date_created,
+		m.Oof_shard,
+		seq,
+	).Scan(id)
+	if err != nil {
+		return err
+	}
+	if d := m.Delivery; d != nil {
+		_, err = tx.Exec(ctx, NormalizedSetDeliveryQuery,
+			*id, d.Name, d.Phone, d.Zip, d.City, d.Address, d.Region, d.Email)
+		if err != nil {
+			return err
+		}
+	}
+	if p := m.Payment; p != nil {
+		_, err = tx.Exec(ctx, NormalizedSetPaymentQuery,
+			*id, p.Transaction, p.Request_id, p.Currency, p.Provider, p.Bank,
+			p.Amount, p.Payment_dt, p.Delivery_cost, p.Goods_total, p.Custom_fee)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(ctx, NormalizedDeleteItemsQuery, *id)
+	if err != nil {
+		return err
+	}
+	if len(m.Items) > 0 {
+		rows := make([][]interface{}, 0, len(m.Items))
+		for i, it := range m.Items {
+			rows = append(rows, []interface{}{
+				*id, i + 1, it.Track_number, it.Rid, it.Name, it.Size, it.Brand,
+				it.Chrt_id, it.Price, it.Sale, it.Total_price, it.Nm_id, it.Status,
+			})
+		}
+		_, err = tx.CopyFrom(ctx, orderItemsTable, orderItemsColumns, pgx.CopyFromRows(rows))
+		return err
+	}
+	return nil
+}
+
+func (db *DBStore) getNormalized(ctx context.Context, query string, args ...interface{}) (*store.Model, error) {
+	ids, models, err := selectNormalized(ctx, db.connPool, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, Error404NotFound
+	}
+	return models[ids[0]], nil
+}
+
+// selectNormalized runs a query over the orders table and fills delivery,
+// payment and items of every returned order. The ids are returned in the
+// order of the query.
+func selectNormalized(ctx context.Context, q querier, query string, args ...interface{}) ([]int, map[int]*store.Model, error) {
+	rows, err := q.Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids, models := make([]int, 0), make(map[int]*store.Model)
+	for rows.Next() {
+		id, temp := 0, &store.Model{Items: make([]*store.Item, 0)}
+		err = rows.Scan(
+			&id,
+			&temp.Order_uid,
+			&temp.Track_number,
+			&temp.Entry,
+			&temp.Locale,
+			&temp.Internal_signature,
+			&temp.Customer_id,
+			&temp.Delivery_service,
+			&temp.Shardkey,
+			&temp.Sm_id,
+			&temp.Date_created,
+			&temp.Oof_shard,
+		)
+		if err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		models[id] = temp
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(ids) == 0 {
+		return ids, models, nil
+	}
+	if err = hydrateNormalized(ctx, q, ids, models); err != nil {
+		return nil, nil, err
+	}
+	return ids, models, nil
+}
+
+func hydrateNormalized(ctx context.Context, q querier, ids []int, models map[int]*store.Model) error {
+	err := queryEach(ctx, q, func(rows pgx.Rows) error {
+		id, d := 0, new(store.Delivery)
+		err := rows.Scan(&id, &d.Name, &d.Phone, &d.Zip, &d.City, &d.Address, &d.Region, &d.Email)
+		if m, ok := models[id]; ok && err == nil {
+			m.Delivery = d
+		}
+		return err
+	}, NormalizedDeliveriesQuery, ids)
+	if err != nil {
+		return err
+	}
+	err = queryEach(ctx, q, func(rows pgx.Rows) error {
+		id, p := 0, new(store.Payment)
+		err := rows.Scan(&id, &p.Transaction, &p.Request_id, &p.Currency, &p.Provider, &p.Bank,
+			&p.Amount, &p.Payment_dt, &p.Delivery_cost, &p.Goods_total, &p.Custom_fee)
+		if m, ok := models[id]; ok && err == nil {
+			m.Payment = p
+		}
+		return err
+	}, NormalizedPaymentsQuery, ids)
+	if err != nil {
+		return err
+	}
+	return queryEach(ctx, q, func(rows pgx.Rows) error {
+		id, it := 0, new(store.Item)
+		err := rows.Scan(&id, &it.Track_number, &it.Rid, &it.Name, &it.Size, &it.Brand,
+			&it.Chrt_id, &it.Price, &it.Sale, &it.Total_price, &it.Nm_id, &it.Status)
+		if m, ok := models[id]; ok && err == nil {
+			m.Items = append(m.Items, it)
+		}
+		return err
+	}, NormalizedItemsQuery, ids)
+}
+
+func queryEach(ctx context.Context, q querier, f func(pgx.Rows) error, query string, args ...interface{}) error {
+	rows, err := q.Query(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err = f(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
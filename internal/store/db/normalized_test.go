@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStorageMode(t *testing.T) {
+	tc := []struct {
+		input string
+		mode  StorageMode
+		err   bool
+	}{
+		{"", ModeJSON, false},
+		{"json", ModeJSON, false},
+		{"normalized", ModeNormalized, false},
+		{"yaml", ModeJSON, true},
+	}
+	for _, c := range tc {
+		mode, err := ParseStorageMode(c.input)
+		require.Equal(t, c.mode, mode)
+		require.Equal(t, c.err, err != nil)
+	}
+}
+
+func TestDBStoreNormalized(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Errorf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	ctx := context.Background()
+	id, model := 0, exampleModel
+	dbStore := &DBStore{connPool: mock, mode: ModeNormalized}
+	d, p, it := model.Delivery, model.Payment, model.Items[0]
+
+	expectSetOrder := func() *pgxmock.ExpectedQuery {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id,version,nats_seq FROM orders WHERE order_uid(.+) FOR UPDATE").WithArgs(
+			model.Order_uid,
+		).WillReturnError(pgx.ErrNoRows)
+		return mock.ExpectQuery("INSERT INTO orders (.+) ON CONFLICT \\(order_uid\\) DO UPDATE").WithArgs(
+			model.Order_uid,
+			model.Track_number,
+			model.Entry,
+			model.Locale,
+			model.Internal_signature,
+			model.Customer_id,
+			model.Delivery_service,
+			model.Shardkey,
+			model.Sm_id,
+			model.Date_created,
+			model.Oof_shard,
+			(*int64)(nil),
+		)
+	}
+
+	// Testing 'Set', not expecting any error
+	expectSetOrder().WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec("INSERT INTO deliveries").WithArgs(
+		7, d.Name, d.Phone, d.Zip, d.City, d.Address, d.Region, d.Email,
+	).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectExec("INSERT INTO payments").WithArgs(
+		7, p.Transaction, p.Request_id, p.Currency, p.Provider, p.Bank,
+		p.Amount, p.Payment_dt, p.Delivery_cost, p.Goods_total, p.Custom_fee,
+	).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectExec("DELETE FROM order_items").WithArgs(7).WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	mock.ExpectCopyFrom(`"order_items"`, orderItemsColumns).WillReturnResult(1)
+	mock.ExpectCommit()
+	require.NoError(t, dbStore.Set(ctx, &id, model))
+	require.Equal(t, 7, id)
+
+	// Testing 'Set', expecting error and rollback
+	expectSetOrder().WillReturnError(pgx.ErrTxClosed)
+	mock.ExpectRollback()
+	require.ErrorIs(t, dbStore.Set(ctx, &id, model), pgx.ErrTxClosed)
+
+	orderRows := func() *pgxmock.Rows {
+		return pgxmock.NewRows([]string{
+			"id", "order_uid", "track_number", "entry", "locale", "internal_signature", "customer_id",
+			"delivery_service", "shardkey", "sm_id", "date_created", "oof_shard",
+		}).AddRow(
+			7,
+			model.Order_uid,
+			model.Track_number,
+			model.Entry,
+			model.Locale,
+			model.Internal_signature,
+			model.Customer_id,
+			model.Delivery_service,
+			model.Shardkey,
+			model.Sm_id,
+			model.Date_created,
+			model.Oof_shard,
+		)
+	}
+	expectChildren := func() {
+		mock.ExpectQuery("SELECT (.+) FROM deliveries").WithArgs([]int{7}).WillReturnRows(pgxmock.NewRows([]string{
+			"order_id", "name", "phone", "zip", "city", "address", "region", "email",
+		}).AddRow(7, d.Name, d.Phone, d.Zip, d.City, d.Address, d.Region, d.Email))
+		mock.ExpectQuery("SELECT (.+) FROM payments").WithArgs([]int{7}).WillReturnRows(pgxmock.NewRows([]string{
+			"order_id", "transaction", "request_id", "currency", "provider", "bank",
+			"amount", "payment_dt", "delivery_cost", "goods_total", "custom_fee",
+		}).AddRow(7, p.Transaction, p.Request_id, p.Currency, p.Provider, p.Bank,
+			p.Amount, p.Payment_dt, p.Delivery_cost, p.Goods_total, p.Custom_fee))
+		mock.ExpectQuery("SELECT (.+) FROM order_items").WithArgs([]int{7}).WillReturnRows(pgxmock.NewRows([]string{
+			"order_id", "track_number", "rid", "name", "size", "brand",
+			"chrt_id", "price", "sale", "total_price", "nm_id", "status",
+		}).AddRow(7, it.Track_number, it.Rid, it.Name, it.Size, it.Brand,
+			it.Chrt_id, it.Price, it.Sale, it.Total_price, it.Nm_id, it.Status))
+	}
+
+	// Testing 'Get', not expecting any error, expecting 1 order
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id").WithArgs(7).WillReturnRows(orderRows())
+	expectChildren()
+	res, err := dbStore.Get(ctx, 7)
+	require.NoError(t, err)
+	require.Equal(t, model, res)
+
+	// Testing 'Get', expecting Error404NotFound error
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id").WithArgs(8).WillReturnRows(pgxmock.NewRows([]string{"id"}))
+	res, err = dbStore.Get(ctx, 8)
+	require.Nil(t, res)
+	require.ErrorIs(t, err, Error404NotFound)
+
+	// Testing 'GetByUID', not expecting any error, expecting 1 order
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE order_uid").WithArgs(model.Order_uid).WillReturnRows(orderRows())
+	expectChildren()
+	res, err = dbStore.GetByUID(ctx, model.Order_uid)
+	require.NoError(t, err)
+	require.Equal(t, model, res)
+
+	// Testing 'Stream', not expecting any error, expecting 1 order
+	mock.ExpectBegin()
+	mock.ExpectExec("DECLARE orders_stream NO SCROLL CURSOR FOR SELECT (.+) FROM orders WHERE id > 0 ORDER BY id").
+		WillReturnResult(pgxmock.NewResult("DECLARE CURSOR", 0))
+	mock.ExpectQuery("FETCH FORWARD 10 FROM orders_stream").WillReturnRows(orderRows())
+	expectChildren()
+	mock.ExpectQuery("FETCH FORWARD 10 FROM orders_stream").WillReturnRows(pgxmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+	records := make([]store.Record, 0)
+	err = dbStore.Stream(ctx, 0, 10, func(r []store.Record) error {
+		records = append(records, r...)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []store.Record{{ID: 7, Model: model}}, records)
+
+	// Testing 'Stream', expecting error on children query
+	mock.ExpectBegin()
+	mock.ExpectExec("DECLARE orders_stream").WillReturnResult(pgxmock.NewResult("DECLARE CURSOR", 0))
+	mock.ExpectQuery("FETCH FORWARD 10 FROM orders_stream").WillReturnRows(orderRows())
+	mock.ExpectQuery("SELECT (.+) FROM deliveries").WillReturnError(pgx.ErrTxClosed)
+	mock.ExpectRollback()
+	err = dbStore.Stream(ctx, 0, 10, func(r []store.Record) error { return nil })
+	require.ErrorIs(t, err, pgx.ErrTxClosed)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
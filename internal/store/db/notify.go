@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// NotifyChannel is where the orders_changed trigger announces the ids of
+// changed orders.
+const NotifyChannel = "orders_changed"
+
+var ListenQuery = "LISTEN " + NotifyChannel
+
+// listenConn is the part of *pgx.Conn Listen needs.
+type listenConn interface {
+	Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error)
+	WaitForNotification(context.Context) (*pgconn.Notification, error)
+}
+
+// Listen calls f with the id of every order changed in the database, by
+// any instance, until ctx is done or the connection fails. Changes made
+// while nobody listens are not replayed.
+func (db *DBStore) Listen(ctx context.Context, f func(id int)) error {
+	pool, ok := db.connPool.(*pgxpool.Pool)
+	if !ok {
+		return fmt.Errorf("error: listening needs a connection pool")
+	}
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// The connection would keep listening, so it's closed, which makes the
+	// pool drop it on release.
+	defer conn.Release()
+	defer conn.Conn().Close(context.Background())
+	return listen(ctx, conn.Conn(), f)
+}
+
+func listen(ctx context.Context, conn listenConn, f func(id int)) error {
+	if _, err := conn.Exec(ctx, ListenQuery); err != nil {
+		return err
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		id, err := strconv.Atoi(n.Payload)
+		if err != nil {
+			log.Printf("[LISTEN] Bad %s payload '%s'\n", NotifyChannel, n.Payload)
+			continue
+		}
+		f(id)
+	}
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+// connMock delivers payloads and then fails with errDone.
+type connMock struct {
+	query    string
+	payloads []string
+}
+
+var errDone = errors.New("done")
+
+func (c *connMock) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	c.query = sql
+	return pgconn.CommandTag("LISTEN"), nil
+}
+
+func (c *connMock) WaitForNotification(ctx context.Context) (*pgconn.Notification, error) {
+	if len(c.payloads) == 0 {
+		return nil, errDone
+	}
+	n := &pgconn.Notification{Channel: NotifyChannel, Payload: c.payloads[0]}
+	c.payloads = c.payloads[1:]
+	return n, nil
+}
+
+func TestListen(t *testing.T) {
+	conn := &connMock{payloads: []string{"1", "NaN", "42"}}
+	ids := make([]int, 0)
+
+	// Testing 'listen', expecting the ids of the notifications and bad payloads skipped
+	err := listen(context.Background(), conn, func(id int) { ids = append(ids, id) })
+	require.ErrorIs(t, err, errDone)
+	require.Equal(t, "LISTEN orders_changed", conn.query)
+	require.Equal(t, []int{1, 42}, ids)
+
+	// Testing 'Listen' without a pgxpool, expecting error
+	require.Error(t, (&DBStore{}).Listen(context.Background(), func(int) {}))
+}
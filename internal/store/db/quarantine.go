@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	QuarantineColumns      = "id,data,class,reason,channel,nats_seq,published_at,quarantined_at"
+	QuarantineQuery        = "INSERT INTO quarantine (data,class,reason,channel,nats_seq,published_at) VALUES ($1,$2,$3,$4,$5,$6) ON CONFLICT (channel,nats_seq) DO NOTHING"
+	ListQuarantinedQuery   = "SELECT " + QuarantineColumns + " FROM quarantine ORDER BY id DESC LIMIT $1"
+	GetQuarantinedQuery    = "SELECT " + QuarantineColumns + " FROM quarantine WHERE id=$1"
+	UpdateQuarantinedQuery = "UPDATE quarantine SET data=$2,class=$3,reason=$4 WHERE id=$1"
+	DeleteQuarantinedQuery = "DELETE FROM quarantine WHERE id=$1"
+)
+
+// Quarantine keeps a message rejected by the worker, unless it's kept
+// already.
+func (db *DBStore) Quarantine(ctx context.Context, q *store.Quarantined) error {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Set)
+	defer cancel()
+	_, err := db.connPool.Exec(ctx, QuarantineQuery,
+		q.Data, q.Class, q.Reason, q.Channel, int64(q.Nats_seq), q.Published_at.UTC())
+	return err
+}
+
+// ListQuarantined returns up to limit quarantined messages, newest first.
+func (db *DBStore) ListQuarantined(ctx context.Context, limit int) ([]store.Quarantined, error) {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Find)
+	defer cancel()
+	return selectQuarantined(ctx, db.connPool, ListQuarantinedQuery, limit)
+}
+
+func (db *DBStore) GetQuarantined(ctx context.Context, id int) (*store.Quarantined, error) {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Get)
+	defer cancel()
+	res, err := selectQuarantined(ctx, db.connPool, GetQuarantinedQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, Error404NotFound
+	}
+	return &res[0], nil
+}
+
+// UpdateQuarantined replaces the data, class and reason of a quarantined
+// message.
+func (db *DBStore) UpdateQuarantined(ctx context.Context, q *store.Quarantined) error {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Set)
+	defer cancel()
+	tag, err := db.connPool.Exec(ctx, UpdateQuarantinedQuery, q.ID, q.Data, q.Class, q.Reason)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return Error404NotFound
+	}
+	return nil
+}
+
+func (db *DBStore) DeleteQuarantined(ctx context.Context, id int) error {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Set)
+	defer cancel()
+	_, err := db.connPool.Exec(ctx, DeleteQuarantinedQuery, id)
+	return err
+}
+
+func selectQuarantined(ctx context.Context, q querier, query string, args ...interface{}) ([]store.Quarantined, error) {
+	res := make([]store.Quarantined, 0)
+	err := queryEach(ctx, q, func(rows pgx.Rows) error {
+		var (
+			m   store.Quarantined
+			seq int64
+		)
+		err := rows.Scan(&m.ID, &m.Data, &m.Class, &m.Reason, &m.Channel, &seq, &m.Published_at, &m.Quarantined_at)
+		if err != nil {
+			return err
+		}
+		m.Nats_seq = uint64(seq)
+		res = append(res, m)
+		return nil
+	}, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
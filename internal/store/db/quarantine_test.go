@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBStoreQuarantine(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Errorf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	ctx := context.Background()
+	dbStore := &DBStore{connPool: mock}
+	published := time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+	q := &store.Quarantined{
+		ID:             1,
+		Data:           []byte(`{"order_uid":123}`),
+		Class:          store.ClassDecode,
+		Reason:         "error: bad order_uid",
+		Channel:        "foo",
+		Nats_seq:       7,
+		Published_at:   published,
+		Quarantined_at: published.Add(time.Second),
+	}
+	columns := []string{"id", "data", "class", "reason", "channel", "nats_seq", "published_at", "quarantined_at"}
+	row := []interface{}{1, q.Data, q.Class, q.Reason, q.Channel, int64(7), published, published.Add(time.Second)}
+
+	// Testing 'Quarantine', not expecting any error
+	mock.ExpectExec("INSERT INTO quarantine (.+) ON CONFLICT \\(channel,nats_seq\\) DO NOTHING").
+		WithArgs(q.Data, q.Class, q.Reason, q.Channel, int64(7), published).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	require.NoError(t, dbStore.Quarantine(ctx, q))
+
+	// Testing 'ListQuarantined', expecting the messages
+	mock.ExpectQuery("SELECT (.+) FROM quarantine ORDER BY id DESC LIMIT").WithArgs(10).
+		WillReturnRows(pgxmock.NewRows(columns).AddRow(row...))
+	list, err := dbStore.ListQuarantined(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, []store.Quarantined{*q}, list)
+
+	// Testing 'GetQuarantined', expecting the message
+	mock.ExpectQuery("SELECT (.+) FROM quarantine WHERE id").WithArgs(1).
+		WillReturnRows(pgxmock.NewRows(columns).AddRow(row...))
+	res, err := dbStore.GetQuarantined(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, q, res)
+
+	// Testing 'GetQuarantined', expecting Error404NotFound error
+	mock.ExpectQuery("SELECT (.+) FROM quarantine WHERE id").WithArgs(2).
+		WillReturnRows(pgxmock.NewRows(columns))
+	_, err = dbStore.GetQuarantined(ctx, 2)
+	require.ErrorIs(t, err, Error404NotFound)
+
+	// Testing 'UpdateQuarantined', not expecting any error
+	mock.ExpectExec("UPDATE quarantine SET").WithArgs(1, q.Data, q.Class, q.Reason).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	require.NoError(t, dbStore.UpdateQuarantined(ctx, q))
+
+	// Testing 'UpdateQuarantined', expecting Error404NotFound error
+	mock.ExpectExec("UPDATE quarantine SET").WithArgs(1, q.Data, q.Class, q.Reason).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	require.ErrorIs(t, dbStore.UpdateQuarantined(ctx, q), Error404NotFound)
+
+	// Testing 'DeleteQuarantined', expecting the error of the db
+	mock.ExpectExec("DELETE FROM quarantine WHERE id").WithArgs(1).WillReturnError(pgx.ErrTxClosed)
+	require.ErrorIs(t, dbStore.DeleteQuarantined(ctx, 1), pgx.ErrTxClosed)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/jackc/pgx/v4"
+)
+
+const DefaultStreamChunk = 1000
+
+var (
+	StreamDeclareQuery = "DECLARE orders_stream NO SCROLL CURSOR FOR %s WHERE id > %d ORDER BY id"
+	StreamFetchQuery   = "FETCH FORWARD %d FROM orders_stream"
+)
+
+// Stream reads every order with an id greater than after through a
+// server-side cursor and calls f with chunks of up to chunk records in id
+// order, so the table never has to fit in memory at once. It stops at the
+// first error returned by f.
+func (db *DBStore) Stream(ctx context.Context, after, chunk int, f func([]store.Record) error) error {
+	if chunk <= 0 {
+		chunk = DefaultStreamChunk
+	}
+	tx, err := db.connPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := GetAllQuery
+	if db.mode == ModeNormalized {
+		query = NormalizedGetAllQuery
+	}
+	if _, err = tx.Exec(ctx, fmt.Sprintf(StreamDeclareQuery, query, after)); err != nil {
+		return err
+	}
+	fetch := fmt.Sprintf(StreamFetchQuery, chunk)
+	for {
+		records, err := db.fetch(ctx, tx, fetch)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return tx.Commit(ctx)
+		}
+		if err = f(records); err != nil {
+			return err
+		}
+	}
+}
+
+func (db *DBStore) fetch(ctx context.Context, tx pgx.Tx, query string) ([]store.Record, error) {
+	ctx, cancel := withTimeout(ctx, db.timeouts.Fetch)
+	defer cancel()
+	var (
+		ids    []int
+		models map[int]*store.Model
+		err    error
+	)
+	if db.mode == ModeNormalized {
+		ids, models, err = selectNormalized(ctx, tx, query)
+	} else {
+		ids, models, err = selectJSON(ctx, tx, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	records := make([]store.Record, len(ids))
+	for i, id := range ids {
+		records[i] = store.Record{ID: id, Model: models[id]}
+	}
+	return records, nil
+}
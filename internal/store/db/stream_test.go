@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBStoreStream(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Errorf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	ctx := context.Background()
+	model := exampleModel
+	dbStore := &DBStore{connPool: mock}
+	expectDeclare := func() {
+		mock.ExpectBegin()
+		mock.ExpectExec("DECLARE orders_stream NO SCROLL CURSOR FOR SELECT (.+) FROM wb_data WHERE id > 0 ORDER BY id").
+			WillReturnResult(pgxmock.NewResult("DECLARE CURSOR", 0))
+	}
+
+	// Testing 'Stream', not expecting any error, expecting 2 chunks
+	expectDeclare()
+	mock.ExpectQuery("FETCH FORWARD 1 FROM orders_stream").WillReturnRows(jsonRows(1, model))
+	mock.ExpectQuery("FETCH FORWARD 1 FROM orders_stream").WillReturnRows(jsonRows(2, model))
+	mock.ExpectQuery("FETCH FORWARD 1 FROM orders_stream").WillReturnRows(pgxmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+	chunks := make([][]store.Record, 0)
+	err = dbStore.Stream(ctx, 0, 1, func(r []store.Record) error {
+		chunks = append(chunks, r)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]store.Record{
+		{{ID: 1, Model: model}},
+		{{ID: 2, Model: model}},
+	}, chunks)
+
+	// Testing 'Stream', expecting the callback's error to stop the stream
+	expectDeclare()
+	mock.ExpectQuery(fmt.Sprintf("FETCH FORWARD %d FROM orders_stream", DefaultStreamChunk)).WillReturnRows(jsonRows(1, model))
+	mock.ExpectRollback()
+	stop := fmt.Errorf("stop")
+	err = dbStore.Stream(ctx, 0, 0, func(r []store.Record) error { return stop })
+	require.ErrorIs(t, err, stop)
+
+	// Testing 'Stream' after an id, expecting it in the cursor query
+	mock.ExpectBegin()
+	mock.ExpectExec("DECLARE orders_stream NO SCROLL CURSOR FOR SELECT (.+) FROM wb_data WHERE id > 42 ORDER BY id").
+		WillReturnResult(pgxmock.NewResult("DECLARE CURSOR", 0))
+	mock.ExpectQuery("FETCH FORWARD 1 FROM orders_stream").WillReturnRows(pgxmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+	err = dbStore.Stream(ctx, 42, 1, func(r []store.Record) error { return nil })
+	require.NoError(t, err)
+
+	// Testing 'Stream', expecting error on declare
+	mock.ExpectBegin()
+	mock.ExpectExec("DECLARE orders_stream").WillReturnError(pgx.ErrTxClosed)
+	mock.ExpectRollback()
+	err = dbStore.Stream(ctx, 0, 1, func(r []store.Record) error { return nil })
+	require.ErrorIs(t, err, pgx.ErrTxClosed)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/jackc/pgconn"
+)
+
+// Transient reports whether err is a failure worth retrying: the db is
+// unreachable, restarting or overloaded, or the transaction lost a
+// serialization conflict or a deadlock.
+func Transient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// connection_exception, insufficient_resources
+		if strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "53") {
+			return true
+		}
+		switch pgErr.Code {
+		case "40001", "40P01", "57P01", "57P02", "57P03":
+			// serialization_failure, deadlock_detected, admin_shutdown,
+			// crash_shutdown, cannot_connect_now
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, ErrorTimeoutExceeded) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr) ||
+		pgconn.Timeout(err) ||
+		pgconn.SafeToRetry(err)
+}
+
+// Refused reports whether err means the db refuses the order for good: a
+// value is malformed or out of range (data_exception), or the order breaks
+// a constraint (integrity_constraint_violation). Any other failure, even one
+// Transient doesn't list, may go away.
+func Refused(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) &&
+		(strings.HasPrefix(pgErr.Code, "22") || strings.HasPrefix(pgErr.Code, "23"))
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransient(t *testing.T) {
+	// Testing 'Transient' with failures worth retrying, expecting true
+	for _, err := range []error{
+		&pgconn.PgError{Code: "08006"},
+		&pgconn.PgError{Code: "40001"},
+		&pgconn.PgError{Code: "40P01"},
+		&pgconn.PgError{Code: "53300"},
+		&pgconn.PgError{Code: "57P01"},
+		fmt.Errorf("set: %w", &pgconn.PgError{Code: "57P03"}),
+		ErrorTimeoutExceeded,
+		context.DeadlineExceeded,
+		syscall.ECONNREFUSED,
+		io.ErrUnexpectedEOF,
+		&net.OpError{Op: "dial", Err: errors.New("no route to host")},
+	} {
+		require.True(t, Transient(err), err.Error())
+	}
+
+	// Testing 'Transient' with failures that would fail again, expecting false
+	for _, err := range []error{
+		nil,
+		context.Canceled,
+		Error404NotFound,
+		errors.New("some error"),
+		&pgconn.PgError{Code: "23505"},
+		&pgconn.PgError{Code: "42P01"},
+	} {
+		require.False(t, Transient(err), fmt.Sprint(err))
+	}
+}
+
+func TestRefused(t *testing.T) {
+	// Testing 'Refused' with data and constraint failures, expecting true
+	for _, err := range []error{
+		&pgconn.PgError{Code: "22001"},
+		&pgconn.PgError{Code: "22P02"},
+		&pgconn.PgError{Code: "23505"},
+		fmt.Errorf("set: %w", &pgconn.PgError{Code: "23502"}),
+	} {
+		require.True(t, Refused(err), err.Error())
+	}
+
+	// Testing 'Refused' with failures that may go away, expecting false
+	for _, err := range []error{
+		nil,
+		context.Canceled,
+		context.DeadlineExceeded,
+		errors.New("some error"),
+		&pgconn.PgError{Code: "08006"},
+		&pgconn.PgError{Code: "25006"},
+		&pgconn.PgError{Code: "42P01"},
+		&pgconn.PgError{Code: "55P03"},
+		&pgconn.PgError{Code: "57014"},
+		&pgconn.PgError{Code: "XX000"},
+	} {
+		require.False(t, Refused(err), fmt.Sprint(err))
+	}
+}
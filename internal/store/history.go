@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Version is one stored state of an order. Replaced_at is nil for the
+// current version.
+type Version struct {
+	Version     int
+	Nats_seq    uint64
+	Replaced_at *time.Time
+	Model       *Model
+}
+
+// Field is a flattened model field, nested fields are keyed like
+// "delivery.city" and "items[0].status".
+type Field struct {
+	Key   string
+	Value string
+}
+
+type sequenceKey struct{}
+
+// WithSequence returns a copy of ctx carrying the NATS sequence number of the
+// message being stored.
+func WithSequence(ctx context.Context, seq uint64) context.Context {
+	return context.WithValue(ctx, sequenceKey{}, seq)
+}
+
+func SequenceFromContext(ctx context.Context) (uint64, bool) {
+	seq, ok := ctx.Value(sequenceKey{}).(uint64)
+	return seq, ok
+}
+
+// Fields flattens m into its json-named fields in declaration order.
+func Fields(m *Model) []Field {
+	res := make([]Field, 0, 32)
+	if m != nil {
+		flatten(&res, "", reflect.ValueOf(m).Elem())
+	}
+	return res
+}
+
+// Diff returns the keys of the fields that differ between a and b.
+func Diff(a, b *Model) []string {
+	fa, fb := Fields(a), Fields(b)
+	values := make(map[string]string, len(fa))
+	for _, f := range fa {
+		values[f.Key] = f.Value
+	}
+	res := make([]string, 0)
+	for _, f := range fb {
+		if v, ok := values[f.Key]; !ok || v != f.Value {
+			res = append(res, f.Key)
+		}
+		delete(values, f.Key)
+	}
+	for _, f := range fa {
+		if _, ok := values[f.Key]; ok {
+			res = append(res, f.Key)
+		}
+	}
+	return res
+}
+
+func flatten(res *[]Field, prefix string, v reflect.Value) {
+	if t, ok := v.Interface().(*time.Time); ok {
+		if t != nil {
+			*res = append(*res, Field{prefix, t.UTC().Format(time.RFC3339Nano)})
+			return
+		}
+		*res = append(*res, Field{prefix, ""})
+		return
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			flatten(res, prefix, v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			flatten(res, name, v.Field(i))
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			flatten(res, fmt.Sprintf("%s[%d]", prefix, i), v.Index(i))
+		}
+	default:
+		*res = append(*res, Field{prefix, fmt.Sprint(v.Interface())})
+	}
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFields(t *testing.T) {
+	date := time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+	m := &Model{
+		Order_uid:    "uid",
+		Sm_id:        99,
+		Date_created: &date,
+		Delivery:     &Delivery{City: "Kiryat Mozkin"},
+		Items:        []*Item{{Status: 202}},
+	}
+
+	// Testing 'Fields', expecting flattened json keys
+	fields := make(map[string]string)
+	for _, f := range Fields(m) {
+		fields[f.Key] = f.Value
+	}
+	require.Equal(t, "uid", fields["order_uid"])
+	require.Equal(t, "99", fields["sm_id"])
+	require.Equal(t, "2021-11-26T06:22:19Z", fields["date_created"])
+	require.Equal(t, "Kiryat Mozkin", fields["delivery.city"])
+	require.Equal(t, "202", fields["items[0].status"])
+	require.NotContains(t, fields, "payment.amount")
+
+	// Testing 'Fields', expecting nothing for nil
+	require.Empty(t, Fields(nil))
+}
+
+func TestDiff(t *testing.T) {
+	a := &Model{Order_uid: "uid", Locale: "en", Items: []*Item{{Nm_id: 1}, {Nm_id: 2}}}
+	b := &Model{Order_uid: "uid", Locale: "ru", Items: []*Item{{Nm_id: 1}}, Payment: &Payment{}}
+
+	// Testing 'Diff', expecting no changes between equal models
+	require.Empty(t, Diff(a, a))
+
+	// Testing 'Diff', expecting changed, added and removed fields
+	diff := Diff(a, b)
+	require.Contains(t, diff, "locale")
+	require.Contains(t, diff, "payment.amount")
+	require.Contains(t, diff, "items[1].nm_id")
+	require.NotContains(t, diff, "order_uid")
+	require.NotContains(t, diff, "items[0].nm_id")
+}
+
+func TestSequence(t *testing.T) {
+	// Testing 'SequenceFromContext', expecting no sequence by default
+	_, ok := SequenceFromContext(context.Background())
+	require.False(t, ok)
+
+	// Testing 'SequenceFromContext', expecting the sequence from 'WithSequence'
+	seq, ok := SequenceFromContext(WithSequence(context.Background(), 42))
+	require.True(t, ok)
+	require.Equal(t, uint64(42), seq)
+}
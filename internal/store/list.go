@@ -0,0 +1,66 @@
+package store
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record is a stored model together with its database id.
+type Record struct {
+	ID    int    `json:"id"`
+	Model *Model `json:"order"`
+}
+
+// Cursor points at the last record of a page. Orders are listed newest first
+// by (date_created, id), so the next page starts right after it.
+type Cursor struct {
+	Date_created time.Time
+	ID           int
+}
+
+// Encode returns an opaque token for c that DecodeCursor accepts.
+func (c Cursor) Encode() string {
+	s := strconv.FormatInt(c.Date_created.UnixNano(), 10) + ":" + strconv.Itoa(c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func DecodeCursor(token string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("error: bad cursor")
+	}
+	parts := strings.Split(string(b), ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("error: bad cursor")
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("error: bad cursor")
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("error: bad cursor")
+	}
+	return &Cursor{time.Unix(0, nanos).UTC(), id}, nil
+}
+
+// ListFilter selects a page of orders. Empty fields don't filter, From and To
+// bound date_created as [From, To).
+type ListFilter struct {
+	Customer_id      string
+	Delivery_service string
+	Locale           string
+	From             *time.Time
+	To               *time.Time
+	After            *Cursor
+	Limit            int
+}
+
+// Page is one page of orders, Next is nil on the last page.
+type Page struct {
+	Records []Record
+	Next    *Cursor
+}
@@ -0,0 +1,21 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursor(t *testing.T) {
+	c := Cursor{time.Date(2021, 11, 26, 6, 22, 19, 5, time.UTC), 42}
+	res, err := DecodeCursor(c.Encode())
+	require.NoError(t, err)
+	require.Equal(t, c, *res)
+
+	for _, token := range []string{"", "!!!", "MTI", "YTpi", "MTI6Yg"} {
+		res, err = DecodeCursor(token)
+		require.Error(t, err)
+		require.Nil(t, res)
+	}
+}
@@ -0,0 +1,257 @@
+// Package lru is a store.CacheIface that keeps the most recently used orders
+// within an entry and byte budget.
+package lru
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ineverbee/wbl0/internal/store"
+)
+
+// entryOverhead approximates the memory an entry takes besides its model:
+// the list element, map buckets and the order_uid key.
+const entryOverhead = 128
+
+// Stats are the counters of an LRUStore since it was created.
+type Stats struct {
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+	Evictions  uint64 `json:"evictions"`
+	Entries    int    `json:"entries"`
+	Bytes      int64  `json:"bytes"`
+	MaxEntries int    `json:"max_entries"`
+	MaxBytes   int64  `json:"max_bytes"`
+}
+
+type entry struct {
+	id    int
+	model *store.Model
+	size  int64
+}
+
+// LRUStore evicts the least recently used orders once it holds more than
+// maxEntries orders or more than maxBytes bytes. A zero limit is no limit.
+// Orders are sized by their JSON encoding, and an order larger than maxBytes
+// on its own isn't cached at all. Every hit moves the order to the front, so
+// reads take the same lock as writes.
+type LRUStore struct {
+	sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	ll         *list.List
+	m          map[int]*list.Element
+	uids       map[string]int
+	stats      Stats
+}
+
+func NewLRUStore(maxEntries int, maxBytes int64) *LRUStore {
+	return &LRUStore{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		m:          make(map[int]*list.Element),
+		uids:       make(map[string]int),
+	}
+}
+
+func (s *LRUStore) Get(ctx context.Context, id int) (*store.Model, error) {
+	defer s.Unlock()
+	s.Lock()
+	if el, ok := s.m[id]; ok {
+		s.stats.Hits++
+		s.ll.MoveToFront(el)
+		return el.Value.(*entry).model.Clone(), nil
+	}
+	s.stats.Misses++
+	return nil, fmt.Errorf("error: no rows with id '%d'", id)
+}
+
+func (s *LRUStore) GetByUID(ctx context.Context, uid string) (*store.Model, error) {
+	defer s.Unlock()
+	s.Lock()
+	if id, ok := s.uids[uid]; ok {
+		el := s.m[id]
+		s.stats.Hits++
+		s.ll.MoveToFront(el)
+		return el.Value.(*entry).model.Clone(), nil
+	}
+	s.stats.Misses++
+	return nil, fmt.Errorf("error: no rows with order_uid '%s'", uid)
+}
+
+// Set caches model as the most recently used order and evicts the least
+// recently used ones that no longer fit. A model bigger than the whole
+// byte budget isn't cached.
+func (s *LRUStore) Set(ctx context.Context, id *int, model *store.Model) error {
+	size, err := sizeOf(model)
+	if err != nil {
+		return err
+	}
+	defer s.Unlock()
+	s.Lock()
+	s.remove(*id)
+	if s.maxBytes > 0 && size > s.maxBytes {
+		return nil
+	}
+	s.add(*id, model.Clone(), size)
+	s.evict()
+	return nil
+}
+
+// Add caches model as the most recently used order unless id is cached
+// already. Unlike Load, it evicts the orders that no longer fit.
+func (s *LRUStore) Add(ctx context.Context, id int, model *store.Model) (bool, error) {
+	size, err := sizeOf(model)
+	if err != nil {
+		return false, err
+	}
+	defer s.Unlock()
+	s.Lock()
+	if _, ok := s.m[id]; ok || s.maxBytes > 0 && size > s.maxBytes {
+		return false, nil
+	}
+	s.add(id, model.Clone(), size)
+	s.evict()
+	return true, nil
+}
+
+// Swap caches model as the most recently used order unless the order cached
+// under id is gone or no longer equals old.
+func (s *LRUStore) Swap(ctx context.Context, id int, old, model *store.Model) (bool, error) {
+	size, err := sizeOf(model)
+	if err != nil {
+		return false, err
+	}
+	defer s.Unlock()
+	s.Lock()
+	if el, ok := s.m[id]; !ok || len(store.Diff(el.Value.(*entry).model, old)) > 0 {
+		return false, nil
+	}
+	s.remove(id)
+	if s.maxBytes > 0 && size > s.maxBytes {
+		return true, nil
+	}
+	s.add(id, model.Clone(), size)
+	s.evict()
+	return true, nil
+}
+
+func (s *LRUStore) Delete(ctx context.Context, id int) error {
+	defer s.Unlock()
+	s.Lock()
+	s.remove(id)
+	return nil
+}
+
+// Load adds the records that aren't cached yet as long as they fit and
+// returns how many were added. Cached entries are kept, as they may be newer
+// than the records, and nothing is evicted to make room.
+func (s *LRUStore) Load(records []store.Record) int {
+	n := 0
+	for _, r := range records {
+		size, err := sizeOf(r.Model)
+		if err != nil {
+			continue
+		}
+		s.Lock()
+		_, ok := s.m[r.ID]
+		if !ok && s.fits(size) {
+			// Loaded orders go to the back, behind the ones already used.
+			s.add(r.ID, r.Model.Clone(), size)
+			s.ll.MoveToBack(s.m[r.ID])
+			n++
+		}
+		s.Unlock()
+	}
+	return n
+}
+
+// Peek returns a cached order without counting a hit or marking it used.
+func (s *LRUStore) Peek(id int) (*store.Model, bool) {
+	defer s.Unlock()
+	s.Lock()
+	if el, ok := s.m[id]; ok {
+		return el.Value.(*entry).model.Clone(), true
+	}
+	return nil, false
+}
+
+// IDs returns the ids of the cached orders in ascending order.
+func (s *LRUStore) IDs() []int {
+	defer s.Unlock()
+	s.Lock()
+	ids := make([]int, 0, len(s.m))
+	for id := range s.m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Full reports whether another order may not fit without evicting one.
+func (s *LRUStore) Full() bool {
+	defer s.Unlock()
+	s.Lock()
+	return !s.fits(entryOverhead)
+}
+
+// Stats returns a snapshot of the counters.
+func (s *LRUStore) Stats() Stats {
+	defer s.Unlock()
+	s.Lock()
+	stats := s.stats
+	stats.Entries = s.ll.Len()
+	stats.MaxEntries = s.maxEntries
+	stats.MaxBytes = s.maxBytes
+	return stats
+}
+
+func (s *LRUStore) fits(size int64) bool {
+	if s.maxEntries > 0 && s.ll.Len() >= s.maxEntries {
+		return false
+	}
+	return s.maxBytes <= 0 || s.stats.Bytes+size <= s.maxBytes
+}
+
+func (s *LRUStore) add(id int, model *store.Model, size int64) {
+	s.m[id] = s.ll.PushFront(&entry{id, model, size})
+	s.uids[model.Order_uid] = id
+	s.stats.Bytes += size
+}
+
+func (s *LRUStore) remove(id int) {
+	el, ok := s.m[id]
+	if !ok {
+		return
+	}
+	e := el.Value.(*entry)
+	s.ll.Remove(el)
+	delete(s.m, id)
+	if s.uids[e.model.Order_uid] == id {
+		delete(s.uids, e.model.Order_uid)
+	}
+	s.stats.Bytes -= e.size
+}
+
+func (s *LRUStore) evict() {
+	for s.ll.Len() > 0 && (s.maxEntries > 0 && s.ll.Len() > s.maxEntries ||
+		s.maxBytes > 0 && s.stats.Bytes > s.maxBytes) {
+		s.remove(s.ll.Back().Value.(*entry).id)
+		s.stats.Evictions++
+	}
+}
+
+// sizeOf estimates the memory taken by a cached model from the length of its
+// JSON encoding.
+func sizeOf(model *store.Model) (int64, error) {
+	b, err := json.Marshal(model)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(b)) + entryOverhead, nil
+}
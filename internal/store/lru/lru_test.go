@@ -0,0 +1,180 @@
+package lru
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/stretchr/testify/require"
+)
+
+func model(id int) *store.Model {
+	return &store.Model{Order_uid: fmt.Sprintf("b563feb7b2b84b6test%d", id)}
+}
+
+func set(t *testing.T, s *LRUStore, ids ...int) {
+	for _, id := range ids {
+		id := id
+		require.NoError(t, s.Set(context.Background(), &id, model(id)))
+	}
+}
+
+func TestSetGet(t *testing.T) {
+	ctx := context.Background()
+	s := NewLRUStore(0, 0)
+	set(t, s, 1)
+
+	// Testing 'Get', expecting the cached order
+	res, err := s.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, model(1), res)
+
+	// Testing 'GetByUID', expecting the cached order
+	res, err = s.GetByUID(ctx, model(1).Order_uid)
+	require.NoError(t, err)
+	require.Equal(t, model(1), res)
+
+	// Testing 'Get' and 'GetByUID', expecting misses
+	res, err = s.Get(ctx, 2)
+	require.Error(t, err)
+	require.Nil(t, res)
+	res, err = s.GetByUID(ctx, "unknown")
+	require.Error(t, err)
+	require.Nil(t, res)
+
+	// Testing 'Set' of a changed order_uid, expecting the old one forgotten
+	id, changed := 1, &store.Model{Order_uid: "changed"}
+	require.NoError(t, s.Set(ctx, &id, changed))
+	_, err = s.GetByUID(ctx, model(1).Order_uid)
+	require.Error(t, err)
+
+	// Testing 'Delete', expecting a miss and no bytes left
+	require.NoError(t, s.Delete(ctx, 1))
+	_, err = s.GetByUID(ctx, "changed")
+	require.Error(t, err)
+
+	stats := s.Stats()
+	require.Equal(t, Stats{Hits: 2, Misses: 4}, stats)
+}
+
+func TestEvictEntries(t *testing.T) {
+	ctx := context.Background()
+	s := NewLRUStore(2, 0)
+	set(t, s, 1, 2)
+
+	// Testing 'Set' over the entry budget, expecting the least recently used evicted
+	_, err := s.Get(ctx, 1)
+	require.NoError(t, err)
+	set(t, s, 3)
+	_, err = s.Get(ctx, 2)
+	require.Error(t, err)
+	_, err = s.Get(ctx, 1)
+	require.NoError(t, err)
+	_, err = s.Get(ctx, 3)
+	require.NoError(t, err)
+
+	require.Equal(t, []int{1, 3}, s.IDs())
+
+	// Testing 'Peek', expecting the order without a hit
+	hits := s.Stats().Hits
+	res, ok := s.Peek(1)
+	require.True(t, ok)
+	require.Equal(t, model(1), res)
+	_, ok = s.Peek(2)
+	require.False(t, ok)
+	require.Equal(t, hits, s.Stats().Hits)
+	stats := s.Stats()
+	require.Equal(t, uint64(1), stats.Evictions)
+	require.Equal(t, 2, stats.Entries)
+	require.Equal(t, 2, stats.MaxEntries)
+}
+
+func TestEvictBytes(t *testing.T) {
+	ctx := context.Background()
+	size, err := sizeOf(model(1))
+	require.NoError(t, err)
+	s := NewLRUStore(0, 2*size)
+	set(t, s, 1, 2, 3)
+
+	// Testing 'Set' over the byte budget, expecting the oldest order evicted
+	_, err = s.Get(ctx, 1)
+	require.Error(t, err)
+	stats := s.Stats()
+	require.Equal(t, uint64(1), stats.Evictions)
+	require.Equal(t, 2*size, stats.Bytes)
+
+	// Testing 'Set' of an order bigger than the budget, expecting it not cached
+	id, big := 4, &store.Model{Order_uid: "big", Items: make([]*store.Item, 100)}
+	require.NoError(t, s.Set(ctx, &id, big))
+	_, err = s.Get(ctx, 4)
+	require.Error(t, err)
+	require.Equal(t, 2, s.Stats().Entries)
+}
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	s := NewLRUStore(2, 0)
+	newer := &store.Model{Order_uid: model(1).Order_uid, Locale: "ru"}
+	id := 1
+	require.NoError(t, s.Set(ctx, &id, newer))
+
+	// Testing 'Load', expecting cached orders kept and no evictions past the budget
+	n := s.Load([]store.Record{{ID: 1, Model: model(1)}, {ID: 2, Model: model(2)}, {ID: 3, Model: model(3)}})
+	require.Equal(t, 1, n)
+	require.True(t, s.Full())
+	res, err := s.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, newer, res)
+	_, err = s.Get(ctx, 3)
+	require.Error(t, err)
+	require.Zero(t, s.Stats().Evictions)
+
+	// Testing 'Set' after 'Load', expecting loaded orders evicted first
+	set(t, s, 4)
+	_, err = s.Get(ctx, 2)
+	require.Error(t, err)
+	_, err = s.Get(ctx, 1)
+	require.NoError(t, err)
+}
+
+func TestAdd(t *testing.T) {
+	ctx := context.Background()
+	s := NewLRUStore(2, 0)
+	newer := &store.Model{Order_uid: model(1).Order_uid, Locale: "ru"}
+	id := 1
+	require.NoError(t, s.Set(ctx, &id, newer))
+	set(t, s, 2)
+
+	// Testing 'Add' of a cached order, expecting it kept
+	added, err := s.Add(ctx, 1, model(1))
+	require.NoError(t, err)
+	require.False(t, added)
+	res, err := s.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, newer, res)
+
+	// Testing 'Add' over the entry budget, expecting the least recently used evicted
+	added, err = s.Add(ctx, 3, model(3))
+	require.NoError(t, err)
+	require.True(t, added)
+	_, err = s.Get(ctx, 2)
+	require.Error(t, err)
+	_, err = s.Get(ctx, 3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), s.Stats().Evictions)
+
+	// Testing 'Swap', expecting the order replaced only while it equals old
+	swapped, err := s.Swap(ctx, 1, model(1), model(1))
+	require.NoError(t, err)
+	require.False(t, swapped)
+	swapped, err = s.Swap(ctx, 1, newer, model(1))
+	require.NoError(t, err)
+	require.True(t, swapped)
+	res, err = s.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, model(1), res)
+	swapped, err = s.Swap(ctx, 2, model(2), model(2))
+	require.NoError(t, err)
+	require.False(t, swapped)
+}
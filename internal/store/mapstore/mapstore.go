@@ -1,33 +1,180 @@
 package mapstore
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/ineverbee/wbl0/internal/store"
 )
 
+// MapStore keeps copies of the cached orders and hands out copies, so that
+// callers can't change what other callers read.
 type MapStore struct {
 	sync.RWMutex
-	m map[int]*store.Model
+	m    map[int]*store.Model
+	uids map[string]int
+	// index maps the store.SearchFields other than order_uid to their
+	// values and to the ids of the orders with those values.
+	index map[string]map[string]map[int]struct{}
 }
 
 func NewMapStore(mp map[int]*store.Model) *MapStore {
-	return &MapStore{m: mp}
+	ms := &MapStore{
+		m:     make(map[int]*store.Model, len(mp)),
+		uids:  make(map[string]int, len(mp)),
+		index: make(map[string]map[string]map[int]struct{}),
+	}
+	for _, field := range store.SearchFields {
+		if field != "order_uid" {
+			ms.index[field] = make(map[string]map[int]struct{})
+		}
+	}
+	for id, model := range mp {
+		ms.add(id, model.Clone())
+	}
+	return ms
 }
 
-func (ms *MapStore) Get(id int) (*store.Model, error) {
+func (ms *MapStore) Get(ctx context.Context, id int) (*store.Model, error) {
 	defer ms.RUnlock()
 	ms.RLock()
 	if model, ok := ms.m[id]; ok {
-		return model, nil
+		return model.Clone(), nil
 	}
 	return nil, fmt.Errorf("error: no rows with id '%d'", id)
 }
 
-func (ms *MapStore) Set(id *int, model *store.Model) error {
+func (ms *MapStore) GetByUID(ctx context.Context, uid string) (*store.Model, error) {
+	defer ms.RUnlock()
+	ms.RLock()
+	if id, ok := ms.uids[uid]; ok {
+		return ms.m[id].Clone(), nil
+	}
+	return nil, fmt.Errorf("error: no rows with order_uid '%s'", uid)
+}
+
+func (ms *MapStore) Set(ctx context.Context, id *int, model *store.Model) error {
+	model = model.Clone()
+	defer ms.Unlock()
+	ms.Lock()
+	ms.remove(*id)
+	ms.add(*id, model)
+	return nil
+}
+
+func (ms *MapStore) Delete(ctx context.Context, id int) error {
 	defer ms.Unlock()
 	ms.Lock()
-	ms.m[*id] = model
+	ms.remove(id)
 	return nil
 }
+
+// Add caches model unless id is cached already.
+func (ms *MapStore) Add(ctx context.Context, id int, model *store.Model) (bool, error) {
+	return ms.Load([]store.Record{{ID: id, Model: model}}) == 1, nil
+}
+
+// Swap caches model unless the order cached under id is gone or no longer
+// equals old.
+func (ms *MapStore) Swap(ctx context.Context, id int, old, model *store.Model) (bool, error) {
+	model = model.Clone()
+	defer ms.Unlock()
+	ms.Lock()
+	if cur, ok := ms.m[id]; !ok || len(store.Diff(cur, old)) > 0 {
+		return false, nil
+	}
+	ms.remove(id)
+	ms.add(id, model)
+	return true, nil
+}
+
+// Load adds the records that aren't cached yet and returns how many were
+// added. Cached entries are kept, as they may be newer than the records.
+func (ms *MapStore) Load(records []store.Record) int {
+	defer ms.Unlock()
+	ms.Lock()
+	n := 0
+	for _, r := range records {
+		if _, ok := ms.m[r.ID]; ok {
+			continue
+		}
+		ms.add(r.ID, r.Model.Clone())
+		n++
+	}
+	return n
+}
+
+// IDs returns the ids of the cached orders in ascending order.
+func (ms *MapStore) IDs() []int {
+	defer ms.RUnlock()
+	ms.RLock()
+	ids := make([]int, 0, len(ms.m))
+	for id := range ms.m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Search returns the orders whose field has value, in id order. See
+// store.SearchFields.
+func (ms *MapStore) Search(ctx context.Context, field, value string) ([]store.Record, error) {
+	defer ms.RUnlock()
+	ms.RLock()
+	records := make([]store.Record, 0)
+	if field == "order_uid" {
+		if id, ok := ms.uids[value]; ok {
+			records = append(records, store.Record{ID: id, Model: ms.m[id].Clone()})
+		}
+		return records, nil
+	}
+	index, ok := ms.index[field]
+	if !ok {
+		return nil, fmt.Errorf("error: can't search by '%s'", field)
+	}
+	for id := range index[value] {
+		records = append(records, store.Record{ID: id, Model: ms.m[id].Clone()})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, nil
+}
+
+func (ms *MapStore) add(id int, model *store.Model) {
+	ms.m[id] = model
+	ms.uids[model.Order_uid] = id
+	ms.indexModel(id, model)
+}
+
+func (ms *MapStore) remove(id int) {
+	model, ok := ms.m[id]
+	if !ok {
+		return
+	}
+	if ms.uids[model.Order_uid] == id {
+		delete(ms.uids, model.Order_uid)
+	}
+	for field, index := range ms.index {
+		values, _ := store.SearchValues(model, field)
+		for _, v := range values {
+			delete(index[v], id)
+			if len(index[v]) == 0 {
+				delete(index, v)
+			}
+		}
+	}
+	delete(ms.m, id)
+}
+
+func (ms *MapStore) indexModel(id int, model *store.Model) {
+	for field, index := range ms.index {
+		values, _ := store.SearchValues(model, field)
+		for _, v := range values {
+			if index[v] == nil {
+				index[v] = make(map[int]struct{})
+			}
+			index[v][id] = struct{}{}
+		}
+	}
+}
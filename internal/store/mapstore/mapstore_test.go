@@ -1,6 +1,7 @@
 package mapstore
 
 import (
+	"context"
 	"testing"
 
 	"github.com/ineverbee/wbl0/internal/store"
@@ -8,16 +9,150 @@ import (
 )
 
 func TestSetGet(t *testing.T) {
+	ctx := context.Background()
 	ms := NewMapStore(make(map[int]*store.Model, 1))
-	id, model := 1, new(store.Model)
-	require.NoError(t, ms.Set(&id, model))
+	id, model := 1, &store.Model{Order_uid: "b563feb7b2b84b6test"}
+	require.NoError(t, ms.Set(ctx, &id, model))
 
-	res, err := ms.Get(id)
+	res, err := ms.Get(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, model, res)
+
+	res, err = ms.GetByUID(ctx, model.Order_uid)
+	require.NoError(t, err)
+	require.Equal(t, model, res)
+
+	res, err = ms.GetByUID(ctx, "unknown")
+	require.Error(t, err)
+	require.Nil(t, res)
+
+	ms = NewMapStore(map[int]*store.Model{2: model})
+	res, err = ms.GetByUID(ctx, model.Order_uid)
 	require.NoError(t, err)
 	require.Equal(t, model, res)
 
 	id = -1
-	res, err = ms.Get(id)
+	res, err = ms.Get(ctx, id)
 	require.Error(t, err)
 	require.Nil(t, res)
 }
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMapStore(make(map[int]*store.Model))
+	id, newer := 1, &store.Model{Order_uid: "b563feb7b2b84b6test", Locale: "ru"}
+	require.NoError(t, ms.Set(ctx, &id, newer))
+
+	n := ms.Load([]store.Record{
+		{ID: 1, Model: &store.Model{Order_uid: "b563feb7b2b84b6test", Locale: "en"}},
+		{ID: 2, Model: &store.Model{Order_uid: "other_uid"}},
+	})
+	require.Equal(t, 1, n)
+
+	res, err := ms.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, newer, res)
+
+	res, err = ms.GetByUID(ctx, "other_uid")
+	require.NoError(t, err)
+	require.Equal(t, "other_uid", res.Order_uid)
+
+	// Testing 'Add', expecting only the order not cached yet added
+	added, err := ms.Add(ctx, 1, &store.Model{Order_uid: "b563feb7b2b84b6test"})
+	require.NoError(t, err)
+	require.False(t, added)
+	added, err = ms.Add(ctx, 3, &store.Model{Order_uid: "third_uid"})
+	require.NoError(t, err)
+	require.True(t, added)
+	res, err = ms.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, newer, res)
+
+	// Testing 'Swap', expecting the order replaced only while it equals old
+	stale := &store.Model{Order_uid: "b563feb7b2b84b6test", Locale: "en"}
+	swapped, err := ms.Swap(ctx, 1, stale, &store.Model{Order_uid: "b563feb7b2b84b6test"})
+	require.NoError(t, err)
+	require.False(t, swapped)
+	swapped, err = ms.Swap(ctx, 1, newer, &store.Model{Order_uid: "changed_uid"})
+	require.NoError(t, err)
+	require.True(t, swapped)
+	res, err = ms.GetByUID(ctx, "changed_uid")
+	require.NoError(t, err)
+	require.Equal(t, "changed_uid", res.Order_uid)
+	_, err = ms.GetByUID(ctx, "b563feb7b2b84b6test")
+	require.Error(t, err)
+	swapped, err = ms.Swap(ctx, 4, newer, newer)
+	require.NoError(t, err)
+	require.False(t, swapped)
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	model := &store.Model{Order_uid: "b563feb7b2b84b6test"}
+	ms := NewMapStore(map[int]*store.Model{1: model})
+
+	require.NoError(t, ms.Delete(ctx, 1))
+	_, err := ms.Get(ctx, 1)
+	require.Error(t, err)
+	_, err = ms.GetByUID(ctx, model.Order_uid)
+	require.Error(t, err)
+
+	require.NoError(t, ms.Delete(ctx, 2))
+}
+
+func TestIDs(t *testing.T) {
+	ms := NewMapStore(map[int]*store.Model{3: {Order_uid: "c"}, 1: {Order_uid: "a"}, 2: {Order_uid: "b"}})
+
+	// Testing 'IDs', expecting sorted ids
+	require.Equal(t, []int{1, 2, 3}, ms.IDs())
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMapStore(map[int]*store.Model{
+		1: {Order_uid: "uid1", Track_number: "TRACK", Customer_id: "test", Items: []*store.Item{{Rid: "rid1"}}},
+	})
+	id, model := 2, &store.Model{Order_uid: "uid2", Track_number: "TRACK", Customer_id: "other", Items: []*store.Item{{Rid: "rid2"}}}
+	require.NoError(t, ms.Set(ctx, &id, model))
+
+	// Testing 'Search' by every field, expecting the matching orders in id order
+	for _, c := range []struct {
+		field, value string
+		ids          []int
+	}{
+		{"order_uid", "uid2", []int{2}},
+		{"track_number", "TRACK", []int{1, 2}},
+		{"customer_id", "test", []int{1}},
+		{"rid", "rid2", []int{2}},
+		{"rid", "unknown", []int{}},
+	} {
+		res, err := ms.Search(ctx, c.field, c.value)
+		require.NoError(t, err)
+		ids := make([]int, 0)
+		for _, r := range res {
+			ids = append(ids, r.ID)
+		}
+		require.Equal(t, c.ids, ids, c.field)
+	}
+
+	// Testing 'Search' after 'Set' of a changed order, expecting the old values unindexed
+	changed := &store.Model{Order_uid: "uid2", Track_number: "NEWTRACK", Customer_id: "other"}
+	require.NoError(t, ms.Set(ctx, &id, changed))
+	res, err := ms.Search(ctx, "track_number", "TRACK")
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	res, err = ms.Search(ctx, "rid", "rid2")
+	require.NoError(t, err)
+	require.Empty(t, res)
+
+	// Testing 'Search' after 'Delete', expecting the order unindexed
+	require.NoError(t, ms.Delete(ctx, 2))
+	res, err = ms.Search(ctx, "customer_id", "other")
+	require.NoError(t, err)
+	require.Empty(t, res)
+	require.Empty(t, ms.index["track_number"]["NEWTRACK"])
+
+	// Testing 'Search', expecting error on an unknown field
+	_, err = ms.Search(ctx, "locale", "en")
+	require.Error(t, err)
+}
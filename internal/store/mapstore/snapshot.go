@@ -0,0 +1,183 @@
+package mapstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+)
+
+// A snapshot file is snapshotMagic, the format version, the length and the
+// CRC-32C of the payload as big-endian uint32, uint64 and uint32, then the
+// gob encoded payload.
+const (
+	snapshotMagic   = "WBL0SNAP"
+	snapshotVersion = 2
+)
+
+// lastSnapshotTimeout bounds asking for the time of the snapshot RunSnapshots
+// writes on shutdown.
+const lastSnapshotTimeout = 5 * time.Second
+
+var (
+	ErrorBadSnapshot = errors.New("error: bad snapshot")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Snapshot is the content of a snapshot file.
+type Snapshot struct {
+	// HighWater is the greatest id in the snapshot. Orders with greater ids
+	// were written after it.
+	HighWater int
+	// Since is the db time the snapshot was taken at. Orders up to HighWater
+	// replaced or archived since may differ from the snapshot.
+	Since   time.Time
+	Records []store.Record
+}
+
+// WriteSnapshot writes every cached order to path with since, and returns
+// the high-water mark of the snapshot. since must be taken before the call,
+// see Snapshot. The file is replaced atomically, so a crash leaves the
+// previous snapshot intact.
+func (ms *MapStore) WriteSnapshot(path string, since time.Time) (int, error) {
+	ms.RLock()
+	snap := Snapshot{Since: since, Records: make([]store.Record, 0, len(ms.m))}
+	for id, model := range ms.m {
+		snap.Records = append(snap.Records, store.Record{ID: id, Model: model})
+		if id > snap.HighWater {
+			snap.HighWater = id
+		}
+	}
+	// Cached models are replaced, never changed, so they're encoded unlocked.
+	ms.RUnlock()
+
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(snap); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := bufio.NewWriter(tmp)
+	w.WriteString(snapshotMagic)
+	binary.Write(w, binary.BigEndian, uint32(snapshotVersion))
+	binary.Write(w, binary.BigEndian, uint64(payload.Len()))
+	binary.Write(w, binary.BigEndian, crc32.Checksum(payload.Bytes(), crcTable))
+	payload.WriteTo(w)
+	if err = w.Flush(); err != nil {
+		return 0, err
+	}
+	if err = tmp.Sync(); err != nil {
+		return 0, err
+	}
+	if err = tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	return snap.HighWater, syncDir(filepath.Dir(path))
+}
+
+// ReadSnapshot reads the snapshot written by WriteSnapshot. It returns
+// ErrorBadSnapshot if the file is truncated or corrupt.
+func ReadSnapshot(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var header struct {
+		Magic   [len(snapshotMagic)]byte
+		Version uint32
+		Length  uint64
+		CRC     uint32
+	}
+	if err = binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrorBadSnapshot, err.Error())
+	}
+	if string(header.Magic[:]) != snapshotMagic || header.Version != snapshotVersion {
+		return nil, fmt.Errorf("%w: unknown format", ErrorBadSnapshot)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if header.Length > uint64(info.Size()) {
+		return nil, fmt.Errorf("%w: truncated", ErrorBadSnapshot)
+	}
+	payload := make([]byte, header.Length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrorBadSnapshot, err.Error())
+	}
+	if crc32.Checksum(payload, crcTable) != header.CRC {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrorBadSnapshot)
+	}
+	snap := new(Snapshot)
+	if err = gob.NewDecoder(bytes.NewReader(payload)).Decode(snap); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrorBadSnapshot, err.Error())
+	}
+	return snap, nil
+}
+
+// RunSnapshots writes a snapshot to path every interval and once more when
+// ctx is done, taken since the time now returns right before.
+func (ms *MapStore) RunSnapshots(ctx context.Context, path string, interval time.Duration, now func(context.Context) (time.Time, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// ctx is done, so the last snapshot gets a context of its own.
+			last, cancel := context.WithTimeout(context.Background(), lastSnapshotTimeout)
+			ms.logSnapshot(last, path, now)
+			cancel()
+			return
+		case <-ticker.C:
+			ms.logSnapshot(ctx, path, now)
+		}
+	}
+}
+
+func (ms *MapStore) logSnapshot(ctx context.Context, path string, now func(context.Context) (time.Time, error)) {
+	start := time.Now()
+	since, err := now(ctx)
+	if err != nil {
+		log.Printf("[SNAPSHOT] Error: %s\n", err.Error())
+		return
+	}
+	highWater, err := ms.WriteSnapshot(path, since)
+	if err != nil {
+		log.Printf("[SNAPSHOT] Error: %s\n", err.Error())
+		return
+	}
+	log.Printf("[SNAPSHOT] Written up to id %d in %s\n", highWater, time.Since(start))
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
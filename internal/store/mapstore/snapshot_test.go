@@ -0,0 +1,84 @@
+package mapstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	date := time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+	ms := NewMapStore(map[int]*store.Model{
+		3: {Order_uid: "uid3", Date_created: &date, Items: []*store.Item{{Rid: "rid"}}},
+		7: {Order_uid: "uid7", Delivery: &store.Delivery{City: "Kiryat Mozkin"}},
+	})
+
+	// Testing 'WriteSnapshot', expecting the greatest id as high-water mark
+	highWater, err := ms.WriteSnapshot(path, date)
+	require.NoError(t, err)
+	require.Equal(t, 7, highWater)
+
+	// Testing 'ReadSnapshot', expecting every order back with the time
+	snap, err := ReadSnapshot(path)
+	require.NoError(t, err)
+	require.Equal(t, 7, snap.HighWater)
+	require.True(t, date.Equal(snap.Since))
+	loaded := NewMapStore(make(map[int]*store.Model))
+	require.Equal(t, 2, loaded.Load(snap.Records))
+	for id, model := range ms.m {
+		res, err := loaded.Get(context.Background(), id)
+		require.NoError(t, err)
+		require.Equal(t, model, res)
+	}
+
+	// Testing 'ReadSnapshot' of a corrupt file, expecting ErrorBadSnapshot
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	b[len(b)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(path, b, 0644))
+	_, err = ReadSnapshot(path)
+	require.ErrorIs(t, err, ErrorBadSnapshot)
+
+	// Testing 'ReadSnapshot' of a truncated file, expecting ErrorBadSnapshot
+	require.NoError(t, os.WriteFile(path, b[:len(b)/2], 0644))
+	_, err = ReadSnapshot(path)
+	require.ErrorIs(t, err, ErrorBadSnapshot)
+
+	// Testing 'ReadSnapshot' of another file, expecting ErrorBadSnapshot
+	require.NoError(t, os.WriteFile(path, []byte("not a snapshot at all"), 0644))
+	_, err = ReadSnapshot(path)
+	require.ErrorIs(t, err, ErrorBadSnapshot)
+
+	// Testing 'ReadSnapshot' without a file, expecting os.ErrNotExist
+	_, err = ReadSnapshot(filepath.Join(t.TempDir(), "missing.snap"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestRunSnapshots(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	ms := NewMapStore(map[int]*store.Model{1: {Order_uid: "uid1"}})
+
+	since := time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+	now := func(ctx context.Context) (time.Time, error) {
+		return since, ctx.Err()
+	}
+
+	// Testing 'RunSnapshots', expecting a snapshot on shutdown with the time
+	// now returns
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ms.RunSnapshots(ctx, path, time.Hour, now)
+	snap, err := ReadSnapshot(path)
+	require.NoError(t, err)
+	require.Equal(t, 1, snap.HighWater)
+	require.True(t, since.Equal(snap.Since))
+	files, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
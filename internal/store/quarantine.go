@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Classes of the failures that put a message in quarantine.
+const (
+	// ClassJSON messages aren't JSON.
+	ClassJSON = "json"
+	// ClassDecode messages are JSON that doesn't fit Model, like a string
+	// where a number is expected.
+	ClassDecode = "decode"
+	// ClassValidation messages fit Model but lack required fields.
+	ClassValidation = "validation"
+	// ClassDB messages are valid but were refused by the db for good, like
+	// an order that breaks a constraint.
+	ClassDB = "db"
+)
+
+// Quarantined is a message that was rejected by the worker, kept so that it
+// can be fixed and resubmitted.
+type Quarantined struct {
+	ID             int       `json:"id"`
+	Data           []byte    `json:"data"`
+	Class          string    `json:"class"`
+	Reason         string    `json:"reason"`
+	Channel        string    `json:"channel"`
+	Nats_seq       uint64    `json:"nats_seq"`
+	Published_at   time.Time `json:"published_at"`
+	Quarantined_at time.Time `json:"quarantined_at"`
+}
+
+// QuarantineIface keeps rejected messages. Quarantine ignores a message
+// that is already kept with the same channel and NATS sequence, as rejected
+// messages may be redelivered.
+type QuarantineIface interface {
+	Quarantine(context.Context, *Quarantined) error
+	// ListQuarantined returns up to limit messages, newest first.
+	ListQuarantined(ctx context.Context, limit int) ([]Quarantined, error)
+	GetQuarantined(context.Context, int) (*Quarantined, error)
+	// UpdateQuarantined replaces the data, class and reason of a message.
+	UpdateQuarantined(context.Context, *Quarantined) error
+	DeleteQuarantined(context.Context, int) error
+}
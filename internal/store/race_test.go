@@ -0,0 +1,162 @@
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/ineverbee/wbl0/internal/store/lru"
+	"github.com/ineverbee/wbl0/internal/store/mapstore"
+	"github.com/ineverbee/wbl0/internal/store/readthrough"
+	"github.com/ineverbee/wbl0/internal/store/sharded"
+	"github.com/stretchr/testify/require"
+)
+
+// These tests are meant to be run with the race detector, go test -race, as
+// well as without it: readers and writers share the caches while changing
+// every order they get or have set.
+
+const (
+	raceIDs     = 8
+	raceRounds  = 200
+	raceWorkers = 4
+)
+
+// raceModel is version v of order id. Every version is consistent: all of
+// its fields carry v.
+func raceModel(id, v int) *store.Model {
+	date := time.Date(2021, 11, 26, 6, 22, v, 0, time.UTC)
+	version := fmt.Sprintf("v%d", v)
+	return &store.Model{
+		Order_uid:    fmt.Sprintf("uid%d", id),
+		Track_number: version,
+		Date_created: &date,
+		Delivery:     &store.Delivery{Name: version},
+		Payment:      &store.Payment{Transaction: version},
+		Items:        []*store.Item{{Rid: version}},
+	}
+}
+
+// consistent checks that m is a whole version of an order, so that no change
+// made to another copy got through.
+func consistent(m *store.Model) error {
+	v := m.Track_number
+	if m.Delivery == nil || m.Payment == nil || len(m.Items) != 1 || m.Date_created == nil {
+		return fmt.Errorf("order %s %s is incomplete", m.Order_uid, v)
+	}
+	if m.Delivery.Name != v || m.Payment.Transaction != v || m.Items[0].Rid != v ||
+		fmt.Sprintf("v%d", m.Date_created.Second()) != v {
+		return fmt.Errorf("order %s %s was changed", m.Order_uid, v)
+	}
+	return nil
+}
+
+// mutate changes everything in m that a cache could share.
+func mutate(m *store.Model) {
+	m.Track_number = "mutated"
+	*m.Date_created = time.Time{}
+	m.Delivery.Name = "mutated"
+	m.Payment.Transaction = "mutated"
+	m.Items[0].Rid = "mutated"
+	m.Items = append(m.Items, &store.Item{})
+}
+
+// sharedDB always returns the same order, to catch read-through misses
+// that hand it out.
+type sharedDB struct {
+	store.DBMock
+	model *store.Model
+}
+
+func (db *sharedDB) Get(ctx context.Context, id int) (*store.Model, error) {
+	return db.model, nil
+}
+
+func TestRace(t *testing.T) {
+	for name, newCache := range map[string]func() store.CacheIface{
+		"MapStore": func() store.CacheIface {
+			return mapstore.NewMapStore(make(map[int]*store.Model))
+		},
+		"LRUStore": func() store.CacheIface {
+			return lru.NewLRUStore(0, 0)
+		},
+		"ShardedStore": func() store.CacheIface {
+			return sharded.NewShardedStore(4, sharded.RouteID)
+		},
+		"ReadThrough": func() store.CacheIface {
+			db := &sharedDB{model: raceModel(raceIDs+1, 0)}
+			return readthrough.NewReadThrough(mapstore.NewMapStore(make(map[int]*store.Model)), db, time.Minute)
+		},
+	} {
+		newCache := newCache
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			testRace(t, newCache())
+		})
+	}
+}
+
+func testRace(t *testing.T, cache store.CacheIface) {
+	ctx := context.Background()
+	for id := 1; id <= raceIDs; id++ {
+		id := id
+		require.NoError(t, cache.Set(ctx, &id, raceModel(id, 0)))
+	}
+
+	// Testing concurrent 'Set', 'Get', 'GetByUID' and 'Delete' while the
+	// orders are changed, expecting every read to be a whole version
+	errs := make(chan error, 2*raceWorkers)
+	var wg sync.WaitGroup
+	for w := 0; w < raceWorkers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < raceRounds; i++ {
+				id := i%raceIDs + 1
+				model := raceModel(id, (w*raceRounds+i)%60)
+				if err := cache.Set(ctx, &id, model); err != nil {
+					errs <- err
+					return
+				}
+				mutate(model)
+				if i%50 == 49 {
+					cache.Delete(ctx, id)
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < raceRounds; i++ {
+				// raceIDs+1 is only in the db of a read-through cache.
+				id := i%(raceIDs+1) + 1
+				model, err := cache.Get(ctx, id)
+				if i%2 == 1 {
+					model, err = cache.GetByUID(ctx, fmt.Sprintf("uid%d", id))
+				}
+				if err != nil || model == nil {
+					continue
+				}
+				if err = consistent(model); err != nil {
+					errs <- err
+					return
+				}
+				mutate(model)
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	// Testing 'Get' after the run, expecting unchanged orders
+	for id := 1; id <= raceIDs+1; id++ {
+		if model, err := cache.Get(ctx, id); err == nil && model != nil {
+			require.NoError(t, consistent(model))
+		}
+	}
+}
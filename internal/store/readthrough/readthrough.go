@@ -0,0 +1,199 @@
+// Package readthrough is a store.CacheIface that loads orders missing from a
+// cache from the db.
+package readthrough
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+)
+
+// DefaultNegativeTTL is how long an order the db doesn't have is reported
+// missing without asking the db again.
+const DefaultNegativeTTL = 5 * time.Second
+
+// DefaultLoadTimeout bounds a db query shared by misses, which isn't bound
+// to the context of any of them.
+const DefaultLoadTimeout = 10 * time.Second
+
+const maxNegative = 1024
+
+// ReadThrough serves orders from cache and, on a miss, from db. Concurrent
+// misses for the same order share one db query, and orders the db doesn't
+// have are remembered for negativeTTL.
+type ReadThrough struct {
+	cache       store.CacheIface
+	db          store.DBIface
+	negativeTTL time.Duration
+	loadTimeout time.Duration
+
+	mu       sync.Mutex
+	calls    map[string]*call
+	negative map[string]time.Time
+}
+
+// call is a db query shared by concurrent misses.
+type call struct {
+	done  chan struct{}
+	model *store.Model
+	err   error
+}
+
+func NewReadThrough(cache store.CacheIface, db store.DBIface, negativeTTL time.Duration) *ReadThrough {
+	return &ReadThrough{
+		cache:       cache,
+		db:          db,
+		negativeTTL: negativeTTL,
+		loadTimeout: DefaultLoadTimeout,
+		calls:       make(map[string]*call),
+		negative:    make(map[string]time.Time),
+	}
+}
+
+// Cache returns the cache ReadThrough reads through.
+func (rt *ReadThrough) Cache() store.CacheIface {
+	return rt.cache
+}
+
+func (rt *ReadThrough) Get(ctx context.Context, id int) (*store.Model, error) {
+	if model, err := rt.cache.Get(ctx, id); err == nil {
+		return model, nil
+	}
+	return rt.load(ctx, idKey(id), func(ctx context.Context) (*store.Model, error) {
+		model, err := rt.db.Get(ctx, id)
+		if err == nil && model != nil {
+			// The order is served even if the cache is down.
+			if err := rt.add(ctx, id, model); err != nil {
+				log.Printf("[CACHE] Error: %s\n", err.Error())
+			}
+		}
+		return model, err
+	})
+}
+
+// GetByUID falls back to the db like Get, but the order isn't cached, as
+// the db doesn't tell its id. It's cached once it's read by id.
+func (rt *ReadThrough) GetByUID(ctx context.Context, uid string) (*store.Model, error) {
+	if model, err := rt.cache.GetByUID(ctx, uid); err == nil {
+		return model, nil
+	}
+	return rt.load(ctx, uidKey(uid), func(ctx context.Context) (*store.Model, error) {
+		return rt.db.GetByUID(ctx, uid)
+	})
+}
+
+// Set caches model and forgets that the order was missing.
+func (rt *ReadThrough) Set(ctx context.Context, id *int, model *store.Model) error {
+	rt.forget(idKey(*id), uidKey(model.Order_uid))
+	return rt.cache.Set(ctx, id, model)
+}
+
+func (rt *ReadThrough) Delete(ctx context.Context, id int) error {
+	return rt.cache.Delete(ctx, id)
+}
+
+// add caches an order read from the db unless the worker cached a version
+// meanwhile, which may be newer. Caches that can't add are set.
+func (rt *ReadThrough) add(ctx context.Context, id int, model *store.Model) error {
+	if a, ok := rt.cache.(store.AdderIface); ok {
+		_, err := a.Add(ctx, id, model)
+		return err
+	}
+	return rt.cache.Set(ctx, &id, model)
+}
+
+// load runs f for key unless the order is known to be missing or f already
+// runs for key, in which case its result is waited for.
+func (rt *ReadThrough) load(ctx context.Context, key string, f func(context.Context) (*store.Model, error)) (*store.Model, error) {
+	rt.mu.Lock()
+	if until, ok := rt.negative[key]; ok {
+		if time.Now().Before(until) {
+			rt.mu.Unlock()
+			return nil, notFound(key)
+		}
+		delete(rt.negative, key)
+	}
+	c, ok := rt.calls[key]
+	if !ok {
+		c = &call{done: make(chan struct{})}
+		rt.calls[key] = c
+		go rt.run(key, c, f)
+	}
+	rt.mu.Unlock()
+
+	select {
+	case <-c.done:
+		// Every miss gets its own copy of the loaded order.
+		return c.model.Clone(), c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// run queries the db for all the misses of key. It isn't bound to the
+// context of the first miss, so that its cancellation doesn't fail the others,
+// but to loadTimeout, so that a stuck query doesn't hold the key forever.
+func (rt *ReadThrough) run(key string, c *call, f func(context.Context) (*store.Model, error)) {
+	ctx, cancel := context.WithTimeout(context.Background(), rt.loadTimeout)
+	c.model, c.err = f(ctx)
+	cancel()
+	missing := c.model == nil && (c.err == nil || errors.Is(c.err, store.Error404NotFound))
+	if missing {
+		c.model, c.err = nil, notFound(key)
+	}
+	rt.mu.Lock()
+	if missing && rt.negativeTTL > 0 {
+		rt.prune()
+		rt.negative[key] = time.Now().Add(rt.negativeTTL)
+	}
+	delete(rt.calls, key)
+	rt.mu.Unlock()
+	close(c.done)
+}
+
+// prune makes room for a negative entry once there are maxNegative of them,
+// so ids that are asked for once don't pile up. Expired entries are dropped
+// and, if none are, the oldest one.
+func (rt *ReadThrough) prune() {
+	if len(rt.negative) < maxNegative {
+		return
+	}
+	now := time.Now()
+	oldest, oldestUntil := "", time.Time{}
+	for key, until := range rt.negative {
+		if now.After(until) {
+			delete(rt.negative, key)
+		} else if oldest == "" || until.Before(oldestUntil) {
+			oldest, oldestUntil = key, until
+		}
+	}
+	if len(rt.negative) >= maxNegative {
+		delete(rt.negative, oldest)
+	}
+}
+
+func (rt *ReadThrough) forget(keys ...string) {
+	defer rt.mu.Unlock()
+	rt.mu.Lock()
+	for _, key := range keys {
+		delete(rt.negative, key)
+	}
+}
+
+func idKey(id int) string {
+	return "id:" + strconv.Itoa(id)
+}
+
+func uidKey(uid string) string {
+	return "uid:" + uid
+}
+
+func notFound(key string) error {
+	return fmt.Errorf("%w: %s", store.Error404NotFound, key)
+}
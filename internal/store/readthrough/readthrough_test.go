@@ -0,0 +1,214 @@
+package readthrough
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/ineverbee/wbl0/internal/store/mapstore"
+	"github.com/stretchr/testify/require"
+)
+
+// dbMock has order 1 and counts the queries, which wait for release.
+type dbMock struct {
+	store.DBMock
+	queries int32
+	release chan struct{}
+}
+
+func (db *dbMock) Get(ctx context.Context, id int) (*store.Model, error) {
+	atomic.AddInt32(&db.queries, 1)
+	select {
+	case <-db.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if id != 1 {
+		return nil, store.Error404NotFound
+	}
+	return &store.Model{Order_uid: "b563feb7b2b84b6test"}, nil
+}
+
+func (db *dbMock) GetByUID(ctx context.Context, uid string) (*store.Model, error) {
+	atomic.AddInt32(&db.queries, 1)
+	select {
+	case <-db.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if uid != "b563feb7b2b84b6test" {
+		return nil, nil
+	}
+	return &store.Model{Order_uid: uid}, nil
+}
+
+func newReadThrough(ttl time.Duration) (*ReadThrough, *dbMock, *mapstore.MapStore) {
+	db := &dbMock{release: make(chan struct{})}
+	close(db.release)
+	cache := mapstore.NewMapStore(make(map[int]*store.Model))
+	return NewReadThrough(cache, db, ttl), db, cache
+}
+
+func TestGet(t *testing.T) {
+	ctx := context.Background()
+	rt, db, cache := newReadThrough(time.Minute)
+
+	// Testing 'Get' on a miss, expecting the order from db cached
+	res, err := rt.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "b563feb7b2b84b6test", res.Order_uid)
+	_, err = cache.Get(ctx, 1)
+	require.NoError(t, err)
+
+	// Testing 'Get' on a hit, expecting no db query
+	_, err = rt.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), db.queries)
+
+	// Testing 'GetByUID' on a miss, expecting the order from db
+	res, err = rt.GetByUID(ctx, "b563feb7b2b84b6test")
+	require.NoError(t, err)
+	require.Equal(t, "b563feb7b2b84b6test", res.Order_uid)
+	require.Equal(t, int32(1), db.queries)
+	_, err = rt.GetByUID(ctx, "unknown")
+	require.ErrorIs(t, err, store.Error404NotFound)
+}
+
+func TestNegative(t *testing.T) {
+	ctx := context.Background()
+	rt, db, _ := newReadThrough(time.Minute)
+
+	// Testing 'Get' of a missing order twice, expecting one db query
+	for i := 0; i < 2; i++ {
+		res, err := rt.Get(ctx, 2)
+		require.ErrorIs(t, err, store.Error404NotFound)
+		require.Nil(t, res)
+	}
+	require.Equal(t, int32(1), db.queries)
+
+	// Testing 'Set' of the missing order, expecting it served
+	id := 2
+	require.NoError(t, rt.Set(ctx, &id, &store.Model{Order_uid: "new"}))
+	res, err := rt.Get(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, "new", res.Order_uid)
+
+	// Testing 'Get' after the TTL, expecting the db asked again
+	rt, db, _ = newReadThrough(time.Millisecond)
+	_, err = rt.Get(ctx, 2)
+	require.Error(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = rt.Get(ctx, 2)
+	require.Error(t, err)
+	require.Equal(t, int32(2), db.queries)
+
+	// Testing 'Get' of more missing orders than are remembered, expecting
+	// the oldest ones forgotten
+	rt, _, _ = newReadThrough(time.Minute)
+	for i := 0; i < maxNegative+10; i++ {
+		_, err = rt.Get(ctx, i+2)
+		require.Error(t, err)
+	}
+	require.Len(t, rt.negative, maxNegative)
+	require.NotContains(t, rt.negative, idKey(2))
+	require.Contains(t, rt.negative, idKey(maxNegative+11))
+}
+
+func TestSingleflight(t *testing.T) {
+	ctx := context.Background()
+	db := &dbMock{release: make(chan struct{})}
+	rt := NewReadThrough(mapstore.NewMapStore(make(map[int]*store.Model)), db, time.Minute)
+
+	// Testing concurrent 'Get' misses, expecting one db query
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := rt.Get(ctx, 1)
+			require.NoError(t, err)
+			require.Equal(t, "b563feb7b2b84b6test", res.Order_uid)
+		}()
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&db.queries) == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(db.release)
+	wg.Wait()
+	require.Equal(t, int32(1), db.queries)
+
+	// Testing 'Get' with a canceled context, expecting ctx error
+	db.release = make(chan struct{})
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := rt.Get(cctx, 3)
+	require.ErrorIs(t, err, context.Canceled)
+	close(db.release)
+
+	// Testing 'Get' of a stuck db query, expecting it to time out
+	rt = NewReadThrough(mapstore.NewMapStore(make(map[int]*store.Model)), &dbMock{release: make(chan struct{})}, time.Minute)
+	rt.loadTimeout = 10 * time.Millisecond
+	_, err = rt.Get(ctx, 1)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestGetRace(t *testing.T) {
+	ctx := context.Background()
+	db := &dbMock{release: make(chan struct{})}
+	cache := mapstore.NewMapStore(make(map[int]*store.Model))
+	rt := NewReadThrough(cache, db, time.Minute)
+
+	// Testing 'Get' racing a newer 'Set' of the worker, expecting the newer
+	// order kept in cache
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := rt.Get(ctx, 1)
+		require.NoError(t, err)
+	}()
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&db.queries) == 1 }, time.Second, time.Millisecond)
+	id, newer := 1, &store.Model{Order_uid: "b563feb7b2b84b6test", Locale: "ru"}
+	require.NoError(t, cache.Set(ctx, &id, newer))
+	close(db.release)
+	<-done
+	res, err := cache.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, newer, res)
+}
+
+// downCache fails every call, like a cache server that can't be reached.
+type downCache struct{}
+
+func (downCache) Set(ctx context.Context, id *int, model *store.Model) error {
+	return errors.New("down")
+}
+
+func (downCache) Get(ctx context.Context, id int) (*store.Model, error) {
+	return nil, errors.New("down")
+}
+
+func (downCache) GetByUID(ctx context.Context, uid string) (*store.Model, error) {
+	return nil, errors.New("down")
+}
+
+func (downCache) Delete(ctx context.Context, id int) error {
+	return errors.New("down")
+}
+
+func TestCacheDown(t *testing.T) {
+	ctx := context.Background()
+	db := &dbMock{release: make(chan struct{})}
+	close(db.release)
+	rt := NewReadThrough(downCache{}, db, time.Minute)
+
+	// Testing 'Get' and 'GetByUID' with the cache down, expecting the order from db
+	res, err := rt.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "b563feb7b2b84b6test", res.Order_uid)
+	res, err = rt.GetByUID(ctx, "b563feb7b2b84b6test")
+	require.NoError(t, err)
+	require.Equal(t, "b563feb7b2b84b6test", res.Order_uid)
+}
@@ -0,0 +1,336 @@
+// Package redisstore is a store.CacheIface kept in a Redis-compatible server,
+// so that every instance shares one cache.
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	orderKey = "wbl0:order:"
+	uidKey   = "wbl0:uid:"
+)
+
+// maxRetries is how many times a write is retried after a concurrent write
+// of the same order failed its transaction.
+const maxRetries = 10
+
+// DefaultDownFor is how long a RedisStore fails fast after the server
+// couldn't be reached, instead of waiting for a timeout on every call.
+const DefaultDownFor = time.Second
+
+var ErrorCacheDown = errors.New("error: cache is down")
+
+type Config struct {
+	Addr     string
+	Password string
+	DB       int
+	PoolSize int
+	// TTL is how long an order is kept since it was last written. A zero TTL
+	// keeps orders until they're deleted or evicted by the server.
+	TTL     time.Duration
+	Timeout time.Duration
+	DownFor time.Duration
+}
+
+type RedisStore struct {
+	client  *redis.Client
+	ttl     time.Duration
+	downFor time.Duration
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+func NewRedisStore(cfg Config) *RedisStore {
+	if cfg.DownFor <= 0 {
+		cfg.DownFor = DefaultDownFor
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:         cfg.Addr,
+		Password:     cfg.Password,
+		DB:           cfg.DB,
+		PoolSize:     cfg.PoolSize,
+		DialTimeout:  cfg.Timeout,
+		ReadTimeout:  cfg.Timeout,
+		WriteTimeout: cfg.Timeout,
+	})
+	return &RedisStore{client: client, ttl: cfg.TTL, downFor: cfg.DownFor}
+}
+
+func (rs *RedisStore) Close() error {
+	return rs.client.Close()
+}
+
+// Ping checks that the server can be reached.
+func (rs *RedisStore) Ping(ctx context.Context) error {
+	return rs.check(rs.client.Ping(ctx).Err())
+}
+
+func (rs *RedisStore) Get(ctx context.Context, id int) (*store.Model, error) {
+	if err := rs.up(); err != nil {
+		return nil, err
+	}
+	return rs.get(ctx, id)
+}
+
+func (rs *RedisStore) GetByUID(ctx context.Context, uid string) (*store.Model, error) {
+	if err := rs.up(); err != nil {
+		return nil, err
+	}
+	id, err := rs.client.Get(ctx, uidKey+uid).Int()
+	if errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("error: no rows with order_uid '%s'", uid)
+	}
+	if err != nil {
+		return nil, rs.check(err)
+	}
+	model, err := rs.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// The order_uid of the order may have changed since.
+	if model.Order_uid != uid {
+		return nil, fmt.Errorf("error: no rows with order_uid '%s'", uid)
+	}
+	return model, nil
+}
+
+// Set caches model. The order_uid the order was cached with before is
+// dropped if it changed, and the order is watched meanwhile, so a concurrent
+// write makes Set start over.
+func (rs *RedisStore) Set(ctx context.Context, id *int, model *store.Model) error {
+	if err := rs.up(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(model)
+	if err != nil {
+		return err
+	}
+	key := orderKey + strconv.Itoa(*id)
+	for i := 0; i < maxRetries; i++ {
+		err = rs.client.Watch(ctx, func(tx *redis.Tx) error {
+			cur, err := cached(ctx, tx, key)
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return err
+			}
+			stale, err := staleUID(ctx, tx, *id, cur, model)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+				p.Set(ctx, key, b, rs.ttl)
+				p.Set(ctx, uidKey+model.Order_uid, *id, rs.ttl)
+				if stale != "" {
+					p.Del(ctx, stale)
+				}
+				return nil
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	return rs.check(err)
+}
+
+// Add caches model unless id is cached already.
+func (rs *RedisStore) Add(ctx context.Context, id int, model *store.Model) (bool, error) {
+	if err := rs.up(); err != nil {
+		return false, err
+	}
+	b, err := json.Marshal(model)
+	if err != nil {
+		return false, err
+	}
+	var added *redis.BoolCmd
+	_, err = rs.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		added = p.SetNX(ctx, orderKey+strconv.Itoa(id), b, rs.ttl)
+		p.SetNX(ctx, uidKey+model.Order_uid, id, rs.ttl)
+		return nil
+	})
+	if err = rs.check(err); err != nil {
+		return false, err
+	}
+	return added.Val(), nil
+}
+
+// Swap caches model unless the order cached under id is gone or no longer
+// equals old. The order is watched meanwhile, so a concurrent write makes it
+// fail rather than be overwritten.
+func (rs *RedisStore) Swap(ctx context.Context, id int, old, model *store.Model) (bool, error) {
+	if err := rs.up(); err != nil {
+		return false, err
+	}
+	b, err := json.Marshal(model)
+	if err != nil {
+		return false, err
+	}
+	key, swapped := orderKey+strconv.Itoa(id), false
+	err = rs.client.Watch(ctx, func(tx *redis.Tx) error {
+		cur, err := cached(ctx, tx, key)
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(store.Diff(cur, old)) > 0 {
+			return nil
+		}
+		stale, err := staleUID(ctx, tx, id, cur, model)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Set(ctx, key, b, rs.ttl)
+			p.Set(ctx, uidKey+model.Order_uid, id, rs.ttl)
+			if stale != "" {
+				p.Del(ctx, stale)
+			}
+			return nil
+		})
+		swapped = err == nil
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		return false, nil
+	}
+	if err = rs.check(err); err != nil {
+		return false, err
+	}
+	return swapped, nil
+}
+
+func (rs *RedisStore) Delete(ctx context.Context, id int) error {
+	if err := rs.up(); err != nil {
+		return err
+	}
+	model, err := rs.get(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrorCacheDown) {
+			return err
+		}
+		return nil
+	}
+	_, err = rs.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, orderKey+strconv.Itoa(id), uidKey+model.Order_uid)
+		return nil
+	})
+	return rs.check(err)
+}
+
+// Load adds the records that aren't cached yet and returns how many were
+// added. Cached entries are kept, as they may be newer than the records.
+func (rs *RedisStore) Load(records []store.Record) int {
+	if len(records) == 0 || rs.up() != nil {
+		return 0
+	}
+	ctx := context.Background()
+	added := make([]*redis.BoolCmd, len(records))
+	_, err := rs.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, r := range records {
+			b, err := json.Marshal(r.Model)
+			if err != nil {
+				return err
+			}
+			added[i] = p.SetNX(ctx, orderKey+strconv.Itoa(r.ID), b, rs.ttl)
+			p.SetNX(ctx, uidKey+r.Model.Order_uid, r.ID, rs.ttl)
+		}
+		return nil
+	})
+	if rs.check(err) != nil {
+		return 0
+	}
+	n := 0
+	for _, cmd := range added {
+		if cmd != nil && cmd.Val() {
+			n++
+		}
+	}
+	return n
+}
+
+func (rs *RedisStore) get(ctx context.Context, id int) (*store.Model, error) {
+	model, err := cached(ctx, rs.client, orderKey+strconv.Itoa(id))
+	if errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("error: no rows with id '%d'", id)
+	}
+	if err != nil {
+		return nil, rs.check(err)
+	}
+	return model, nil
+}
+
+// cached reads the order kept under key, or returns redis.Nil.
+func cached(ctx context.Context, c redis.Cmdable, key string) (*store.Model, error) {
+	b, err := c.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	model := new(store.Model)
+	if err = json.Unmarshal(b, model); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// staleUID returns the order_uid key of cur if model changes the order_uid of
+// order id and the key still maps to id, or "" if there is nothing to drop.
+// The key is watched by tx, so that it isn't dropped after another order took
+// the order_uid meanwhile.
+func staleUID(ctx context.Context, tx *redis.Tx, id int, cur, model *store.Model) (string, error) {
+	if cur == nil || cur.Order_uid == model.Order_uid {
+		return "", nil
+	}
+	key := uidKey + cur.Order_uid
+	if err := tx.Watch(ctx, key).Err(); err != nil {
+		return "", err
+	}
+	owner, err := tx.Get(ctx, key).Int()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if owner != id {
+		return "", nil
+	}
+	return key, nil
+}
+
+// up returns ErrorCacheDown while the server is considered unreachable.
+func (rs *RedisStore) up() error {
+	defer rs.mu.Unlock()
+	rs.mu.Lock()
+	if time.Now().Before(rs.downUntil) {
+		return ErrorCacheDown
+	}
+	return nil
+}
+
+// check marks the server down for downFor if err means it can't be reached.
+func (rs *RedisStore) check(err error) error {
+	if err == nil || errors.Is(err, redis.Nil) {
+		return err
+	}
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) || errors.Is(err, context.Canceled) {
+		return err
+	}
+	rs.mu.Lock()
+	rs.downUntil = time.Now().Add(rs.downFor)
+	rs.mu.Unlock()
+	return fmt.Errorf("%w: %s", ErrorCacheDown, err.Error())
+}
@@ -0,0 +1,165 @@
+package redisstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/stretchr/testify/require"
+)
+
+func newRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	rs := NewRedisStore(Config{Addr: mr.Addr(), PoolSize: 2, TTL: time.Hour, Timeout: 100 * time.Millisecond})
+	t.Cleanup(func() { rs.Close() })
+	return rs, mr
+}
+
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+	rs, mr := newRedisStore(t)
+	date := time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+	id, model := 1, &store.Model{
+		Order_uid:    "b563feb7b2b84b6test",
+		Date_created: &date,
+		Items:        []*store.Item{{Rid: "ab4219087a764ae0btest"}},
+	}
+	require.NoError(t, rs.Ping(ctx))
+
+	// Testing 'Set', expecting the order and its order_uid stored with the TTL
+	require.NoError(t, rs.Set(ctx, &id, model))
+	require.Equal(t, time.Hour, mr.TTL("wbl0:order:1"))
+	require.Equal(t, time.Hour, mr.TTL("wbl0:uid:b563feb7b2b84b6test"))
+
+	// Testing 'Get' and 'GetByUID', expecting the order
+	res, err := rs.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, model, res)
+	res, err = rs.GetByUID(ctx, model.Order_uid)
+	require.NoError(t, err)
+	require.Equal(t, model, res)
+
+	// Testing 'Get' and 'GetByUID', expecting misses
+	_, err = rs.Get(ctx, 2)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrorCacheDown)
+	_, err = rs.GetByUID(ctx, "unknown")
+	require.Error(t, err)
+
+	// Testing 'GetByUID' of an order_uid the order no longer has, expecting a miss
+	changed := &store.Model{Order_uid: "changed"}
+	require.NoError(t, rs.Set(ctx, &id, changed))
+	_, err = rs.GetByUID(ctx, model.Order_uid)
+	require.Error(t, err)
+	require.False(t, mr.Exists("wbl0:uid:b563feb7b2b84b6test"))
+	res, err = rs.GetByUID(ctx, "changed")
+	require.NoError(t, err)
+	require.Equal(t, changed, res)
+
+	// Testing 'Delete', expecting both keys gone
+	require.NoError(t, rs.Delete(ctx, 1))
+	require.False(t, mr.Exists("wbl0:order:1"))
+	require.False(t, mr.Exists("wbl0:uid:changed"))
+	require.NoError(t, rs.Delete(ctx, 1))
+
+	// Testing 'Get' after the TTL, expecting a miss
+	require.NoError(t, rs.Set(ctx, &id, model))
+	mr.FastForward(2 * time.Hour)
+	_, err = rs.Get(ctx, 1)
+	require.Error(t, err)
+}
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	rs, mr := newRedisStore(t)
+	id, newer := 1, &store.Model{Order_uid: "uid1", Locale: "ru"}
+	require.NoError(t, rs.Set(ctx, &id, newer))
+
+	// Testing 'Load', expecting cached orders kept
+	n := rs.Load([]store.Record{{ID: 1, Model: &store.Model{Order_uid: "uid1"}}, {ID: 2, Model: &store.Model{Order_uid: "uid2"}}})
+	require.Equal(t, 1, n)
+	res, err := rs.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, newer, res)
+	res, err = rs.GetByUID(ctx, "uid2")
+	require.NoError(t, err)
+	require.Equal(t, "uid2", res.Order_uid)
+
+	// Testing 'Add', expecting only the order not cached yet added
+	added, err := rs.Add(ctx, 1, &store.Model{Order_uid: "uid1"})
+	require.NoError(t, err)
+	require.False(t, added)
+	added, err = rs.Add(ctx, 3, &store.Model{Order_uid: "uid3"})
+	require.NoError(t, err)
+	require.True(t, added)
+	res, err = rs.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, newer, res)
+	res, err = rs.GetByUID(ctx, "uid3")
+	require.NoError(t, err)
+	require.Equal(t, "uid3", res.Order_uid)
+
+	// Testing 'Swap', expecting the order replaced only while it equals old
+	swapped, err := rs.Swap(ctx, 1, &store.Model{Order_uid: "uid1"}, &store.Model{Order_uid: "uid1"})
+	require.NoError(t, err)
+	require.False(t, swapped)
+	swapped, err = rs.Swap(ctx, 1, newer, &store.Model{Order_uid: "uid1", Locale: "en"})
+	require.NoError(t, err)
+	require.True(t, swapped)
+	res, err = rs.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "en", res.Locale)
+	swapped, err = rs.Swap(ctx, 4, newer, newer)
+	require.NoError(t, err)
+	require.False(t, swapped)
+
+	// Testing 'Swap' changing the order_uid, expecting the old one dropped
+	swapped, err = rs.Swap(ctx, 1, res, &store.Model{Order_uid: "uid4"})
+	require.NoError(t, err)
+	require.True(t, swapped)
+	_, err = rs.GetByUID(ctx, "uid1")
+	require.Error(t, err)
+	require.False(t, mr.Exists("wbl0:uid:uid1"))
+
+	// Testing 'Set' changing the order_uid to one of another order, expecting
+	// the other order's order_uid kept
+	id = 2
+	require.NoError(t, rs.Set(ctx, &id, &store.Model{Order_uid: "uid3"}))
+	id = 3
+	require.NoError(t, rs.Set(ctx, &id, &store.Model{Order_uid: "uid5"}))
+	res, err = rs.GetByUID(ctx, "uid3")
+	require.NoError(t, err)
+	require.Equal(t, "uid3", res.Order_uid)
+	res, err = rs.GetByUID(ctx, "uid5")
+	require.NoError(t, err)
+	require.Equal(t, "uid5", res.Order_uid)
+}
+
+func TestDown(t *testing.T) {
+	ctx := context.Background()
+	rs, mr := newRedisStore(t)
+	rs.downFor = time.Hour
+	id := 1
+	mr.Close()
+
+	// Testing 'Get' with the server down, expecting ErrorCacheDown
+	_, err := rs.Get(ctx, 1)
+	require.ErrorIs(t, err, ErrorCacheDown)
+
+	// Testing calls while down, expecting ErrorCacheDown without a round trip
+	start := time.Now()
+	require.ErrorIs(t, rs.Set(ctx, &id, &store.Model{Order_uid: "uid1"}), ErrorCacheDown)
+	_, err = rs.GetByUID(ctx, "uid1")
+	require.ErrorIs(t, err, ErrorCacheDown)
+	require.ErrorIs(t, rs.Delete(ctx, 1), ErrorCacheDown)
+	require.Zero(t, rs.Load([]store.Record{{ID: 1, Model: &store.Model{}}}))
+	require.Less(t, time.Since(start), 50*time.Millisecond)
+
+	// Testing 'Get' after downFor, expecting the server tried again
+	rs.downUntil = time.Now()
+	_, err = rs.Get(ctx, 1)
+	require.ErrorIs(t, err, ErrorCacheDown)
+	require.Contains(t, err.Error(), "refused")
+}
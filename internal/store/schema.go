@@ -0,0 +1,183 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Problems of a SchemaIssue.
+const (
+	IssueMissing    = "missing"
+	IssueUnexpected = "unexpected"
+	IssueType       = "wrong type"
+	IssueEmpty      = "empty"
+)
+
+// SchemaIssue is a difference between a message and the JSON of Model. Keys
+// are named like the keys of Fields.
+type SchemaIssue struct {
+	Key      string
+	Problem  string
+	Expected string
+	Got      string
+}
+
+// optionalFields may be left empty in a message, the other top-level fields
+// are required by the worker.
+var optionalFields = map[string]bool{"internal_signature": true}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// CheckSchema lists the differences between the JSON message data and the
+// JSON of Model, in the order of the Model fields. It fails if data isn't
+// JSON.
+func CheckSchema(data []byte) ([]SchemaIssue, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	issues := make([]SchemaIssue, 0)
+	checkValue(&issues, "", reflect.TypeOf(Model{}), v, false)
+	return issues, nil
+}
+
+// checkValue compares v with the JSON of type t. Required values may not be
+// empty.
+func checkValue(issues *[]SchemaIssue, key string, t reflect.Type, v interface{}, required bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if v == nil {
+		*issues = append(*issues, SchemaIssue{key, IssueMissing, expected(t), "null"})
+		return
+	}
+	wrongType := func() {
+		*issues = append(*issues, SchemaIssue{key, IssueType, expected(t), describe(v)})
+	}
+	empty := func() {
+		if required {
+			*issues = append(*issues, SchemaIssue{key, IssueEmpty, expected(t), describe(v)})
+		}
+	}
+	switch {
+	case t == timeType:
+		s, ok := v.(string)
+		if !ok {
+			wrongType()
+		} else if _, err := time.Parse(time.RFC3339, s); err != nil {
+			wrongType()
+		}
+	case t.Kind() == reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			wrongType()
+			return
+		}
+		checkObject(issues, key, t, obj)
+	case t.Kind() == reflect.Slice:
+		arr, ok := v.([]interface{})
+		if !ok {
+			wrongType()
+			return
+		}
+		for i, el := range arr {
+			checkValue(issues, fmt.Sprintf("%s[%d]", key, i), t.Elem(), el, false)
+		}
+	case t.Kind() == reflect.String:
+		s, ok := v.(string)
+		if !ok {
+			wrongType()
+		} else if s == "" {
+			empty()
+		}
+	case t.Kind() == reflect.Uint:
+		n, ok := v.(json.Number)
+		if !ok {
+			wrongType()
+			return
+		}
+		u, err := strconv.ParseUint(n.String(), 10, strconv.IntSize)
+		if err != nil {
+			wrongType()
+		} else if u == 0 {
+			empty()
+		}
+	}
+}
+
+// checkObject compares obj with the fields of struct t. Top-level fields are
+// required unless they're optional.
+func checkObject(issues *[]SchemaIssue, prefix string, t reflect.Type, obj map[string]interface{}) {
+	known := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		known[name] = true
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		v, ok := obj[name]
+		if !ok {
+			if prefix != "" || !optionalFields[name] {
+				*issues = append(*issues, SchemaIssue{key, IssueMissing, expected(t.Field(i).Type), ""})
+			}
+			continue
+		}
+		checkValue(issues, key, t.Field(i).Type, v, prefix == "" && !optionalFields[name])
+	}
+	extra := make([]string, 0)
+	for name := range obj {
+		if !known[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		*issues = append(*issues, SchemaIssue{key, IssueUnexpected, "", describe(obj[name])})
+	}
+}
+
+// expected names the JSON expected for t.
+func expected(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return "RFC 3339 date"
+	case t.Kind() == reflect.Struct:
+		return "object"
+	case t.Kind() == reflect.Slice:
+		return "array"
+	case t.Kind() == reflect.Uint:
+		return "unsigned integer"
+	}
+	return t.Kind().String()
+}
+
+// describe shortens the JSON of v for display.
+func describe(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	b, _ := json.Marshal(v)
+	if len(b) > 50 {
+		return string(b[:47]) + "..."
+	}
+	return string(b)
+}
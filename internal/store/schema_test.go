@@ -0,0 +1,60 @@
+package store
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSchema(t *testing.T) {
+	// Testing 'CheckSchema' with a complete order, expecting no issues
+	b, err := os.ReadFile("../../model.json")
+	require.NoError(t, err)
+	issues, err := CheckSchema(b)
+	require.NoError(t, err)
+	require.Empty(t, issues)
+
+	// Testing 'CheckSchema' with a broken order, expecting every issue in
+	// field order
+	issues, err = CheckSchema([]byte(`{
+		"order_uid": "b563feb7b2b84b6test",
+		"track_number": 42,
+		"entry": "",
+		"delivery": {"name": "Test Testov", "phone": "+9720000000", "zip": "2639809", "city": "Kiryat Mozkin",
+			"address": "Ploshad Mira 15", "region": "Kraiot", "email": "test@gmail.com"},
+		"payment": null,
+		"items": [{"chrt_id": -1, "track_number": "WBILMTESTTRACK", "price": 453, "rid": "ab4219087a764ae0btest",
+			"name": "Mascaras", "sale": 30, "size": "0", "total_price": 317, "nm_id": 2389212,
+			"brand": "Vivienne Sabo", "status": 202, "color": "red"}],
+		"locale": "en",
+		"customer_id": "test",
+		"delivery_service": "meest",
+		"shardkey": "9",
+		"sm_id": 0,
+		"date_created": "yesterday",
+		"oof_shard": "1",
+		"comment": "rush"
+	}`))
+	require.NoError(t, err)
+	require.Equal(t, []SchemaIssue{
+		{"track_number", IssueType, "string", "42"},
+		{"entry", IssueEmpty, "string", `""`},
+		{"sm_id", IssueEmpty, "unsigned integer", "0"},
+		{"date_created", IssueType, "RFC 3339 date", `"yesterday"`},
+		{"payment", IssueMissing, "object", "null"},
+		{"items[0].chrt_id", IssueType, "unsigned integer", "-1"},
+		{"items[0].color", IssueUnexpected, "", `"red"`},
+		{"comment", IssueUnexpected, "", `"rush"`},
+	}, issues)
+
+	// Testing 'CheckSchema' with something else than an object, expecting
+	// one issue
+	issues, err = CheckSchema([]byte(`[1, 2]`))
+	require.NoError(t, err)
+	require.Equal(t, []SchemaIssue{{"", IssueType, "object", "array"}}, issues)
+
+	// Testing 'CheckSchema', expecting error on bad JSON
+	_, err = CheckSchema([]byte(`{"order_uid":`))
+	require.Error(t, err)
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// SearchFields are the fields a SearchIface can look orders up by. "rid"
+// matches the rid of any item.
+var SearchFields = []string{"order_uid", "track_number", "customer_id", "rid"}
+
+// SearchIface is a cache that indexes the SearchFields of its orders.
+type SearchIface interface {
+	Search(ctx context.Context, field, value string) ([]Record, error)
+}
+
+// SearchValues returns the values of field in m, which are indexed to find
+// m by field. It errors if field isn't one of SearchFields.
+func SearchValues(m *Model, field string) ([]string, error) {
+	switch field {
+	case "order_uid":
+		return []string{m.Order_uid}, nil
+	case "track_number":
+		return []string{m.Track_number}, nil
+	case "customer_id":
+		return []string{m.Customer_id}, nil
+	case "rid":
+		rids := make([]string, 0, len(m.Items))
+		for _, item := range m.Items {
+			if item != nil {
+				rids = append(rids, item.Rid)
+			}
+		}
+		return rids, nil
+	}
+	return nil, fmt.Errorf("error: can't search by '%s'", field)
+}
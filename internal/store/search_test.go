@@ -0,0 +1,33 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchValues(t *testing.T) {
+	m := &Model{
+		Order_uid:    "uid",
+		Track_number: "WBILMTESTTRACK",
+		Customer_id:  "test",
+		Items:        []*Item{{Rid: "rid1"}, nil, {Rid: "rid2"}},
+	}
+
+	// Testing 'SearchValues', expecting the value of every search field
+	for field, values := range map[string][]string{
+		"order_uid":    {"uid"},
+		"track_number": {"WBILMTESTTRACK"},
+		"customer_id":  {"test"},
+		"rid":          {"rid1", "rid2"},
+	} {
+		res, err := SearchValues(m, field)
+		require.NoError(t, err)
+		require.Equal(t, values, res)
+	}
+	require.Len(t, SearchFields, 4)
+
+	// Testing 'SearchValues', expecting error on an unknown field
+	_, err := SearchValues(m, "locale")
+	require.Error(t, err)
+}
@@ -0,0 +1,278 @@
+// Package sharded is a store.CacheIface split into independently locked
+// segments, so that writes to one segment don't stall reads of the others.
+package sharded
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ineverbee/wbl0/internal/store"
+)
+
+// Route selects the segment an order is kept in. Routes other than RouteID
+// cost an id lookup on every read.
+type Route int
+
+const (
+	// RouteID spreads orders over the segments by id.
+	RouteID Route = iota
+	// RouteShardkey keeps the orders of a shardkey in one segment.
+	RouteShardkey
+	// RouteOofShard keeps the orders of an oof_shard in one segment.
+	RouteOofShard
+)
+
+func ParseRoute(s string) (Route, error) {
+	switch s {
+	case "", "id":
+		return RouteID, nil
+	case "shardkey":
+		return RouteShardkey, nil
+	case "oof_shard":
+		return RouteOofShard, nil
+	}
+	return RouteID, fmt.Errorf("error: unknown shard route '%s'", s)
+}
+
+// idLocks is the number of stripes of ShardedStore.ids.
+const idLocks = 64
+
+type segment struct {
+	sync.RWMutex
+	m map[int]*store.Model
+	// Keeps the locks of neighbouring segments off one cache line.
+	_ [64]byte
+}
+
+// ShardedStore splits the orders into segments that are locked separately.
+// Every segment is a plain map, so the store has no capacity limit; a write
+// only locks the segment of its order, and of the order's previous segment
+// if it moved.
+type ShardedStore struct {
+	segments []*segment
+	route    Route
+	// ids serializes the writes of an id, striped by id, so that finding its
+	// segment and moving it from there is atomic.
+	ids [idLocks]sync.Mutex
+	// uids maps order_uid to id, and with a route other than RouteID routes
+	// maps id to the segment. Both are written once per order and read on
+	// every lookup, which is what sync.Map is good at.
+	uids   sync.Map
+	routes sync.Map
+}
+
+func NewShardedStore(n int, route Route) *ShardedStore {
+	if n < 1 {
+		n = 1
+	}
+	s := &ShardedStore{segments: make([]*segment, n), route: route}
+	for i := range s.segments {
+		s.segments[i] = &segment{m: make(map[int]*store.Model)}
+	}
+	return s
+}
+
+func (s *ShardedStore) Get(ctx context.Context, id int) (*store.Model, error) {
+	if seg, ok := s.find(id); ok {
+		seg.RLock()
+		model, ok := seg.m[id]
+		seg.RUnlock()
+		if ok {
+			return model.Clone(), nil
+		}
+	}
+	return nil, fmt.Errorf("error: no rows with id '%d'", id)
+}
+
+func (s *ShardedStore) GetByUID(ctx context.Context, uid string) (*store.Model, error) {
+	if id, ok := s.uids.Load(uid); ok {
+		if model, err := s.Get(ctx, id.(int)); err == nil {
+			return model, nil
+		}
+	}
+	return nil, fmt.Errorf("error: no rows with order_uid '%s'", uid)
+}
+
+func (s *ShardedStore) Set(ctx context.Context, id *int, model *store.Model) error {
+	s.set(*id, model, true)
+	return nil
+}
+
+// Add caches model unless id is cached already.
+func (s *ShardedStore) Add(ctx context.Context, id int, model *store.Model) (bool, error) {
+	return s.set(id, model, false), nil
+}
+
+// Swap caches model unless the order cached under id is gone or no longer
+// equals old. An order that moves to another segment is deleted instead, to
+// be read through again.
+func (s *ShardedStore) Swap(ctx context.Context, id int, old, model *store.Model) (bool, error) {
+	model = model.Clone()
+	defer s.lock(id)()
+	seg, ok := s.find(id)
+	if !ok {
+		return false, nil
+	}
+	seg.Lock()
+	defer seg.Unlock()
+	cur, ok := seg.m[id]
+	if !ok || len(store.Diff(cur, old)) > 0 {
+		return false, nil
+	}
+	if seg != s.segments[s.index(id, model)] {
+		delete(seg.m, id)
+		s.forget(id, cur)
+		s.routes.Delete(id)
+		return true, nil
+	}
+	seg.m[id] = model
+	if cur.Order_uid != model.Order_uid {
+		s.forget(id, cur)
+		s.uids.Store(model.Order_uid, id)
+	}
+	return true, nil
+}
+
+func (s *ShardedStore) Delete(ctx context.Context, id int) error {
+	defer s.lock(id)()
+	s.delete(id)
+	return nil
+}
+
+func (s *ShardedStore) delete(id int) {
+	seg, ok := s.find(id)
+	if !ok {
+		return
+	}
+	// The uid and the route are dropped under the segment lock, so that a
+	// Set of the same id can't have its own dropped.
+	seg.Lock()
+	defer seg.Unlock()
+	if old, ok := seg.m[id]; ok {
+		delete(seg.m, id)
+		s.forget(id, old)
+		s.routes.Delete(id)
+	}
+}
+
+// Load adds the records that aren't cached yet and returns how many were
+// added. Cached entries are kept, as they may be newer than the records.
+func (s *ShardedStore) Load(records []store.Record) int {
+	n := 0
+	for _, r := range records {
+		if s.set(r.ID, r.Model, false) {
+			n++
+		}
+	}
+	return n
+}
+
+// IDs returns the ids of the cached orders in ascending order.
+func (s *ShardedStore) IDs() []int {
+	ids := make([]int, 0)
+	for _, seg := range s.segments {
+		seg.RLock()
+		for id := range seg.m {
+			ids = append(ids, id)
+		}
+		seg.RUnlock()
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Len returns the number of cached orders.
+func (s *ShardedStore) Len() int {
+	n := 0
+	for _, seg := range s.segments {
+		seg.RLock()
+		n += len(seg.m)
+		seg.RUnlock()
+	}
+	return n
+}
+
+// set caches model unless replace is false and id is cached already. It
+// reports whether model was cached.
+func (s *ShardedStore) set(id int, model *store.Model, replace bool) bool {
+	model = model.Clone()
+	defer s.lock(id)()
+	seg := s.segments[s.index(id, model)]
+	cur, routed := s.find(id)
+	if routed && cur != seg {
+		// The order moved to another shard.
+		if !replace {
+			return false
+		}
+		s.delete(id)
+	}
+	seg.Lock()
+	defer seg.Unlock()
+	old, ok := seg.m[id]
+	if ok && !replace {
+		return false
+	}
+	seg.m[id] = model
+	if s.route != RouteID {
+		if cur, ok := s.routes.Load(id); !ok || cur != seg {
+			s.routes.Store(id, seg)
+		}
+	}
+	if ok && old.Order_uid == model.Order_uid {
+		return true
+	}
+	if ok {
+		s.forget(id, old)
+	}
+	s.uids.Store(model.Order_uid, id)
+	return true
+}
+
+// lock locks the writes of id and returns the unlock.
+func (s *ShardedStore) lock(id int) func() {
+	mu := &s.ids[uint(id)%idLocks]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// forget drops the order_uid of old unless it's taken by another order.
+func (s *ShardedStore) forget(id int, old *store.Model) {
+	if cur, ok := s.uids.Load(old.Order_uid); ok && cur.(int) == id {
+		s.uids.Delete(old.Order_uid)
+	}
+}
+
+// find returns the segment order id is kept in.
+func (s *ShardedStore) find(id int) (*segment, bool) {
+	if s.route == RouteID {
+		return s.segments[s.index(id, nil)], true
+	}
+	seg, ok := s.routes.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return seg.(*segment), true
+}
+
+func (s *ShardedStore) index(id int, model *store.Model) int {
+	n := len(s.segments)
+	switch s.route {
+	case RouteShardkey:
+		return hash(model.Shardkey) % n
+	case RouteOofShard:
+		return hash(model.Oof_shard) % n
+	}
+	return int(uint(id) % uint(n))
+}
+
+// hash is 32-bit FNV-1a.
+func hash(s string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= 16777619
+	}
+	return int(h & 0x7fffffff)
+}
@@ -0,0 +1,265 @@
+package sharded
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/ineverbee/wbl0/internal/store/mapstore"
+	"github.com/stretchr/testify/require"
+)
+
+func model(id int) *store.Model {
+	return &store.Model{
+		Order_uid: fmt.Sprintf("b563feb7b2b84b6test%d", id),
+		Shardkey:  fmt.Sprint(id % 10),
+		Oof_shard: fmt.Sprint(id % 3),
+	}
+}
+
+func TestParseRoute(t *testing.T) {
+	for s, route := range map[string]Route{"": RouteID, "id": RouteID, "shardkey": RouteShardkey, "oof_shard": RouteOofShard} {
+		res, err := ParseRoute(s)
+		require.NoError(t, err)
+		require.Equal(t, route, res)
+	}
+
+	// Testing 'ParseRoute', expecting error on an unknown route
+	_, err := ParseRoute("customer_id")
+	require.Error(t, err)
+}
+
+func TestShardedStore(t *testing.T) {
+	ctx := context.Background()
+	for _, route := range []Route{RouteID, RouteShardkey, RouteOofShard} {
+		s := NewShardedStore(4, route)
+		for id := 1; id <= 20; id++ {
+			id := id
+			require.NoError(t, s.Set(ctx, &id, model(id)))
+		}
+
+		// Testing 'Get' and 'GetByUID', expecting every order
+		for id := 1; id <= 20; id++ {
+			res, err := s.Get(ctx, id)
+			require.NoError(t, err)
+			require.Equal(t, model(id), res)
+			res, err = s.GetByUID(ctx, model(id).Order_uid)
+			require.NoError(t, err)
+			require.Equal(t, model(id), res)
+		}
+		require.Equal(t, 20, s.Len())
+		require.Len(t, s.IDs(), 20)
+		require.Equal(t, 1, s.IDs()[0])
+
+		// Testing 'Get' and 'GetByUID', expecting misses
+		_, err := s.Get(ctx, 21)
+		require.Error(t, err)
+		_, err = s.GetByUID(ctx, "unknown")
+		require.Error(t, err)
+
+		// Testing 'Set' of an order with a new shardkey and order_uid, expecting it moved
+		id, changed := 1, &store.Model{Order_uid: "changed", Shardkey: "7", Oof_shard: "2"}
+		require.NoError(t, s.Set(ctx, &id, changed))
+		res, err := s.Get(ctx, 1)
+		require.NoError(t, err)
+		require.Equal(t, changed, res)
+		_, err = s.GetByUID(ctx, model(1).Order_uid)
+		require.Error(t, err)
+		require.Equal(t, 20, s.Len())
+
+		// Testing 'Delete', expecting the order and its order_uid gone
+		require.NoError(t, s.Delete(ctx, 1))
+		_, err = s.Get(ctx, 1)
+		require.Error(t, err)
+		_, err = s.GetByUID(ctx, "changed")
+		require.Error(t, err)
+		require.NoError(t, s.Delete(ctx, 1))
+	}
+}
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	s := NewShardedStore(4, RouteShardkey)
+	id, newer := 1, &store.Model{Order_uid: model(1).Order_uid, Locale: "ru"}
+	require.NoError(t, s.Set(ctx, &id, newer))
+
+	// Testing 'Load', expecting cached orders kept
+	n := s.Load([]store.Record{{ID: 1, Model: model(1)}, {ID: 2, Model: model(2)}})
+	require.Equal(t, 1, n)
+	res, err := s.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, newer, res)
+	_, err = s.Get(ctx, 2)
+	require.NoError(t, err)
+
+	// Testing 'Add', expecting only the order not cached yet added
+	added, err := s.Add(ctx, 1, model(1))
+	require.NoError(t, err)
+	require.False(t, added)
+	added, err = s.Add(ctx, 3, model(3))
+	require.NoError(t, err)
+	require.True(t, added)
+	res, err = s.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, newer, res)
+	require.Equal(t, 3, s.Len())
+
+	// Testing 'Swap', expecting the order replaced only while it equals old
+	swapped, err := s.Swap(ctx, 1, model(1), model(1))
+	require.NoError(t, err)
+	require.False(t, swapped)
+	changed := &store.Model{Order_uid: "changed", Shardkey: newer.Shardkey}
+	swapped, err = s.Swap(ctx, 1, newer, changed)
+	require.NoError(t, err)
+	require.True(t, swapped)
+	res, err = s.GetByUID(ctx, "changed")
+	require.NoError(t, err)
+	require.Equal(t, changed, res)
+	_, err = s.GetByUID(ctx, newer.Order_uid)
+	require.Error(t, err)
+
+	// Testing 'Swap' to another segment, expecting the order deleted
+	moved := model(1)
+	moved.Shardkey = "moved"
+	require.NotEqual(t, s.index(1, changed), s.index(1, moved))
+	swapped, err = s.Swap(ctx, 1, changed, moved)
+	require.NoError(t, err)
+	require.True(t, swapped)
+	_, err = s.Get(ctx, 1)
+	require.Error(t, err)
+	_, err = s.GetByUID(ctx, "changed")
+	require.Error(t, err)
+}
+
+func TestConcurrent(t *testing.T) {
+	ctx := context.Background()
+	s := NewShardedStore(8, RouteShardkey)
+
+	// Testing concurrent 'Set', 'Get' and 'Delete', expecting no races
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				id := (w*500 + i) % 100
+				switch i % 3 {
+				case 0:
+					s.Set(ctx, &id, model(id))
+				case 1:
+					s.Get(ctx, id)
+					s.GetByUID(ctx, model(id).Order_uid)
+				case 2:
+					s.Delete(ctx, id)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+func TestDeleteRace(t *testing.T) {
+	ctx := context.Background()
+	for _, route := range []Route{RouteID, RouteShardkey} {
+		s := NewShardedStore(4, route)
+
+		// Testing 'Delete' racing a 'Set' of the same id, expecting the order
+		// found by order_uid whenever it's found by id
+		for i := 0; i < 1000; i++ {
+			id := i
+			require.NoError(t, s.Set(ctx, &id, model(id)))
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				s.Delete(ctx, id)
+			}()
+			go func() {
+				defer wg.Done()
+				s.Set(ctx, &id, model(id))
+			}()
+			wg.Wait()
+			if _, err := s.Get(ctx, id); err == nil {
+				_, err = s.GetByUID(ctx, model(id).Order_uid)
+				require.NoError(t, err)
+			}
+		}
+	}
+}
+
+func TestMoveRace(t *testing.T) {
+	ctx := context.Background()
+	s := NewShardedStore(4, RouteShardkey)
+
+	// Testing concurrent 'Set' of an id with different shardkeys, expecting
+	// the order kept in one segment only
+	for i := 0; i < 1000; i++ {
+		id, start := i, make(chan struct{})
+		var wg sync.WaitGroup
+		for k := 0; k < 8; k++ {
+			wg.Add(1)
+			go func(k int) {
+				defer wg.Done()
+				<-start
+				s.Set(ctx, &id, &store.Model{Order_uid: model(id).Order_uid, Shardkey: fmt.Sprint(k)})
+			}(k)
+		}
+		close(start)
+		wg.Wait()
+		copies := 0
+		for _, seg := range s.segments {
+			if _, ok := seg.m[id]; ok {
+				copies++
+			}
+		}
+		require.Equal(t, 1, copies)
+		seg, ok := s.find(id)
+		require.True(t, ok)
+		require.Contains(t, seg.m, id)
+	}
+}
+
+const benchOrders = 10000
+
+// benchmarkMixed reads and writes random orders of c from parallel
+// goroutines, one write per writeEvery operations.
+func benchmarkMixed(b *testing.B, c store.CacheIface, writeEvery int) {
+	ctx := context.Background()
+	models := make([]*store.Model, benchOrders)
+	for id := range models {
+		id := id
+		models[id] = model(id)
+		c.Set(ctx, &id, models[id])
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for i := 0; pb.Next(); i++ {
+			id := r.Intn(benchOrders)
+			if i%writeEvery == 0 {
+				c.Set(ctx, &id, models[id])
+			} else if _, err := c.Get(ctx, id); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkMixed(b *testing.B) {
+	for _, writeEvery := range []int{10, 2} {
+		b.Run(fmt.Sprintf("MapStore/write1of%d", writeEvery), func(b *testing.B) {
+			benchmarkMixed(b, mapstore.NewMapStore(make(map[int]*store.Model)), writeEvery)
+		})
+		for _, n := range []int{16, 64} {
+			b.Run(fmt.Sprintf("ShardedStore%d/write1of%d", n, writeEvery), func(b *testing.B) {
+				benchmarkMixed(b, NewShardedStore(n, RouteID), writeEvery)
+			})
+		}
+		b.Run(fmt.Sprintf("ShardedStore16Shardkey/write1of%d", writeEvery), func(b *testing.B) {
+			benchmarkMixed(b, NewShardedStore(16, RouteShardkey), writeEvery)
+		})
+	}
+}
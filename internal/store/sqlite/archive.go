@@ -0,0 +1,67 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+)
+
+var (
+	ArchiveCandidatesQuery = "SELECT id FROM wb_data WHERE date_created < ? ORDER BY id"
+	ArchiveQuery           = "INSERT INTO order_archive (" + OrderColumns + ",version,nats_seq,archived_at) SELECT " + OrderColumns + ",version,nats_seq,? FROM wb_data WHERE id IN (%s)"
+	ArchiveDeleteQuery     = "DELETE FROM wb_data WHERE id IN (%s)"
+	GetArchivedQuery       = "SELECT " + OrderColumns + " FROM order_archive WHERE id=?"
+)
+
+// Archive moves up to limit orders created before cutoff to order_archive
+// and returns their ids. With dryRun nothing is moved and the ids of all
+// orders that would be archived are returned, limit is ignored.
+func (s *SQLiteStore) Archive(ctx context.Context, cutoff time.Time, limit int, dryRun bool) ([]int, error) {
+	query, args := ArchiveCandidatesQuery, []interface{}{formatDate(&cutoff)}
+	if !dryRun {
+		query, args = query+" LIMIT ?", append(args, limit)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	ids, idArgs := make([]int, 0), make([]interface{}, 0)
+	for rows.Next() {
+		id := 0
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids, idArgs = append(ids, id), append(idArgs, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if dryRun || len(ids) == 0 {
+		return ids, nil
+	}
+
+	in := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf(ArchiveQuery, in), append([]interface{}{now()}, idArgs...)...); err != nil {
+		return nil, err
+	}
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf(ArchiveDeleteQuery, in), idArgs...); err != nil {
+		return nil, err
+	}
+	return ids, tx.Commit()
+}
+
+// GetArchived returns an order from order_archive.
+func (s *SQLiteStore) GetArchived(ctx context.Context, id int) (*store.Model, error) {
+	return get(ctx, s.db, GetArchivedQuery, id)
+}
@@ -0,0 +1,44 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	s := open(t)
+	ctx := context.Background()
+	withOrders(t, s, 5)
+	cutoff := exampleDate.AddDate(0, 0, 3)
+
+	// Testing 'Archive' in dry-run mode, expecting nothing moved
+	ids, err := s.Archive(ctx, cutoff, 1, true)
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2, 3}, ids)
+	_, err = s.Get(ctx, 1)
+	require.NoError(t, err)
+
+	// Testing 'Archive', expecting up to limit orders moved
+	ids, err = s.Archive(ctx, cutoff, 2, false)
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2}, ids)
+	_, err = s.Get(ctx, 1)
+	require.ErrorIs(t, err, Error404NotFound)
+
+	// Testing 'GetArchived', expecting the moved order
+	res, err := s.GetArchived(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "uid0", res.Order_uid)
+	_, err = s.GetArchived(ctx, 3)
+	require.ErrorIs(t, err, Error404NotFound)
+
+	// Testing 'Archive', expecting the rest and then nothing
+	ids, err = s.Archive(ctx, cutoff, 2, false)
+	require.NoError(t, err)
+	require.Equal(t, []int{3}, ids)
+	ids, err = s.Archive(ctx, cutoff, 2, false)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+}
@@ -0,0 +1,45 @@
+package sqlite
+
+import (
+	"context"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+)
+
+var (
+	ChangedQuery  = "SELECT " + OrderColumns + " FROM wb_data WHERE id <= ? AND id IN (SELECT order_id FROM order_history WHERE replaced_at >= ?) ORDER BY id"
+	ArchivedQuery = "SELECT id FROM order_archive WHERE archived_at >= ? AND id <= ? ORDER BY id"
+)
+
+// Now returns the current time of the database, which is the time of the
+// process, as the store writes replaced_at and archived_at itself.
+func (s *SQLiteStore) Now(ctx context.Context) (time.Time, error) {
+	return time.Now().UTC(), nil
+}
+
+// Changed returns the orders with ids up to upTo that were replaced since,
+// and the ids up to upTo of the orders archived since.
+func (s *SQLiteStore) Changed(ctx context.Context, since time.Time, upTo int) ([]store.Record, []int, error) {
+	records, err := selectOrders(ctx, s.db, ChangedQuery, upTo, formatDate(&since))
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, ArchivedQuery, formatDate(&since), upTo)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	archived := make([]int, 0)
+	for rows.Next() {
+		id := 0
+		if err = rows.Scan(&id); err != nil {
+			return nil, nil, err
+		}
+		archived = append(archived, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return records, archived, nil
+}
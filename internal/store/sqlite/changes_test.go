@@ -0,0 +1,46 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChanged(t *testing.T) {
+	s := open(t)
+	ctx := context.Background()
+	withOrders(t, s, 5)
+	update := func(i int, locale string) {
+		m := *exampleModel
+		date := exampleDate.AddDate(0, 0, i)
+		m.Order_uid, m.Date_created, m.Locale = fmt.Sprintf("uid%d", i), &date, locale
+		id := -1
+		require.NoError(t, s.Set(ctx, &id, &m))
+	}
+	update(2, "fr")
+	since, err := s.Now(ctx)
+	require.NoError(t, err)
+	update(1, "de")
+	update(4, "de")
+	_, err = s.Archive(ctx, exampleDate.AddDate(0, 0, 1), 10, false)
+	require.NoError(t, err)
+
+	// Testing 'Changed', expecting the orders up to upTo replaced since and
+	// the ids of the ones archived since
+	records, archived, err := s.Changed(ctx, since, 4)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, 2, records[0].ID)
+	require.Equal(t, "de", records[0].Model.Locale)
+	require.Equal(t, []int{1}, archived)
+
+	// Testing 'Changed' since now, expecting nothing
+	since, err = s.Now(ctx)
+	require.NoError(t, err)
+	records, archived, err = s.Changed(ctx, since, 5)
+	require.NoError(t, err)
+	require.Empty(t, records)
+	require.Empty(t, archived)
+}
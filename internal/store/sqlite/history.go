@@ -0,0 +1,58 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+)
+
+var (
+	LockQuery          = "SELECT id,version,nats_seq FROM wb_data WHERE order_uid=?"
+	VersionQuery       = "SELECT version,nats_seq FROM wb_data WHERE id=?"
+	InsertHistoryQuery = "INSERT INTO order_history (order_id,version,nats_seq,replaced_at,order_uid,track_number,entry,delivery,payment,items,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
+	HistoryQuery       = "SELECT version,nats_seq,replaced_at,order_id," + OrderColumns[len("id,"):] + " FROM order_history WHERE order_id=? ORDER BY version DESC"
+)
+
+// History returns every stored version of the order with id, newest first.
+// The first one is the current version.
+func (s *SQLiteStore) History(ctx context.Context, id int) ([]store.Version, error) {
+	cur, seq := store.Version{}, sql.NullInt64{}
+	err := s.db.QueryRowContext(ctx, VersionQuery, id).Scan(&cur.Version, &seq)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, Error404NotFound
+		}
+		return nil, err
+	}
+	cur.Nats_seq = uint64(seq.Int64)
+	if cur.Model, err = s.Get(ctx, id); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, HistoryQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []store.Version{cur}
+	for rows.Next() {
+		v, replaced := store.Version{}, ""
+		r, err := scanOrder(rows, &v.Version, &seq, &replaced)
+		if err != nil {
+			return nil, err
+		}
+		t, err := time.Parse(dateLayout, replaced)
+		if err != nil {
+			return nil, err
+		}
+		v.Nats_seq, v.Replaced_at, v.Model = uint64(seq.Int64), &t, r.Model
+		res = append(res, v)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,45 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	s := open(t)
+	ctx := context.Background()
+	id := -1
+	require.NoError(t, s.Set(store.WithSequence(ctx, 1), &id, exampleModel))
+
+	// Testing 'Set' of a redelivered order, expecting no new version
+	require.NoError(t, s.Set(store.WithSequence(ctx, 2), &id, exampleModel))
+	versions, err := s.History(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	require.Equal(t, uint64(1), versions[0].Nats_seq)
+
+	// Testing 'Set' of a changed order, expecting the old version in history
+	changed := *exampleModel
+	changed.Locale = "ru"
+	require.NoError(t, s.Set(store.WithSequence(ctx, 3), &id, &changed))
+	require.Equal(t, 1, id)
+	versions, err = s.History(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, 2, versions[0].Version)
+	require.Equal(t, uint64(3), versions[0].Nats_seq)
+	require.Nil(t, versions[0].Replaced_at)
+	require.Equal(t, &changed, versions[0].Model)
+	require.Equal(t, 1, versions[1].Version)
+	require.Equal(t, uint64(1), versions[1].Nats_seq)
+	require.NotNil(t, versions[1].Replaced_at)
+	require.Equal(t, exampleModel, versions[1].Model)
+
+	// Testing 'History', expecting Error404NotFound error
+	versions, err = s.History(ctx, 2)
+	require.Nil(t, versions)
+	require.ErrorIs(t, err, Error404NotFound)
+}
@@ -0,0 +1,74 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ineverbee/wbl0/internal/store"
+)
+
+const (
+	DefaultListLimit = 20
+	MaxListLimit     = 100
+)
+
+var ListQuery = "SELECT " + OrderColumns + " FROM wb_data%s ORDER BY date_created DESC, id DESC LIMIT %d"
+
+// List returns a page of orders, newest first, using keyset pagination on
+// (date_created, id).
+func (s *SQLiteStore) List(ctx context.Context, f store.ListFilter) (*store.Page, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	} else if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+	where, args := listWhere(f)
+
+	// One extra row tells whether there is a next page.
+	records, err := selectOrders(ctx, s.db, fmt.Sprintf(ListQuery, where, limit+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	page := &store.Page{Records: records}
+	if len(records) > limit {
+		page.Records = records[:limit]
+		last := page.Records[limit-1]
+		page.Next = &store.Cursor{ID: last.ID}
+		if last.Model.Date_created != nil {
+			page.Next.Date_created = *last.Model.Date_created
+		}
+	}
+	return page, nil
+}
+
+func listWhere(f store.ListFilter) (string, []interface{}) {
+	conds, args := make([]string, 0), make([]interface{}, 0)
+	add := func(cond string, vals ...interface{}) {
+		conds = append(conds, cond)
+		args = append(args, vals...)
+	}
+	if f.Customer_id != "" {
+		add("customer_id=?", f.Customer_id)
+	}
+	if f.Delivery_service != "" {
+		add("delivery_service=?", f.Delivery_service)
+	}
+	if f.Locale != "" {
+		add("locale=?", f.Locale)
+	}
+	if f.From != nil {
+		add("date_created>=?", formatDate(f.From))
+	}
+	if f.To != nil {
+		add("date_created<?", formatDate(f.To))
+	}
+	if f.After != nil {
+		add("(date_created, id) < (?, ?)", formatDate(&f.After.Date_created), f.After.ID)
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
@@ -0,0 +1,41 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	s := open(t)
+	ctx := context.Background()
+	withOrders(t, s, 5)
+
+	// Testing 'List', expecting the newest orders and a cursor
+	page, err := s.List(ctx, store.ListFilter{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page.Records, 2)
+	require.Equal(t, 5, page.Records[0].ID)
+	require.Equal(t, 4, page.Records[1].ID)
+	require.Equal(t, &store.Cursor{Date_created: exampleDate.AddDate(0, 0, 3), ID: 4}, page.Next)
+
+	// Testing 'List' after the cursor, expecting the next page
+	page, err = s.List(ctx, store.ListFilter{Limit: 2, After: page.Next})
+	require.NoError(t, err)
+	require.Equal(t, 3, page.Records[0].ID)
+	require.Equal(t, 2, page.Records[1].ID)
+
+	// Testing 'List' with filters, expecting the last page without a cursor
+	from, to := exampleDate, exampleDate.AddDate(0, 0, 2)
+	page, err = s.List(ctx, store.ListFilter{Customer_id: "test", Locale: "en", From: &from, To: &to})
+	require.NoError(t, err)
+	require.Len(t, page.Records, 2)
+	require.Nil(t, page.Next)
+
+	// Testing 'List' with filters, expecting no orders
+	page, err = s.List(ctx, store.ListFilter{Delivery_service: "unknown"})
+	require.NoError(t, err)
+	require.Empty(t, page.Records)
+}
@@ -0,0 +1,92 @@
+package sqlite
+
+import (
+	"context"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+)
+
+var (
+	QuarantineColumns      = "id,data,class,reason,channel,nats_seq,published_at,quarantined_at"
+	QuarantineQuery        = "INSERT INTO quarantine (data,class,reason,channel,nats_seq,published_at,quarantined_at) VALUES (?,?,?,?,?,?,?) ON CONFLICT (channel,nats_seq) DO NOTHING"
+	ListQuarantinedQuery   = "SELECT " + QuarantineColumns + " FROM quarantine ORDER BY id DESC LIMIT ?"
+	GetQuarantinedQuery    = "SELECT " + QuarantineColumns + " FROM quarantine WHERE id=?"
+	UpdateQuarantinedQuery = "UPDATE quarantine SET data=?,class=?,reason=? WHERE id=?"
+	DeleteQuarantinedQuery = "DELETE FROM quarantine WHERE id=?"
+)
+
+// Quarantine keeps a message rejected by the worker, unless it's kept
+// already.
+func (s *SQLiteStore) Quarantine(ctx context.Context, q *store.Quarantined) error {
+	_, err := s.db.ExecContext(ctx, QuarantineQuery,
+		q.Data, q.Class, q.Reason, q.Channel, int64(q.Nats_seq), formatDate(&q.Published_at), now())
+	return err
+}
+
+// ListQuarantined returns up to limit quarantined messages, newest first.
+func (s *SQLiteStore) ListQuarantined(ctx context.Context, limit int) ([]store.Quarantined, error) {
+	return selectQuarantined(ctx, s.db, ListQuarantinedQuery, limit)
+}
+
+func (s *SQLiteStore) GetQuarantined(ctx context.Context, id int) (*store.Quarantined, error) {
+	res, err := selectQuarantined(ctx, s.db, GetQuarantinedQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, Error404NotFound
+	}
+	return &res[0], nil
+}
+
+// UpdateQuarantined replaces the data, class and reason of a quarantined
+// message.
+func (s *SQLiteStore) UpdateQuarantined(ctx context.Context, q *store.Quarantined) error {
+	res, err := s.db.ExecContext(ctx, UpdateQuarantinedQuery, q.Data, q.Class, q.Reason, q.ID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return Error404NotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DeleteQuarantined(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, DeleteQuarantinedQuery, id)
+	return err
+}
+
+func selectQuarantined(ctx context.Context, q querier, query string, args ...interface{}) ([]store.Quarantined, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]store.Quarantined, 0)
+	for rows.Next() {
+		var (
+			m                      store.Quarantined
+			seq                    int64
+			published, quarantined string
+		)
+		err = rows.Scan(&m.ID, &m.Data, &m.Class, &m.Reason, &m.Channel, &seq, &published, &quarantined)
+		if err != nil {
+			return nil, err
+		}
+		m.Nats_seq = uint64(seq)
+		if m.Published_at, err = time.Parse(dateLayout, published); err != nil {
+			return nil, err
+		}
+		if m.Quarantined_at, err = time.Parse(dateLayout, quarantined); err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	return res, rows.Err()
+}
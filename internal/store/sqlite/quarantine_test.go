@@ -0,0 +1,56 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuarantine(t *testing.T) {
+	s := open(t)
+	ctx := context.Background()
+	q := &store.Quarantined{
+		Data:         []byte(`{"order_uid":123}`),
+		Class:        store.ClassDecode,
+		Reason:       "error: bad order_uid",
+		Channel:      "foo",
+		Nats_seq:     7,
+		Published_at: exampleDate,
+	}
+
+	// Testing 'Quarantine' of a redelivered message, expecting it kept once
+	require.NoError(t, s.Quarantine(ctx, q))
+	require.NoError(t, s.Quarantine(ctx, q))
+	next := *q
+	next.Nats_seq = 8
+	require.NoError(t, s.Quarantine(ctx, &next))
+
+	// Testing 'ListQuarantined', expecting the newest first up to limit
+	list, err := s.ListQuarantined(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, uint64(8), list[0].Nats_seq)
+	require.Equal(t, q.Data, list[1].Data)
+	require.Equal(t, exampleDate, list[1].Published_at)
+	require.False(t, list[1].Quarantined_at.IsZero())
+	list, err = s.ListQuarantined(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+
+	// Testing 'UpdateQuarantined' and 'GetQuarantined', expecting the new data
+	res, err := s.GetQuarantined(ctx, 1)
+	require.NoError(t, err)
+	res.Data, res.Class, res.Reason = []byte(`{}`), store.ClassValidation, "error: all fields should be provided"
+	require.NoError(t, s.UpdateQuarantined(ctx, res))
+	updated, err := s.GetQuarantined(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, res, updated)
+
+	// Testing 'DeleteQuarantined', expecting Error404NotFound afterwards
+	require.NoError(t, s.DeleteQuarantined(ctx, 1))
+	_, err = s.GetQuarantined(ctx, 1)
+	require.ErrorIs(t, err, Error404NotFound)
+	require.ErrorIs(t, s.UpdateQuarantined(ctx, res), Error404NotFound)
+}
@@ -0,0 +1,83 @@
+CREATE TABLE IF NOT EXISTS wb_data (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "order_uid" TEXT NOT NULL UNIQUE,
+    "track_number" TEXT,
+    "entry" TEXT,
+    "delivery" TEXT,
+    "payment" TEXT,
+    "items" TEXT,
+    "locale" TEXT,
+    "internal_signature" TEXT,
+    "customer_id" TEXT,
+    "delivery_service" TEXT,
+    "shardkey" TEXT,
+    "sm_id" INTEGER,
+    "date_created" TEXT,
+    "oof_shard" TEXT,
+    "version" INTEGER NOT NULL DEFAULT 1,
+    "nats_seq" INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS wb_data_date_created_id_idx ON wb_data (date_created DESC, id DESC);
+CREATE INDEX IF NOT EXISTS wb_data_customer_id_date_created_idx ON wb_data (customer_id, date_created DESC, id DESC);
+
+CREATE TABLE IF NOT EXISTS order_history (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "order_id" INTEGER NOT NULL,
+    "version" INTEGER NOT NULL,
+    "nats_seq" INTEGER,
+    "replaced_at" TEXT NOT NULL,
+    "order_uid" TEXT NOT NULL,
+    "track_number" TEXT,
+    "entry" TEXT,
+    "delivery" TEXT,
+    "payment" TEXT,
+    "items" TEXT,
+    "locale" TEXT,
+    "internal_signature" TEXT,
+    "customer_id" TEXT,
+    "delivery_service" TEXT,
+    "shardkey" TEXT,
+    "sm_id" INTEGER,
+    "date_created" TEXT,
+    "oof_shard" TEXT,
+    UNIQUE ("order_id", "version")
+);
+
+CREATE INDEX IF NOT EXISTS order_history_replaced_at_idx ON order_history (replaced_at);
+
+CREATE TABLE IF NOT EXISTS order_archive (
+    "id" INTEGER NOT NULL PRIMARY KEY,
+    "order_uid" TEXT NOT NULL,
+    "track_number" TEXT,
+    "entry" TEXT,
+    "delivery" TEXT,
+    "payment" TEXT,
+    "items" TEXT,
+    "locale" TEXT,
+    "internal_signature" TEXT,
+    "customer_id" TEXT,
+    "delivery_service" TEXT,
+    "shardkey" TEXT,
+    "sm_id" INTEGER,
+    "date_created" TEXT,
+    "oof_shard" TEXT,
+    "version" INTEGER NOT NULL DEFAULT 1,
+    "nats_seq" INTEGER,
+    "archived_at" TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS order_archive_order_uid_idx ON order_archive (order_uid);
+CREATE INDEX IF NOT EXISTS order_archive_archived_at_idx ON order_archive (archived_at);
+
+CREATE TABLE IF NOT EXISTS quarantine (
+    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "data" BLOB NOT NULL,
+    "class" TEXT NOT NULL,
+    "reason" TEXT NOT NULL,
+    "channel" TEXT NOT NULL,
+    "nats_seq" INTEGER NOT NULL,
+    "published_at" TEXT NOT NULL,
+    "quarantined_at" TEXT NOT NULL,
+    UNIQUE ("channel", "nats_seq")
+);
@@ -0,0 +1,290 @@
+// Package sqlite is a single-file store.DBIface for local runs, demos and
+// tests. Orders are kept in the layout of the Postgres JSON storage mode,
+// with delivery, payment and items as JSON text.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	_ "modernc.org/sqlite"
+)
+
+const DefaultStreamChunk = 1000
+
+// dateLayout keeps dates in UTC with a fixed width, so that they sort as
+// text in date order.
+const dateLayout = "2006-01-02T15:04:05.000000000Z"
+
+var (
+	Error404NotFound = store.Error404NotFound
+
+	OrderColumns  = "id,order_uid,track_number,entry,delivery,payment,items,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard"
+	InsertQuery   = "INSERT INTO wb_data (order_uid,track_number,entry,delivery,payment,items,locale,internal_signature,customer_id,delivery_service,shardkey,sm_id,date_created,oof_shard,nats_seq) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
+	UpdateQuery   = "UPDATE wb_data SET order_uid=?,track_number=?,entry=?,delivery=?,payment=?,items=?,locale=?,internal_signature=?,customer_id=?,delivery_service=?,shardkey=?,sm_id=?,date_created=?,oof_shard=?,nats_seq=?,version=version+1 WHERE id=?"
+	GetQuery      = "SELECT " + OrderColumns + " FROM wb_data WHERE id=?"
+	GetByUIDQuery = "SELECT " + OrderColumns + " FROM wb_data WHERE order_uid=?"
+	StreamQuery   = "SELECT " + OrderColumns + " FROM wb_data WHERE id>? ORDER BY id LIMIT ?"
+
+	//go:embed schema.sql
+	schema string
+)
+
+// querier is the part of *sql.DB shared with *sql.Tx.
+type querier interface {
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}
+
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// Open opens or creates the database file at path and its schema. Use
+// ":memory:" for a database that lives as long as the store.
+func Open(ctx context.Context, path string) (*SQLiteStore, error) {
+	log.Printf("Opening SQLite database %s\n", path)
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite serializes writers anyway, and a single connection keeps a
+	// ":memory:" database alive and shared.
+	db.SetMaxOpenConns(1)
+	if _, err = db.ExecContext(ctx, schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db}, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// Set stores m as a new order or, if an order with the same order_uid
+// exists and differs from m, as its next version. The NATS sequence of the
+// message is taken from ctx, see store.WithSequence.
+func (s *SQLiteStore) Set(ctx context.Context, id *int, m *store.Model) error {
+	args, err := orderArgs(m)
+	if err != nil {
+		return err
+	}
+	seq := sequence(ctx)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	cur, version, curSeq := 0, 0, sql.NullInt64{}
+	err = tx.QueryRowContext(ctx, LockQuery, m.Order_uid).Scan(&cur, &version, &curSeq)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		res, err := tx.ExecContext(ctx, InsertQuery, append(args, seq)...)
+		if err != nil {
+			return err
+		}
+		last, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		*id = int(last)
+		return tx.Commit()
+	case err != nil:
+		return err
+	}
+
+	old, err := get(ctx, tx, GetQuery, cur)
+	if err != nil {
+		return err
+	}
+	*id = cur
+	if len(store.Diff(old, m)) == 0 {
+		return tx.Commit()
+	}
+	oldArgs, err := orderArgs(old)
+	if err != nil {
+		return err
+	}
+	historyArgs := append([]interface{}{cur, version, curSeq, now()}, oldArgs...)
+	if _, err = tx.ExecContext(ctx, InsertHistoryQuery, historyArgs...); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, UpdateQuery, append(args, seq, cur)...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Get(ctx context.Context, id int) (*store.Model, error) {
+	return get(ctx, s.db, GetQuery, id)
+}
+
+func (s *SQLiteStore) GetByUID(ctx context.Context, uid string) (*store.Model, error) {
+	return get(ctx, s.db, GetByUIDQuery, uid)
+}
+
+// Stream calls f with chunks of up to chunk records with an id greater than
+// after in id order. Every chunk is a separate query, so writers aren't
+// blocked between chunks.
+func (s *SQLiteStore) Stream(ctx context.Context, after, chunk int, f func([]store.Record) error) error {
+	if chunk <= 0 {
+		chunk = DefaultStreamChunk
+	}
+	for {
+		records, err := selectOrders(ctx, s.db, StreamQuery, after, chunk)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		if err = f(records); err != nil {
+			return err
+		}
+		after = records[len(records)-1].ID
+	}
+}
+
+func get(ctx context.Context, q querier, query string, arg interface{}) (*store.Model, error) {
+	records, err := selectOrders(ctx, q, query, arg)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, Error404NotFound
+	}
+	return records[0].Model, nil
+}
+
+// selectOrders runs a query for OrderColumns and returns the records in the
+// order of the query.
+func selectOrders(ctx context.Context, q querier, query string, args ...interface{}) ([]store.Record, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := make([]store.Record, 0)
+	for rows.Next() {
+		r, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+type scanner interface {
+	Scan(...interface{}) error
+}
+
+// scanOrder scans OrderColumns, optionally preceded by extra destinations.
+func scanOrder(row scanner, extra ...interface{}) (store.Record, error) {
+	var (
+		r                        = store.Record{Model: new(store.Model)}
+		delivery, payment, items sql.NullString
+		date                     sql.NullString
+		m                        = r.Model
+	)
+	dest := append(extra,
+		&r.ID,
+		&m.Order_uid,
+		&m.Track_number,
+		&m.Entry,
+		&delivery,
+		&payment,
+		&items,
+		&m.Locale,
+		&m.Internal_signature,
+		&m.Customer_id,
+		&m.Delivery_service,
+		&m.Shardkey,
+		&m.Sm_id,
+		&date,
+		&m.Oof_shard,
+	)
+	if err := row.Scan(dest...); err != nil {
+		return r, err
+	}
+	for _, c := range []struct {
+		src sql.NullString
+		dst interface{}
+	}{{delivery, &m.Delivery}, {payment, &m.Payment}, {items, &m.Items}} {
+		if !c.src.Valid {
+			continue
+		}
+		if err := json.Unmarshal([]byte(c.src.String), c.dst); err != nil {
+			return r, err
+		}
+	}
+	if date.Valid {
+		t, err := time.Parse(dateLayout, date.String)
+		if err != nil {
+			return r, err
+		}
+		m.Date_created = &t
+	}
+	return r, nil
+}
+
+// orderArgs returns the values of OrderColumns without id.
+func orderArgs(m *store.Model) ([]interface{}, error) {
+	delivery, err := json.Marshal(m.Delivery)
+	if err != nil {
+		return nil, err
+	}
+	payment, err := json.Marshal(m.Payment)
+	if err != nil {
+		return nil, err
+	}
+	items, err := json.Marshal(m.Items)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{
+		m.Order_uid,
+		m.Track_number,
+		m.Entry,
+		string(delivery),
+		string(payment),
+		string(items),
+		m.Locale,
+		m.Internal_signature,
+		m.Customer_id,
+		m.Delivery_service,
+		m.Shardkey,
+		m.Sm_id,
+		formatDate(m.Date_created),
+		m.Oof_shard,
+	}, nil
+}
+
+func formatDate(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(dateLayout)
+}
+
+func now() string {
+	return time.Now().UTC().Format(dateLayout)
+}
+
+// sequence returns the NATS sequence stored in ctx, or nil if there is none.
+func sequence(ctx context.Context) interface{} {
+	seq, ok := store.SequenceFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return int64(seq)
+}
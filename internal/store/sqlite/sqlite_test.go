@@ -0,0 +1,170 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	exampleDate  = time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+	exampleModel = &store.Model{
+		Order_uid:    "b563feb7b2b84b6test",
+		Track_number: "WBILMTESTTRACK",
+		Entry:        "WBIL",
+		Delivery: &store.Delivery{
+			Name:    "Test Testov",
+			Phone:   "+9720000000",
+			Zip:     "2639809",
+			City:    "Kiryat Mozkin",
+			Address: "Ploshad Mira 15",
+			Region:  "Kraiot",
+			Email:   "test@gmail.com",
+		},
+		Payment: &store.Payment{
+			Transaction:   "b563feb7b2b84b6test",
+			Currency:      "USD",
+			Provider:      "wbpay",
+			Amount:        1817,
+			Payment_dt:    1637907727,
+			Bank:          "alpha",
+			Delivery_cost: 1500,
+			Goods_total:   317,
+		},
+		Items: []*store.Item{
+			{
+				Chrt_id:      9934930,
+				Track_number: "WBILMTESTTRACK",
+				Price:        453,
+				Rid:          "ab4219087a764ae0btest",
+				Name:         "Mascaras",
+				Sale:         30,
+				Size:         "0",
+				Total_price:  317,
+				Nm_id:        2389212,
+				Brand:        "Vivienne Sabo",
+				Status:       202,
+			},
+		},
+		Locale:           "en",
+		Customer_id:      "test",
+		Delivery_service: "meest",
+		Shardkey:         "9",
+		Sm_id:            99,
+		Date_created:     &exampleDate,
+		Oof_shard:        "1",
+	}
+)
+
+func open(t *testing.T) *SQLiteStore {
+	s, err := Open(context.Background(), ":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// withOrders stores n copies of exampleModel with uids "uid<i>", created a
+// day apart starting at exampleDate.
+func withOrders(t *testing.T, s *SQLiteStore, n int) {
+	for i := 0; i < n; i++ {
+		m := *exampleModel
+		date := exampleDate.AddDate(0, 0, i)
+		m.Order_uid, m.Date_created = fmt.Sprintf("uid%d", i), &date
+		id := -1
+		require.NoError(t, s.Set(context.Background(), &id, &m))
+		require.Equal(t, i+1, id)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "wbl0.db")
+
+	// Testing 'Open', expecting the orders to outlive the store
+	s, err := Open(ctx, path)
+	require.NoError(t, err)
+	id := -1
+	require.NoError(t, s.Set(ctx, &id, exampleModel))
+	require.NoError(t, s.Close())
+
+	s, err = Open(ctx, path)
+	require.NoError(t, err)
+	defer s.Close()
+	res, err := s.Get(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, exampleModel, res)
+}
+
+func TestSQLiteStore(t *testing.T) {
+	ctx := context.Background()
+	s := open(t)
+
+	// Testing 'Set', expecting a new id
+	id := -1
+	require.NoError(t, s.Set(ctx, &id, exampleModel))
+	require.Equal(t, 1, id)
+
+	// Testing 'Get', expecting the same model
+	res, err := s.Get(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, exampleModel, res)
+
+	// Testing 'GetByUID', expecting the same model
+	res, err = s.GetByUID(ctx, exampleModel.Order_uid)
+	require.NoError(t, err)
+	require.Equal(t, exampleModel, res)
+
+	// Testing 'Get' and 'GetByUID', expecting Error404NotFound error
+	res, err = s.Get(ctx, 2)
+	require.Nil(t, res)
+	require.ErrorIs(t, err, Error404NotFound)
+	res, err = s.GetByUID(ctx, "unknown")
+	require.Nil(t, res)
+	require.ErrorIs(t, err, Error404NotFound)
+
+	// Testing 'Set' of an order with nil children, expecting them back as nil
+	id = -1
+	require.NoError(t, s.Set(ctx, &id, &store.Model{Order_uid: "no_children"}))
+	res, err = s.Get(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, &store.Model{Order_uid: "no_children"}, res)
+}
+
+func TestStream(t *testing.T) {
+	s := open(t)
+	withOrders(t, s, 5)
+
+	// Testing 'Stream', expecting every order in chunks
+	chunks, ids := 0, make([]int, 0)
+	err := s.Stream(context.Background(), 0, 2, func(r []store.Record) error {
+		chunks++
+		for _, rec := range r {
+			ids = append(ids, rec.ID)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, chunks)
+	require.Equal(t, []int{1, 2, 3, 4, 5}, ids)
+
+	// Testing 'Stream' after an id, expecting only the later orders
+	ids = ids[:0]
+	err = s.Stream(context.Background(), 3, 2, func(r []store.Record) error {
+		for _, rec := range r {
+			ids = append(ids, rec.ID)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int{4, 5}, ids)
+
+	// Testing 'Stream', expecting the callback's error to stop the stream
+	stop := fmt.Errorf("stop")
+	err = s.Stream(context.Background(), 0, 0, func(r []store.Record) error { return stop })
+	require.ErrorIs(t, err, stop)
+}
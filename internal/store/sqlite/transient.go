@@ -0,0 +1,32 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+
+	msqlite "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Transient reports whether err is a failure worth retrying: the database
+// stayed busy or locked by another writer past busy_timeout.
+func Transient(err error) bool {
+	var sqliteErr *msqlite.Error
+	if errors.As(err, &sqliteErr) {
+		code := sqliteErr.Code() & 0xff
+		return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// Refused reports whether err means the database refuses the order for good:
+// it breaks a constraint, or a value doesn't fit its column. Any other
+// failure may go away.
+func Refused(err error) bool {
+	var sqliteErr *msqlite.Error
+	if errors.As(err, &sqliteErr) {
+		code := sqliteErr.Code() & 0xff
+		return code == sqlite3.SQLITE_CONSTRAINT || code == sqlite3.SQLITE_MISMATCH || code == sqlite3.SQLITE_TOOBIG
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "busy.db")
+	open := func() *sql.DB {
+		db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(0)")
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	ctx := context.Background()
+	first, second := open(), open()
+	_, err := first.ExecContext(ctx, "CREATE TABLE t (n INTEGER)")
+	require.NoError(t, err)
+	tx, err := first.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, "INSERT INTO t VALUES (1)")
+	require.NoError(t, err)
+
+	// Testing 'Transient' with a write to a locked database, expecting true
+	_, err = second.ExecContext(ctx, "INSERT INTO t VALUES (2)")
+	require.Error(t, err)
+	require.True(t, Transient(err), err.Error())
+	require.True(t, Transient(context.DeadlineExceeded))
+
+	// Testing 'Transient' with failures that would fail again, expecting false
+	_, err = second.ExecContext(ctx, "INSERT INTO missing VALUES (1)")
+	require.Error(t, err)
+	require.False(t, Transient(err), err.Error())
+	require.False(t, Transient(Error404NotFound))
+	require.False(t, Transient(errors.New("some error")))
+	require.False(t, Transient(nil))
+}
+
+func TestRefused(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "refused.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	_, err = db.ExecContext(ctx, "CREATE TABLE t (n INTEGER NOT NULL UNIQUE)")
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, "INSERT INTO t VALUES (1)")
+	require.NoError(t, err)
+
+	// Testing 'Refused' with constraint failures, expecting true
+	_, err = db.ExecContext(ctx, "INSERT INTO t VALUES (1)")
+	require.Error(t, err)
+	require.True(t, Refused(err), err.Error())
+	_, err = db.ExecContext(ctx, "INSERT INTO t VALUES (NULL)")
+	require.Error(t, err)
+	require.True(t, Refused(err), err.Error())
+
+	// Testing 'Refused' with failures that may go away, expecting false
+	_, err = db.ExecContext(ctx, "INSERT INTO missing VALUES (1)")
+	require.Error(t, err)
+	require.False(t, Refused(err), err.Error())
+	require.False(t, Refused(context.DeadlineExceeded))
+	require.False(t, Refused(errors.New("some error")))
+	require.False(t, Refused(nil))
+}
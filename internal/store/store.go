@@ -1,24 +1,67 @@
 package store
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
 
+// Error404NotFound is returned by DBIface implementations for orders they
+// don't have.
+var Error404NotFound = fmt.Errorf("error: 404 not found")
+
 type DBIface interface {
-	Set(*int, *Model) error
-	Get(int) (*Model, error)
-	GetAll() (map[int]*Model, error)
+	Set(context.Context, *int, *Model) error
+	Get(context.Context, int) (*Model, error)
+	GetByUID(context.Context, string) (*Model, error)
+	Stream(context.Context, int, int, func([]Record) error) error
+	List(context.Context, ListFilter) (*Page, error)
+	History(context.Context, int) ([]Version, error)
+	GetArchived(context.Context, int) (*Model, error)
 }
 
 type CacheIface interface {
-	Set(*int, *Model) error
-	Get(int) (*Model, error)
+	Set(context.Context, *int, *Model) error
+	Get(context.Context, int) (*Model, error)
+	GetByUID(context.Context, string) (*Model, error)
+	Delete(context.Context, int) error
+}
+
+// AdderIface is a cache that can cache an order unless one is cached under
+// its id already. Orders read from the db are added, so that they never
+// replace a newer version written meanwhile. Add reports whether model was
+// cached.
+type AdderIface interface {
+	Add(ctx context.Context, id int, model *Model) (bool, error)
+}
+
+// SwapperIface is a cache that can replace an order only while the cached
+// one still equals old, so that an order found stale never replaces a newer
+// version written meanwhile. Swap reports whether model was cached.
+type SwapperIface interface {
+	Swap(ctx context.Context, id int, old, model *Model) (bool, error)
+}
+
+// ChangesIface is a db that can tell which orders changed since a point in
+// its own time, so that a cache snapshot can be brought up to date without
+// reading every order.
+type ChangesIface interface {
+	// Now returns the current time of the db.
+	Now(ctx context.Context) (time.Time, error)
+	// Changed returns the orders with ids up to upTo that were replaced
+	// since, and the ids up to upTo of the orders archived since.
+	Changed(ctx context.Context, since time.Time, upTo int) ([]Record, []int, error)
+}
+
+// BatchIface persists models in the background. done is called once per
+// model with its id, or with the error that kept it from being stored.
+type BatchIface interface {
+	Add(ctx context.Context, m *Model, done func(int, error))
 }
 
 type DBMock struct{}
 
-func (dbmock *DBMock) Set(id *int, model *Model) error {
+func (dbmock *DBMock) Set(ctx context.Context, id *int, model *Model) error {
 	*id = 1
 	if model.Order_uid == "very_wrong_uid_for_db" {
 		return fmt.Errorf("error")
@@ -26,30 +69,78 @@ func (dbmock *DBMock) Set(id *int, model *Model) error {
 	return nil
 }
 
-func (dbmock *DBMock) Get(id int) (*Model, error) {
+func (dbmock *DBMock) Get(ctx context.Context, id int) (*Model, error) {
 	return nil, nil
 }
 
-func (dbmock *DBMock) GetAll() (map[int]*Model, error) {
+func (dbmock *DBMock) GetByUID(ctx context.Context, uid string) (*Model, error) {
 	return nil, nil
 }
 
+func (dbmock *DBMock) Stream(ctx context.Context, after, chunk int, f func([]Record) error) error {
+	if after >= 1 {
+		return nil
+	}
+	return f([]Record{{1, &Model{Order_uid: "b563feb7b2b84b6test"}}})
+}
+
+func (dbmock *DBMock) List(ctx context.Context, f ListFilter) (*Page, error) {
+	if f.Customer_id == "very_wrong_customer_for_db" {
+		return nil, fmt.Errorf("error")
+	}
+	date := time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+	page := &Page{Records: []Record{{1, &Model{Order_uid: "b563feb7b2b84b6test", Date_created: &date}}}}
+	if f.After == nil {
+		page.Next = &Cursor{date, 1}
+	}
+	return page, nil
+}
+
+func (dbmock *DBMock) History(ctx context.Context, id int) ([]Version, error) {
+	if id == -10 {
+		return nil, fmt.Errorf("error")
+	}
+	date := time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+	return []Version{
+		{Version: 2, Nats_seq: 7, Model: &Model{Order_uid: "b563feb7b2b84b6test", Locale: "ru", Date_created: &date}},
+		{Version: 1, Nats_seq: 3, Replaced_at: &date, Model: &Model{Order_uid: "b563feb7b2b84b6test", Locale: "en", Date_created: &date}},
+	}, nil
+}
+
+func (dbmock *DBMock) GetArchived(ctx context.Context, id int) (*Model, error) {
+	if id != -20 {
+		return nil, fmt.Errorf("error")
+	}
+	return &Model{Order_uid: "archived_uid"}, nil
+}
+
 type CacheMock struct{}
 
-func (cmock *CacheMock) Set(id *int, model *Model) error {
+func (cmock *CacheMock) Set(ctx context.Context, id *int, model *Model) error {
 	if model.Order_uid == "very_wrong_uid_for_cache" {
 		return fmt.Errorf("error")
 	}
 	return nil
 }
 
-func (cmock *CacheMock) Get(id int) (*Model, error) {
-	if id == -10 {
+func (cmock *CacheMock) Get(ctx context.Context, id int) (*Model, error) {
+	if id == -10 || id == -20 {
 		return nil, fmt.Errorf("error")
 	}
 	return nil, nil
 }
 
+func (cmock *CacheMock) GetByUID(ctx context.Context, uid string) (*Model, error) {
+	if uid == "very_wrong_uid_for_cache" {
+		return nil, fmt.Errorf("error")
+	}
+	return nil, nil
+}
+
+func (cmock *CacheMock) Delete(ctx context.Context, id int) error {
+	return nil
+}
+
 type Delivery struct {
 	Name    string `json:"name" sql:"name"`
 	Phone   string `json:"phone" sql:"phone"`
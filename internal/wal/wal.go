@@ -0,0 +1,571 @@
+// Package wal is a local write-ahead log: records are appended to numbered
+// segment files and read back in order by a single consumer, which commits
+// how far it got so that a restart resumes from there.
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A segment is segmentMagic and the format version as a big-endian uint32,
+// then records: the length and the CRC-32C of the payload as big-endian
+// uint32s, then the payload.
+const (
+	segmentMagic   = "WBL0WAL1"
+	segmentVersion = 1
+	segmentExt     = ".wal"
+	headerSize     = int64(len(segmentMagic) + 4)
+	recordHeader   = 8
+	checkpointName = "checkpoint"
+
+	DefaultSegmentBytes = 64 << 20
+	MaxRecordBytes      = 16 << 20
+)
+
+var (
+	ErrorBadRecord  = errors.New("error: bad wal record")
+	ErrorBadSegment = errors.New("error: bad wal segment")
+	ErrorClosed     = errors.New("error: wal is closed")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// SyncPolicy is when appended records are fsynced.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs every record before Append returns.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs every Config.SyncInterval, see Run. A crash loses
+	// the records of the last interval.
+	SyncInterval
+	// SyncNone leaves it to the OS.
+	SyncNone
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncInterval:
+		return "interval"
+	case SyncNone:
+		return "none"
+	}
+	return "always"
+}
+
+// ParseSyncPolicy parses "always" (the default), "interval" or "none".
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "", "always":
+		return SyncAlways, nil
+	case "interval":
+		return SyncInterval, nil
+	case "none":
+		return SyncNone, nil
+	}
+	return SyncAlways, fmt.Errorf("error: unknown wal sync policy '%s'", s)
+}
+
+type Config struct {
+	Dir string
+	// SegmentBytes is the size after which a new segment is started.
+	SegmentBytes int64
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+}
+
+// Position is the offset of a record in a segment.
+type Position struct {
+	Segment uint64
+	Offset  int64
+}
+
+// Entry is a record and the position right after it.
+type Entry struct {
+	Data []byte
+	Next Position
+}
+
+type Log struct {
+	cfg Config
+
+	mu        sync.Mutex
+	segments  []uint64
+	active    *os.File
+	size      int64
+	dirty     bool
+	committed Position
+	// appended is closed and replaced on every append.
+	appended chan struct{}
+}
+
+// Open opens or creates the log in cfg.Dir. The records after the last bad
+// one of every segment, torn by a crash, are truncated.
+func Open(cfg Config) (*Log, error) {
+	if cfg.SegmentBytes <= 0 {
+		cfg.SegmentBytes = DefaultSegmentBytes
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	l := &Log{cfg: cfg, appended: make(chan struct{})}
+	var err error
+	if l.committed, err = readCheckpoint(filepath.Join(cfg.Dir, checkpointName)); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[WAL] Replaying every segment, bad checkpoint: %s\n", err.Error())
+		}
+		l.committed = Position{}
+	}
+	if l.segments, err = listSegments(cfg.Dir); err != nil {
+		return nil, err
+	}
+	l.removeBefore(l.committed.Segment)
+
+	for i, n := range l.segments {
+		last := i == len(l.segments)-1
+		if err = recoverSegment(l.path(n), last); err != nil {
+			return nil, err
+		}
+	}
+	if len(l.segments) == 0 {
+		err = l.create(l.committed.Segment + 1)
+	} else {
+		err = l.openActive(l.segments[len(l.segments)-1])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) path(n uint64) string {
+	return filepath.Join(l.cfg.Dir, fmt.Sprintf("%020d%s", n, segmentExt))
+}
+
+func listSegments(dir string) ([]uint64, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []uint64
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		res = append(res, n)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res, nil
+}
+
+// recoverSegment truncates the segment at path after its last good record.
+// The header of the last segment is rewritten if a crash tore it.
+func recoverSegment(path string, last bool) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if err = readHeader(r); err != nil {
+		if !last {
+			return fmt.Errorf("%w: %s: %s", ErrorBadSegment, path, err.Error())
+		}
+		log.Printf("[WAL] Rewriting the torn header of %s\n", path)
+		if err = f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err = f.WriteAt(header(), 0); err != nil {
+			return err
+		}
+		return f.Sync()
+	}
+	valid := headerSize
+	for {
+		data, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			log.Printf("[WAL] Truncating %s at %d: %s\n", path, valid, err.Error())
+			if err = f.Truncate(valid); err != nil {
+				return err
+			}
+			return f.Sync()
+		}
+		valid += recordHeader + int64(len(data))
+	}
+}
+
+func header() []byte {
+	buf := make([]byte, headerSize)
+	copy(buf, segmentMagic)
+	binary.BigEndian.PutUint32(buf[len(segmentMagic):], segmentVersion)
+	return buf
+}
+
+func readHeader(r io.Reader) error {
+	buf := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	if !bytes.Equal(buf, header()) {
+		return errors.New("unknown format")
+	}
+	return nil
+}
+
+// readRecord reads the next record of r. It returns io.EOF at the end of the
+// segment and ErrorBadRecord for a torn or corrupt record.
+func readRecord(r io.Reader) ([]byte, error) {
+	var head [recordHeader]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("%w: %s", ErrorBadRecord, err.Error())
+	}
+	length := binary.BigEndian.Uint32(head[:4])
+	if length == 0 || length > MaxRecordBytes {
+		return nil, fmt.Errorf("%w: bad length %d", ErrorBadRecord, length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrorBadRecord, err.Error())
+	}
+	if crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(head[4:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrorBadRecord)
+	}
+	return data, nil
+}
+
+// create starts segment n and makes it the active one.
+func (l *Log) create(n uint64) error {
+	f, err := os.OpenFile(l.path(n), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(header()); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = syncDir(l.cfg.Dir); err != nil {
+		f.Close()
+		return err
+	}
+	l.segments = append(l.segments, n)
+	l.active, l.size = f, headerSize
+	return nil
+}
+
+func (l *Log) openActive(n uint64) error {
+	f, err := os.OpenFile(l.path(n), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.active, l.size = f, info.Size()
+	return nil
+}
+
+// Append writes data as a record, and fsyncs it under SyncAlways. A new
+// segment is started once the active one is full.
+func (l *Log) Append(data []byte) error {
+	if len(data) == 0 || len(data) > MaxRecordBytes {
+		return fmt.Errorf("%w: bad length %d", ErrorBadRecord, len(data))
+	}
+	buf := make([]byte, recordHeader+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.Checksum(data, crcTable))
+	copy(buf[recordHeader:], data)
+
+	defer l.mu.Unlock()
+	l.mu.Lock()
+	if l.active == nil {
+		return ErrorClosed
+	}
+	if l.size > headerSize && l.size+int64(len(buf)) > l.cfg.SegmentBytes {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.active.Write(buf)
+	if err != nil {
+		// Cut a partial record, so that the next one isn't appended to it.
+		if n > 0 {
+			l.active.Truncate(l.size)
+		}
+		return err
+	}
+	l.size += int64(n)
+	if l.cfg.Sync == SyncAlways {
+		if err = l.active.Sync(); err != nil {
+			return err
+		}
+	} else {
+		l.dirty = true
+	}
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return nil
+}
+
+func (l *Log) rotate() error {
+	if err := l.active.Sync(); err != nil {
+		return err
+	}
+	if err := l.active.Close(); err != nil {
+		return err
+	}
+	l.dirty = false
+	return l.create(l.segments[len(l.segments)-1] + 1)
+}
+
+// Sync fsyncs the records appended since the last sync.
+func (l *Log) Sync() error {
+	defer l.mu.Unlock()
+	l.mu.Lock()
+	if l.active == nil || !l.dirty {
+		return nil
+	}
+	l.dirty = false
+	return l.active.Sync()
+}
+
+// Run syncs the log every cfg.SyncInterval until ctx is done, under
+// SyncInterval.
+func (l *Log) Run(ctx context.Context) {
+	if l.cfg.Sync != SyncInterval || l.cfg.SyncInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(l.cfg.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.Sync(); err != nil {
+				log.Printf("[WAL] Sync Error: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+// Close syncs and closes the log.
+func (l *Log) Close() error {
+	defer l.mu.Unlock()
+	l.mu.Lock()
+	if l.active == nil {
+		return nil
+	}
+	err := l.active.Sync()
+	if cerr := l.active.Close(); err == nil {
+		err = cerr
+	}
+	l.active = nil
+	return err
+}
+
+// Committed returns the position the consumer committed last, where it
+// should resume reading.
+func (l *Log) Committed() Position {
+	defer l.mu.Unlock()
+	l.mu.Lock()
+	return l.committed
+}
+
+// Wait blocks until there are records after from, or ctx is done.
+func (l *Log) Wait(ctx context.Context, from Position) error {
+	for {
+		l.mu.Lock()
+		active := l.segments[len(l.segments)-1]
+		more := false
+		if from.Segment < active {
+			more = len(l.segments) > 1 || l.size > headerSize
+		} else if from.Segment == active {
+			more = from.Offset < l.size && l.size > headerSize
+		}
+		appended := l.appended
+		l.mu.Unlock()
+		if more {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-appended:
+		}
+	}
+}
+
+// Read returns up to max records from position from on, and the position to
+// read the next ones from.
+func (l *Log) Read(from Position, max int) ([]Entry, Position, error) {
+	l.mu.Lock()
+	segments := append([]uint64(nil), l.segments...)
+	size := l.size
+	l.mu.Unlock()
+	active := segments[len(segments)-1]
+
+	var res []Entry
+	pos := from
+	for len(res) < max {
+		i := sort.Search(len(segments), func(i int) bool { return segments[i] >= pos.Segment })
+		if i == len(segments) {
+			break
+		}
+		if segments[i] != pos.Segment || pos.Offset < headerSize {
+			pos = Position{segments[i], headerSize}
+		}
+		end := int64(-1)
+		if pos.Segment == active {
+			end = size
+		}
+		entries, done, err := l.readSegment(pos, end, max-len(res))
+		res = append(res, entries...)
+		if len(entries) > 0 {
+			pos = entries[len(entries)-1].Next
+		}
+		if err != nil {
+			return res, pos, err
+		}
+		if !done || pos.Segment == active {
+			break
+		}
+		pos = Position{pos.Segment + 1, headerSize}
+	}
+	return res, pos, nil
+}
+
+// readSegment reads up to max records of a segment from pos on, and up to end
+// if it's not negative. done is true once the segment is read to its end.
+func (l *Log) readSegment(pos Position, end int64, max int) (res []Entry, done bool, err error) {
+	f, err := os.Open(l.path(pos.Segment))
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	if _, err = f.Seek(pos.Offset, io.SeekStart); err != nil {
+		return nil, false, err
+	}
+	r := bufio.NewReader(f)
+	for len(res) < max {
+		if end >= 0 && pos.Offset >= end {
+			return res, true, nil
+		}
+		data, err := readRecord(r)
+		if err == io.EOF {
+			return res, true, nil
+		}
+		if err != nil {
+			return res, false, fmt.Errorf("%s at %d: %w", l.path(pos.Segment), pos.Offset, err)
+		}
+		pos.Offset += recordHeader + int64(len(data))
+		res = append(res, Entry{Data: data, Next: pos})
+	}
+	return res, false, nil
+}
+
+// Commit records that every record before pos was consumed and removes the
+// segments left behind. The checkpoint is replaced atomically, so a crash
+// leaves the previous one, and its records are read again.
+func (l *Log) Commit(pos Position) error {
+	if err := writeCheckpoint(filepath.Join(l.cfg.Dir, checkpointName), pos); err != nil {
+		return err
+	}
+	defer l.mu.Unlock()
+	l.mu.Lock()
+	l.committed = pos
+	l.removeBefore(pos.Segment)
+	return nil
+}
+
+// removeBefore removes the segments before n, except the active one.
+func (l *Log) removeBefore(n uint64) {
+	for len(l.segments) > 1 && l.segments[0] < n {
+		if err := os.Remove(l.path(l.segments[0])); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[WAL] Error: %s\n", err.Error())
+			return
+		}
+		l.segments = l.segments[1:]
+	}
+}
+
+// A checkpoint is the segment and the offset of a position as big-endian
+// uint64s, then their CRC-32C as a big-endian uint32.
+func writeCheckpoint(path string, pos Position) error {
+	buf := make([]byte, 20)
+	binary.BigEndian.PutUint64(buf, pos.Segment)
+	binary.BigEndian.PutUint64(buf[8:], uint64(pos.Offset))
+	binary.BigEndian.PutUint32(buf[16:], crc32.Checksum(buf[:16], crcTable))
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err = tmp.Write(buf); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func readCheckpoint(path string) (Position, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return Position{}, err
+	}
+	if len(buf) != 20 || crc32.Checksum(buf[:16], crcTable) != binary.BigEndian.Uint32(buf[16:]) {
+		return Position{}, errors.New("checksum mismatch")
+	}
+	return Position{binary.BigEndian.Uint64(buf), int64(binary.BigEndian.Uint64(buf[8:]))}, nil
+}
+
+// syncDir makes a creation, rename or removal in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,174 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func records(entries []Entry) []string {
+	res := make([]string, len(entries))
+	for i, e := range entries {
+		res[i] = string(e.Data)
+	}
+	return res
+}
+
+func appendN(t *testing.T, l *Log, from, to int) {
+	for i := from; i < to; i++ {
+		require.NoError(t, l.Append([]byte(fmt.Sprintf("record %d", i))))
+	}
+}
+
+func TestLog(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{Dir: dir, SegmentBytes: 48}
+	l, err := Open(cfg)
+	require.NoError(t, err)
+
+	// Testing 'Append' past SegmentBytes, expecting new segments
+	appendN(t, l, 0, 5)
+	segments, err := listSegments(dir)
+	require.NoError(t, err)
+	require.Len(t, segments, 3)
+
+	// Testing 'Read', expecting the records in order across segments
+	entries, next, err := l.Read(l.Committed(), 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"record 0", "record 1", "record 2"}, records(entries))
+	require.Equal(t, entries[2].Next, next)
+	entries, next, err = l.Read(next, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"record 3", "record 4"}, records(entries))
+
+	// Testing 'Commit', expecting the consumed segments removed
+	require.NoError(t, l.Commit(next))
+	segments, err = listSegments(dir)
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	entries, _, err = l.Read(next, 10)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// Testing 'Open' after 'Close', expecting to resume after the commit
+	appendN(t, l, 5, 7)
+	require.NoError(t, l.Close())
+	require.ErrorIs(t, l.Append([]byte("closed")), ErrorClosed)
+	l, err = Open(cfg)
+	require.NoError(t, err)
+	defer l.Close()
+	require.Equal(t, next, l.Committed())
+	entries, _, err = l.Read(l.Committed(), 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"record 5", "record 6"}, records(entries))
+
+	// Testing 'Append' of an empty record, expecting ErrorBadRecord
+	require.ErrorIs(t, l.Append(nil), ErrorBadRecord)
+}
+
+func TestRecover(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{Dir: dir, Sync: SyncNone}
+	l, err := Open(cfg)
+	require.NoError(t, err)
+	appendN(t, l, 0, 3)
+	require.NoError(t, l.Close())
+	path := l.path(1)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	// Testing 'Open' after a crash tore the last record, expecting it cut and
+	// appends to go on after the good ones
+	require.NoError(t, os.Truncate(path, info.Size()-3))
+	l, err = Open(cfg)
+	require.NoError(t, err)
+	appendN(t, l, 3, 4)
+	entries, _, err := l.Read(l.Committed(), 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"record 0", "record 1", "record 3"}, records(entries))
+	require.NoError(t, l.Close())
+
+	// Testing 'Open' with a corrupt record, expecting the segment cut there
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[entries[0].Next.Offset+recordHeader] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	l, err = Open(cfg)
+	require.NoError(t, err)
+	entries, _, err = l.Read(l.Committed(), 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"record 0"}, records(entries))
+	require.NoError(t, l.Close())
+
+	// Testing 'Open' with a bad checkpoint, expecting every record read again
+	require.NoError(t, l.Commit(entries[0].Next))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, checkpointName), []byte("garbage"), 0o644))
+	l, err = Open(cfg)
+	require.NoError(t, err)
+	defer l.Close()
+	require.Equal(t, Position{}, l.Committed())
+	entries, _, err = l.Read(l.Committed(), 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"record 0"}, records(entries))
+
+	// Testing 'Open' with a torn segment header, expecting it rewritten
+	require.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("%020d.wal", 2)), []byte("WBL"), 0o644))
+	l2, err := Open(cfg)
+	require.NoError(t, err)
+	require.NoError(t, l2.Append([]byte("record 4")))
+	entries, _, err = l2.Read(l2.Committed(), 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"record 0", "record 4"}, records(entries))
+	require.NoError(t, l2.Close())
+}
+
+func TestWait(t *testing.T) {
+	l, err := Open(Config{Dir: t.TempDir(), Sync: SyncInterval, SyncInterval: time.Millisecond})
+	require.NoError(t, err)
+	defer l.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.Run(ctx)
+
+	// Testing 'Wait' on an empty log, expecting it to block until ctx is done
+	short, stop := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer stop()
+	require.ErrorIs(t, l.Wait(short, l.Committed()), context.DeadlineExceeded)
+
+	// Testing 'Wait', expecting it to return once a record is appended
+	waited := make(chan error)
+	go func() { waited <- l.Wait(ctx, l.Committed()) }()
+	appendN(t, l, 0, 1)
+	require.NoError(t, <-waited)
+	entries, next, err := l.Read(l.Committed(), 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// Testing 'Wait' at the end of the log, expecting it to block
+	short, stop = context.WithTimeout(ctx, 10*time.Millisecond)
+	defer stop()
+	require.ErrorIs(t, l.Wait(short, next), context.DeadlineExceeded)
+	require.NoError(t, l.Sync())
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	for s, want := range map[string]SyncPolicy{"": SyncAlways, "always": SyncAlways, "interval": SyncInterval, "none": SyncNone} {
+		got, err := ParseSyncPolicy(s)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+	_, err := ParseSyncPolicy("sometimes")
+	require.Error(t, err)
+
+	// Testing 'String', expecting what ParseSyncPolicy parses
+	for _, p := range []SyncPolicy{SyncAlways, SyncInterval, SyncNone} {
+		got, err := ParseSyncPolicy(p.String())
+		require.NoError(t, err)
+		require.Equal(t, p, got)
+	}
+}
@@ -0,0 +1,41 @@
+package worker
+
+import (
+	"encoding/json"
+	"time"
+
+	stan "github.com/nats-io/stan.go"
+)
+
+// DeadLetter is the envelope of a rejected message on the dead-letter
+// channel. Data is the original message, base64 encoded in JSON.
+type DeadLetter struct {
+	Data         []byte    `json:"data"`
+	Reason       string    `json:"reason"`
+	Class        string    `json:"class"`
+	Channel      string    `json:"channel"`
+	Sequence     uint64    `json:"sequence"`
+	Published_at time.Time `json:"published_at"`
+	Rejected_at  time.Time `json:"rejected_at"`
+}
+
+// DeadLetterTo returns a RejectFunc that publishes the rejected messages to
+// channel. Publish waits for NATS to store the envelope, so a message is
+// only acked once it can be inspected on channel.
+func DeadLetterTo(sc stan.Conn, channel string) RejectFunc {
+	return func(m *stan.Msg, reason error) error {
+		b, err := json.Marshal(DeadLetter{
+			Data:         m.Data,
+			Reason:       reason.Error(),
+			Class:        Classify(reason),
+			Channel:      m.Subject,
+			Sequence:     m.Sequence,
+			Published_at: time.Unix(0, m.Timestamp).UTC(),
+			Rejected_at:  time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+		return sc.Publish(channel, b)
+	}
+}
@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/stan.go"
+	"github.com/nats-io/stan.go/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetterTo(t *testing.T) {
+	published := time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+	msg := &stan.Msg{MsgProto: pb.MsgProto{
+		Subject:   "foo",
+		Sequence:  7,
+		Data:      []byte(`{"order_uid":123}`),
+		Timestamp: published.UnixNano(),
+	}}
+
+	// Testing 'DeadLetterTo', expecting the envelope of the message
+	sc := &StanMock{}
+	before := time.Now()
+	require.NoError(t, DeadLetterTo(sc, "foo.dead")(msg, errors.New("error: bad order")))
+	require.Len(t, sc.published, 1)
+	var dl DeadLetter
+	require.NoError(t, json.Unmarshal(sc.published[0], &dl))
+	require.Equal(t, msg.Data, dl.Data)
+	require.Equal(t, "error: bad order", dl.Reason)
+	require.Equal(t, "", dl.Class)
+	require.Equal(t, "foo", dl.Channel)
+	require.Equal(t, uint64(7), dl.Sequence)
+	require.True(t, published.Equal(dl.Published_at))
+	require.False(t, dl.Rejected_at.Before(before))
+
+	// Testing 'DeadLetterTo', expecting error when publishing fails
+	require.Error(t, DeadLetterTo(sc, "wrong channel")(msg, errors.New("error: bad order")))
+}
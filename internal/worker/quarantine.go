@@ -0,0 +1,24 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	stan "github.com/nats-io/stan.go"
+)
+
+// QuarantineTo returns a RejectFunc that keeps the rejected messages in q,
+// classified, so that they can be fixed and resubmitted.
+func QuarantineTo(q store.QuarantineIface) RejectFunc {
+	return func(m *stan.Msg, reason error) error {
+		return q.Quarantine(context.Background(), &store.Quarantined{
+			Data:         m.Data,
+			Class:        Classify(reason),
+			Reason:       reason.Error(),
+			Channel:      m.Subject,
+			Nats_seq:     m.Sequence,
+			Published_at: time.Unix(0, m.Timestamp).UTC(),
+		})
+	}
+}
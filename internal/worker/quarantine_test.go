@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/nats-io/stan.go"
+	"github.com/nats-io/stan.go/pb"
+	"github.com/stretchr/testify/require"
+)
+
+type QuarantineMock struct {
+	store.QuarantineIface
+	kept []*store.Quarantined
+}
+
+func (qm *QuarantineMock) Quarantine(ctx context.Context, q *store.Quarantined) error {
+	if q.Channel == "wrong channel" {
+		return fmt.Errorf("error")
+	}
+	qm.kept = append(qm.kept, q)
+	return nil
+}
+
+func TestQuarantineTo(t *testing.T) {
+	published := time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)
+	msg := &stan.Msg{MsgProto: pb.MsgProto{
+		Subject:   "foo",
+		Sequence:  7,
+		Data:      []byte(`{"order_uid":123}`),
+		Timestamp: published.UnixNano(),
+	}}
+
+	// Testing 'QuarantineTo' with a rejected message, expecting it kept with
+	// its class
+	qm := &QuarantineMock{}
+	_, reason := decode(log.New(io.Discard, "", 0), msg.Data)
+	require.NoError(t, QuarantineTo(qm)(msg, reason))
+	require.Equal(t, []*store.Quarantined{{
+		Data:         msg.Data,
+		Class:        store.ClassDecode,
+		Reason:       reason.Error(),
+		Channel:      "foo",
+		Nats_seq:     7,
+		Published_at: published,
+	}}, qm.kept)
+
+	// Testing 'QuarantineTo', expecting the error of the store
+	msg.Subject = "wrong channel"
+	require.Error(t, QuarantineTo(qm)(msg, reason))
+}
+
+func TestResubmit(t *testing.T) {
+	ctx := context.Background()
+
+	// Testing 'Resubmit' with each class of bad message, expecting a
+	// RejectError of that class
+	for data, class := range map[string]string{
+		`{"order_uid":`:                      store.ClassJSON,
+		`{"order_uid":123}`:                  store.ClassDecode,
+		`{"none_of_the_fields":"oeshgoseh"}`: store.ClassValidation,
+	} {
+		_, err := Resubmit(ctx, &store.DBMock{}, &store.CacheMock{}, []byte(data))
+		require.Equal(t, class, Classify(err))
+	}
+
+	// Testing 'Resubmit' with a valid message, expecting the stored id
+	data := `{"order_uid":"%s","track_number":"WBILMTESTTRACK","entry":"WBIL",
+	"delivery":{"name":"Test Testov"},"payment":{"transaction":"b563feb7b2b84b6test"},"items":[],
+	"locale":"en","customer_id":"test","delivery_service":"meest","shardkey":"9","sm_id":99,
+	"date_created":"2021-11-26T06:22:19Z","oof_shard":"1"}`
+	id, err := Resubmit(ctx, &store.DBMock{}, &store.CacheMock{}, []byte(fmt.Sprintf(data, "NDW839yHW9h")))
+	require.NoError(t, err)
+	require.Equal(t, 1, id)
+
+	// Testing 'Resubmit', expecting the error of the db, which isn't a
+	// RejectError
+	_, err = Resubmit(ctx, &store.DBMock{}, &store.CacheMock{}, []byte(fmt.Sprintf(data, "very_wrong_uid_for_db")))
+	require.Error(t, err)
+	require.Empty(t, Classify(err))
+
+	// Testing 'Resubmit' with an order that fails to be cached, expecting
+	// the stored id, as the order is in the db
+	id, err = Resubmit(ctx, &store.DBMock{}, &store.CacheMock{}, []byte(fmt.Sprintf(data, "very_wrong_uid_for_cache")))
+	require.NoError(t, err)
+	require.Equal(t, 1, id)
+}
@@ -0,0 +1,195 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/ineverbee/wbl0/internal/retry"
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/ineverbee/wbl0/internal/wal"
+
+	stan "github.com/nats-io/stan.go"
+	"github.com/nats-io/stan.go/pb"
+)
+
+const DefaultDrainChunk = 1000
+
+// walEntry is an order in the WAL with the NATS sequence and publish time,
+// in nanoseconds, of its message.
+type walEntry struct {
+	Seq       uint64       `json:"seq"`
+	Model     *store.Model `json:"model"`
+	Timestamp int64        `json:"timestamp,omitempty"`
+}
+
+type timestampKey struct{}
+
+// withTimestamp returns a copy of ctx carrying the NATS publish time of the
+// message being stored, so that walWriter can keep it.
+func withTimestamp(ctx context.Context, ts int64) context.Context {
+	return context.WithValue(ctx, timestampKey{}, ts)
+}
+
+// WithWAL makes Worker append validated orders to l and ack them once
+// they're in it, instead of writing them to the db. A drainer replays them
+// into the db, chunk orders at a time, for as long as Worker runs.
+func WithWAL(l *wal.Log, chunk int) Option {
+	return func(o *options) {
+		o.wal = l
+		o.drainChunk = chunk
+	}
+}
+
+// appendError is a failure to add an order to the WAL, like a full disk.
+// It's never a refusal of the db, whatever it wraps, so the message is left
+// unacked.
+type appendError struct {
+	err error
+}
+
+func (ae *appendError) Error() string {
+	return "error: wal append: " + ae.err.Error()
+}
+
+func (ae *appendError) Unwrap() error {
+	return ae.err
+}
+
+// walWriter appends every model to l. done gets no id, so the model is only
+// cached once it's replayed. Its errors are *appendError.
+func walWriter(l *wal.Log) writer {
+	return func(ctx context.Context, m *store.Model, done func(int, error)) {
+		seq, _ := store.SequenceFromContext(ctx)
+		ts, _ := ctx.Value(timestampKey{}).(int64)
+		data, err := json.Marshal(walEntry{seq, m, ts})
+		if err == nil {
+			err = l.Append(data)
+		}
+		if err != nil {
+			err = &appendError{err}
+		}
+		done(-1, err)
+	}
+}
+
+// drain replays the orders of l with write until ctx is done, and commits
+// them once they're all stored or rejected. Orders the db refuses for good,
+// see options.permanent, are handed to the RejectFuncs of o as messages of
+// channel. A chunk that fails otherwise is replayed again after a backoff, so
+// an order may be written more than once; that's harmless as writes are
+// upserts by order_uid. Only the last order of every order_uid within a
+// chunk is written, see latest; an order_uid spread over chunks is written
+// once per chunk, in order, so the last one still wins.
+func drain(ctx context.Context, log *log.Logger, l *wal.Log, write writer, cache store.CacheIface, channel string, o *options) {
+	chunk := o.drainChunk
+	if chunk < 1 {
+		chunk = DefaultDrainChunk
+	}
+	pos, failures := l.Committed(), 0
+	for ctx.Err() == nil {
+		if err := l.Wait(ctx, pos); err != nil {
+			return
+		}
+		entries, next, err := l.Read(pos, chunk)
+		if err == nil && len(entries) > 0 {
+			err = replay(ctx, log, write, cache, channel, o, latest(log, entries))
+		}
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			failures++
+			log.Printf("[WAL] Replay Error: %s\n", err.Error())
+			retry.DefaultBackoff.Sleep(ctx, failures)
+			continue
+		}
+		failures = 0
+		if next != pos {
+			if err = l.Commit(next); err != nil {
+				log.Printf("[WAL] Commit Error: %s\n", err.Error())
+			}
+			pos = next
+		}
+		if len(entries) > 0 {
+			log.Printf("[WAL] Replayed %d records\n", len(entries))
+		}
+	}
+}
+
+// latest decodes entries and keeps the last order of every order_uid, in
+// the order of their last entries.
+func latest(log *log.Logger, entries []wal.Entry) []walEntry {
+	decoded := make([]walEntry, 0, len(entries))
+	last := make(map[string]int, len(entries))
+	for _, e := range entries {
+		var we walEntry
+		if err := json.Unmarshal(e.Data, &we); err != nil || we.Model == nil {
+			log.Printf("[WAL] Skipping a record that isn't an order\n")
+			continue
+		}
+		last[we.Model.Order_uid] = len(decoded)
+		decoded = append(decoded, we)
+	}
+	res := make([]walEntry, 0, len(last))
+	for i, we := range decoded {
+		if last[we.Model.Order_uid] == i {
+			res = append(res, we)
+		}
+	}
+	return res
+}
+
+// replay writes and caches entries, and returns the first error of a write
+// worth retrying. Once every other entry is stored, the entries refused for
+// good are rejected, and the first error of a RejectFunc is returned.
+func replay(ctx context.Context, log *log.Logger, write writer, cache store.CacheIface, channel string, o *options, entries []walEntry) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		refused  []walEntry
+		reasons  []error
+	)
+	wg.Add(len(entries))
+	for _, we := range entries {
+		we := we
+		write(store.WithSequence(ctx, we.Seq), we.Model, func(id int, err error) {
+			defer wg.Done()
+			if err != nil {
+				mu.Lock()
+				switch {
+				case o.permanent(err):
+					refused = append(refused, we)
+					reasons = append(reasons, err)
+				case firstErr == nil:
+					firstErr = err
+				}
+				mu.Unlock()
+				return
+			}
+			if id != -1 {
+				if err = cache.Set(ctx, &id, we.Model); err != nil {
+					log.Printf("[WAL] Cache Error: %s\n", err.Error())
+				}
+			}
+		})
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	for i, we := range refused {
+		log.Printf("[WAL] Rejecting order '%s': %s\n", we.Model.Order_uid, reasons[i].Error())
+		data, err := json.Marshal(we.Model)
+		if err != nil {
+			return err
+		}
+		m := &stan.Msg{MsgProto: pb.MsgProto{Sequence: we.Seq, Subject: channel, Timestamp: we.Timestamp, Data: data}}
+		if err = o.rejectTo(m, &RejectError{store.ClassDB, reasons[i]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/ineverbee/wbl0/internal/wal"
+	"github.com/nats-io/stan.go"
+	"github.com/nats-io/stan.go/pb"
+	"github.com/stretchr/testify/require"
+)
+
+var errorRefused = errors.New("error: order refused")
+
+// WriteMock records the orders written, fails the first fails writes, and
+// refuses the orders with order_uid refuse for good.
+type WriteMock struct {
+	sync.Mutex
+	fails   int
+	refuse  string
+	written []walEntry
+	ch      chan struct{}
+}
+
+func (wm *WriteMock) write(ctx context.Context, m *store.Model, done func(int, error)) {
+	wm.Lock()
+	if m.Order_uid == wm.refuse {
+		wm.Unlock()
+		done(-1, errorRefused)
+		return
+	}
+	if wm.fails > 0 {
+		wm.fails--
+		wm.Unlock()
+		done(-1, fmt.Errorf("connection refused"))
+		return
+	}
+	seq, _ := store.SequenceFromContext(ctx)
+	wm.written = append(wm.written, walEntry{Seq: seq, Model: m})
+	id := len(wm.written)
+	wm.Unlock()
+	done(id, nil)
+	wm.ch <- struct{}{}
+}
+
+func TestDrain(t *testing.T) {
+	dir := t.TempDir()
+	l, err := wal.Open(wal.Config{Dir: dir})
+	require.NoError(t, err)
+	defer l.Close()
+
+	// Testing 'walWriter', expecting no id so that nothing is cached yet
+	write := walWriter(l)
+	for i, uid := range []string{"a", "b", "a", "c"} {
+		m := &store.Model{Order_uid: uid, Track_number: fmt.Sprint(i)}
+		write(store.WithSequence(context.Background(), uint64(i+1)), m, func(id int, err error) {
+			require.NoError(t, err)
+			require.Equal(t, -1, id)
+		})
+	}
+
+	// Testing 'drain' with a failing db, expecting the whole chunk replayed
+	// again, with only the last order of every order_uid and its sequence
+	buf := new(bytes.Buffer)
+	wm := &WriteMock{fails: 1, ch: make(chan struct{}, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		drain(ctx, log.New(buf, "", 0), l, wm.write, &store.CacheMock{}, "foo", &options{})
+	}()
+	for i := 0; i < 5; i++ {
+		select {
+		case <-wm.ch:
+		case <-time.After(5 * time.Second):
+			t.Fatal("orders were not replayed")
+		}
+	}
+	require.Eventually(t, func() bool { return l.Committed() != wal.Position{} }, time.Second, time.Millisecond)
+	cancel()
+	<-drained
+	require.Contains(t, buf.String(), "[WAL] Replay Error: connection refused")
+	require.Len(t, wm.written, 5)
+	uids := map[string]walEntry{}
+	for _, we := range wm.written {
+		require.NotEqual(t, "0", we.Model.Track_number)
+		uids[we.Model.Order_uid] = we
+	}
+	require.Len(t, uids, 3)
+	require.Equal(t, "2", uids["a"].Model.Track_number)
+	require.Equal(t, uint64(3), uids["a"].Seq)
+	require.Equal(t, uint64(2), uids["b"].Seq)
+	require.Equal(t, uint64(4), uids["c"].Seq)
+
+	// Testing 'Read' after the commit, expecting nothing left to replay
+	entries, _, err := l.Read(l.Committed(), 10)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestLatest(t *testing.T) {
+	// Testing 'latest' with a record that isn't an order, expecting it skipped
+	buf := new(bytes.Buffer)
+	res := latest(log.New(buf, "", 0), []wal.Entry{
+		{Data: []byte(`{"seq":1,"model":{"order_uid":"a"}}`)},
+		{Data: []byte(`not json`)},
+		{Data: []byte(`{"seq":2}`)},
+	})
+	require.Len(t, res, 1)
+	require.Equal(t, "a", res[0].Model.Order_uid)
+	require.Contains(t, buf.String(), "Skipping a record")
+}
+
+func TestDrainReject(t *testing.T) {
+	l, err := wal.Open(wal.Config{Dir: t.TempDir()})
+	require.NoError(t, err)
+	defer l.Close()
+	write := walWriter(l)
+	for i, uid := range []string{"a", "bad", "c"} {
+		ctx := withTimestamp(store.WithSequence(context.Background(), uint64(i+1)), int64(i+1)*1e9)
+		write(ctx, &store.Model{Order_uid: uid}, func(id int, err error) {
+			require.NoError(t, err)
+		})
+	}
+
+	// Testing 'drain' with an order the db refuses for good, expecting it
+	// rejected as ClassDB with its message, the others written and the
+	// chunk committed
+	buf, am := new(bytes.Buffer), &AckMock{}
+	o := am.options()
+	var msgs []*stan.Msg
+	o.reject = append(o.reject, func(m *stan.Msg, reason error) error {
+		msgs = append(msgs, m)
+		return nil
+	})
+	o.refused = func(err error) bool { return errors.Is(err, errorRefused) }
+	wm := &WriteMock{refuse: "bad", ch: make(chan struct{}, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		drain(ctx, log.New(buf, "", 0), l, wm.write, &store.CacheMock{}, "foo", o)
+	}()
+	require.Eventually(t, func() bool { return l.Committed() != wal.Position{} }, 5*time.Second, time.Millisecond)
+	cancel()
+	<-drained
+	require.NotContains(t, buf.String(), "Replay Error")
+	require.Len(t, wm.written, 2)
+	require.Equal(t, []uint64{2}, am.rejected)
+	require.Equal(t, store.ClassDB, Classify(am.reasons[0]))
+	require.ErrorIs(t, am.reasons[0], errorRefused)
+	require.Len(t, msgs, 1)
+	require.Equal(t, "foo", msgs[0].Subject)
+	require.Equal(t, int64(2e9), msgs[0].Timestamp)
+	require.Contains(t, string(msgs[0].Data), `"order_uid":"bad"`)
+	entries, _, err := l.Read(l.Committed(), 10)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestWALWriterError(t *testing.T) {
+	l, err := wal.Open(wal.Config{Dir: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	data := `{"order_uid":"NDW839yHW9h","track_number":"WBILMTESTTRACK","entry":"WBIL",
+	"delivery":{"name":"Test Testov"},"payment":{"transaction":"b563feb7b2b84b6test"},"items":[],
+	"locale":"en","customer_id":"test","delivery_service":"meest","shardkey":"9","sm_id":99,
+	"date_created":"2021-11-26T06:22:19Z","oof_shard":"1"}`
+	buf, am := new(bytes.Buffer), &AckMock{}
+	o := am.options()
+	o.refused = func(error) bool { return true }
+	f := subHandler(context.Background(), log.New(buf, "", 0), walWriter(l), &store.CacheMock{}, o)
+
+	// Testing 'subHandler' with a WAL that fails to append, expecting the
+	// message left unacked rather than rejected as refused by the db
+	f(&stan.Msg{MsgProto: pb.MsgProto{Sequence: 1, Data: []byte(data)}})
+	require.Contains(t, buf.String(), "wal append")
+	require.Empty(t, am.rejected)
+	require.Empty(t, am.acked)
+}
@@ -2,80 +2,346 @@ package worker
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/ineverbee/wbl0/internal/retry"
 	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/ineverbee/wbl0/internal/wal"
 
 	stan "github.com/nats-io/stan.go"
 )
 
-func subHandler(log *log.Logger, db store.DBIface, cache store.CacheIface) stan.MsgHandler {
-	return func(m *stan.Msg) {
-		d := m.Data
-		if !json.Valid(d) {
-			log.Printf("[WORKER] JSON Validation Error\n")
-			return
-		}
-		id, unmarshData := -1, new(store.Model)
-		decoder := json.NewDecoder(bytes.NewReader(d))
-		err := decoder.Decode(unmarshData)
-		if err != nil {
-			log.Printf("[WORKER] Decode Error: %s\n", err.Error())
-			return
+// writer persists a model and reports the stored id or an error through done.
+type writer func(ctx context.Context, m *store.Model, done func(int, error))
+
+// dbWriter stores every model with its own db.Set call.
+func dbWriter(db store.DBIface) writer {
+	return func(ctx context.Context, m *store.Model, done func(int, error)) {
+		id := -1
+		err := db.Set(ctx, &id, m)
+		done(id, err)
+	}
+}
+
+var ErrorInvalidJSON = errors.New("error: invalid JSON")
+
+// RejectError is why a message can never be stored, with the class of the
+// failure, see store.ClassJSON and the like.
+type RejectError struct {
+	Class string
+	Err   error
+}
+
+func (re *RejectError) Error() string {
+	return re.Err.Error()
+}
+
+func (re *RejectError) Unwrap() error {
+	return re.Err
+}
+
+// Classify returns the class of a RejectError, or "" for other errors.
+func Classify(err error) string {
+	var re *RejectError
+	if errors.As(err, &re) {
+		return re.Class
+	}
+	return ""
+}
+
+// RejectFunc routes a message that can never be stored, for the reason
+// given, before it's acked. The message is left unacked, and so redelivered,
+// if RejectFunc fails.
+type RejectFunc func(m *stan.Msg, reason error) error
+
+// Option configures Worker.
+type Option func(*options)
+
+type options struct {
+	batch   store.BatchIface
+	ackWait time.Duration
+	reject  []RejectFunc
+	ack     func(*stan.Msg) error
+
+	backoff   retry.Backoff
+	breaker   *retry.Breaker
+	transient func(error) bool
+	refused   func(error) bool
+
+	wal        *wal.Log
+	drainChunk int
+}
+
+// WithBatch makes Worker hand validated models to batch instead of writing
+// them one by one.
+func WithBatch(batch store.BatchIface) Option {
+	return func(o *options) {
+		o.batch = batch
+	}
+}
+
+// WithAckWait sets how long NATS waits for the ack of a message before it
+// redelivers the message.
+func WithAckWait(d time.Duration) Option {
+	return func(o *options) {
+		o.ackWait = d
+	}
+}
+
+// WithReject makes Worker hand the messages that fail validation, or that the
+// db refuses for good, to f, after the RejectFuncs given before. They're only
+// logged otherwise.
+func WithReject(f RejectFunc) Option {
+	return func(o *options) {
+		o.reject = append(o.reject, f)
+	}
+}
+
+// WithRetry makes Worker retry the writes that fail with an error transient
+// tells is worth retrying, with backoff. Every write waits for breaker, which
+// transient errors open, so that the worker stops consuming while the db is
+// down. breaker may be nil.
+func WithRetry(backoff retry.Backoff, breaker *retry.Breaker, transient func(error) bool) Option {
+	return func(o *options) {
+		o.backoff = backoff
+		o.breaker = breaker
+		o.transient = transient
+	}
+}
+
+// WithRefused makes Worker reject the messages whose write fails with an
+// error refused tells the db will never accept, like a constraint violation.
+// Other failed writes are left unacked, whatever the error.
+func WithRefused(refused func(error) bool) Option {
+	return func(o *options) {
+		o.refused = refused
+	}
+}
+
+// permanent reports whether err is a write error that the refused func of
+// WithRefused lists as a refusal of the db, so that retrying is pointless.
+// Failures to append to the WAL never are.
+func (o *options) permanent(err error) bool {
+	var ae *appendError
+	return o.refused != nil && !errors.Is(err, context.Canceled) && !errors.As(err, &ae) && o.refused(err)
+}
+
+// rejectTo hands m to the RejectFuncs of o in turn, and stops at the first
+// that fails.
+func (o *options) rejectTo(m *stan.Msg, reason error) error {
+	for _, f := range o.reject {
+		if err := f(m, reason); err != nil {
+			return err
 		}
-		err = validateFields(unmarshData)
-		if err != nil {
-			log.Printf("[WORKER] Field Validation Error: %s\n", err.Error())
-			return
+	}
+	return nil
+}
+
+// retrying wraps write with the retries and the breaker of o. Retries run in
+// their own goroutine, as done may be called by a batch flush that a new
+// write could wait for.
+func retrying(log *log.Logger, write writer, o *options) writer {
+	return func(ctx context.Context, m *store.Model, done func(int, error)) {
+		var attempt func(n int)
+		attempt = func(n int) {
+			if o.breaker != nil {
+				if err := o.breaker.Wait(ctx); err != nil {
+					done(-1, err)
+					return
+				}
+			}
+			write(ctx, m, func(id int, err error) {
+				transient := err != nil && o.transient(err)
+				if o.breaker != nil {
+					switch {
+					case errors.Is(err, context.Canceled):
+						o.breaker.Cancel()
+					case transient:
+						o.breaker.Record(err)
+					default:
+						o.breaker.Record(nil)
+					}
+				}
+				if !transient || n >= o.backoff.Attempts {
+					done(id, err)
+					return
+				}
+				log.Printf("[WORKER] Retry %d/%d after DB Error: %s\n", n, o.backoff.Attempts-1, err.Error())
+				go func() {
+					if !o.backoff.Sleep(ctx, n) {
+						done(id, err)
+						return
+					}
+					attempt(n + 1)
+				}()
+			})
 		}
-		err = db.Set(&id, unmarshData)
+		attempt(1)
+	}
+}
+
+// decode parses and validates a message. Its errors are *RejectError.
+func decode(log *log.Logger, d []byte) (*store.Model, error) {
+	if !json.Valid(d) {
+		log.Printf("[WORKER] JSON Validation Error\n")
+		return nil, &RejectError{store.ClassJSON, ErrorInvalidJSON}
+	}
+	unmarshData := new(store.Model)
+	decoder := json.NewDecoder(bytes.NewReader(d))
+	err := decoder.Decode(unmarshData)
+	if err != nil {
+		log.Printf("[WORKER] Decode Error: %s\n", err.Error())
+		return nil, &RejectError{store.ClassDecode, err}
+	}
+	err = validateFields(unmarshData)
+	if err != nil {
+		log.Printf("[WORKER] Field Validation Error: %s\n", err.Error())
+		return nil, &RejectError{store.ClassValidation, err}
+	}
+	return unmarshData, nil
+}
+
+// process decodes a message, writes its order and caches it. done is called
+// with the id of the order, or with the error that kept it from being stored.
+// The order is stored once it's in the db, so a failure to cache it is only
+// logged; the cache catches up on the next read or reconcile.
+func process(ctx context.Context, log *log.Logger, write writer, cache store.CacheIface, d []byte, done func(int, error)) {
+	unmarshData, err := decode(log, d)
+	if err != nil {
+		done(-1, err)
+		return
+	}
+	write(ctx, unmarshData, func(id int, err error) {
 		if err != nil {
 			log.Printf("[WORKER] DB Error: %s\n", err.Error())
+			done(id, err)
 			return
 		}
 		if id != -1 {
-			err = cache.Set(&id, unmarshData)
-			if err != nil {
+			if err := cache.Set(ctx, &id, unmarshData); err != nil {
 				log.Printf("[WORKER] Cache Error: %s\n", err.Error())
-				return
 			}
 		}
+		done(id, nil)
+	})
+}
+
+// Resubmit runs a message through the pipeline of the worker outside of
+// NATS, like a fixed quarantined message. It returns the id of the stored
+// order.
+func Resubmit(ctx context.Context, db store.DBIface, cache store.CacheIface, d []byte) (int, error) {
+	var (
+		id  int
+		err error
+	)
+	process(ctx, log.Default(), dbWriter(db), cache, d, func(i int, e error) {
+		id, err = i, e
+	})
+	return id, err
+}
+
+// subHandler processes every message with ctx, which is canceled when the
+// worker shuts down. A message is acked once its order is stored in the db,
+// or once it's rejected as invalid. Messages that fail to be stored are left
+// unacked, so that NATS redelivers them, unless the refused func of
+// WithRefused tells the db refused them for good: those are rejected too, as
+// ClassDB.
+func subHandler(ctx context.Context, log *log.Logger, write writer, cache store.CacheIface, o *options) stan.MsgHandler {
+	ack := func(m *stan.Msg) {
+		if err := o.ack(m); err != nil {
+			log.Printf("[WORKER] Ack Error: %s\n", err.Error())
+		}
+	}
+	reject := func(m *stan.Msg, reason error) {
+		if err := o.rejectTo(m, reason); err != nil {
+			log.Printf("[WORKER] Reject Error: %s\n", err.Error())
+			return
+		}
+		ack(m)
+	}
+	return func(m *stan.Msg) {
+		process(withTimestamp(store.WithSequence(ctx, m.Sequence), m.Timestamp), log, write, cache, m.Data, func(id int, err error) {
+			switch {
+			case err == nil:
+				ack(m)
+			case Classify(err) != "":
+				reject(m, err)
+			case o.permanent(err):
+				reject(m, &RejectError{store.ClassDB, err})
+			}
+		})
 	}
 }
 
-func Worker(db store.DBIface, cache store.CacheIface, sc stan.Conn, channel, durable string) error {
+// Worker consumes the channel until ctx is done or the process receives
+// SIGINT. Messages are acked manually, see subHandler. With a WAL, they're
+// acked once appended to it, and drained into the db in the background.
+func Worker(ctx context.Context, db store.DBIface, cache store.CacheIface, sc stan.Conn, channel, durable string, opts ...Option) error {
+	o := &options{ackWait: stan.DefaultAckWait, ack: (*stan.Msg).Ack}
+	for _, opt := range opts {
+		opt(o)
+	}
+	write := dbWriter(db)
+	if o.batch != nil {
+		write = o.batch.Add
+	}
+	if o.transient != nil {
+		write = retrying(log.Default(), write, o)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if o.wal != nil {
+		drained := make(chan struct{})
+		go func() {
+			defer close(drained)
+			drain(ctx, log.Default(), o.wal, write, cache, channel, o)
+		}()
+		defer func() {
+			cancel()
+			<-drained
+			if err := o.wal.Sync(); err != nil {
+				log.Printf("[WAL] Sync Error: %s\n", err.Error())
+			}
+		}()
+		write = walWriter(o.wal)
+	}
+
 	// Subscribe with durable name
-	sub, err := sc.Subscribe(channel, subHandler(log.Default(), db, cache), stan.DurableName(durable))
+	sub, err := sc.Subscribe(channel, subHandler(ctx, log.Default(), write, cache, o),
+		stan.DurableName(durable), stan.SetManualAckMode(), stan.AckWait(o.ackWait))
 
 	if err != nil {
 		log.Printf("[WORKER] Sub Error: %s\n", err.Error())
 		return err
 	}
 
-	log.Printf("Listening on [%s], durable=[%s]\n", channel, durable)
+	log.Printf("Listening on [%s], durable=[%s], ack wait=%s\n", channel, durable, o.ackWait)
 
-	// Wait for a SIGINT (perhaps triggered by user with CTRL-C)
-	// Run cleanup when signal is received
+	// Wait for a SIGINT (perhaps triggered by user with CTRL-C) or for ctx
+	// to be done. Run cleanup when either happens
 	signalChan := make(chan os.Signal, 1)
-	cleanupDone := make(chan bool)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGINT)
-	go func() {
-		for range signalChan {
-			log.Printf("\nReceived an interrupt, unsubscribing and closing connection...\n\n")
-			// Do not unsubscribe a durable on exit, except if asked to.
-			if durable == "" {
-				sub.Unsubscribe()
-			}
-			sc.Close()
-			cleanupDone <- true
-		}
-	}()
-	<-cleanupDone
+	defer signal.Stop(signalChan)
+	select {
+	case <-signalChan:
+		log.Printf("\nReceived an interrupt, unsubscribing and closing connection...\n\n")
+	case <-ctx.Done():
+		log.Printf("\nWorker stopped, unsubscribing and closing connection...\n\n")
+	}
+	// Do not unsubscribe a durable on exit, except if asked to.
+	if durable == "" {
+		sub.Unsubscribe()
+	}
+	sc.Close()
 	return nil
 }
 
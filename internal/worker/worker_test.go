@@ -2,21 +2,35 @@ package worker
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/ineverbee/wbl0/internal/retry"
 	"github.com/ineverbee/wbl0/internal/store"
+	"github.com/ineverbee/wbl0/internal/store/db"
+	"github.com/jackc/pgconn"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/stan.go"
+	"github.com/nats-io/stan.go/pb"
 	"github.com/stretchr/testify/require"
 )
 
-type StanMock struct{}
+type StanMock struct {
+	opts      stan.SubscriptionOptions
+	published [][]byte
+}
 
 func (sm *StanMock) Publish(s string, b []byte) error {
+	if s == "wrong channel" {
+		return fmt.Errorf("error: %s", s)
+	}
+	sm.published = append(sm.published, b)
 	return nil
 }
 
@@ -28,6 +42,9 @@ func (sm *StanMock) Subscribe(subject string, cb stan.MsgHandler, opts ...stan.S
 	if subject == "wrong channel" {
 		return nil, fmt.Errorf("error: %s", subject)
 	}
+	for _, opt := range opts {
+		opt(&sm.opts)
+	}
 	return &SubMock{}, nil
 }
 
@@ -89,8 +106,55 @@ func TestWorker(t *testing.T) {
 		time.Sleep(1 * time.Second)
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
 	}()
-	require.NoError(t, Worker(&store.DBMock{}, &store.CacheMock{}, &StanMock{}, "", ""))
-	require.Error(t, Worker(&store.DBMock{}, &store.CacheMock{}, &StanMock{}, "wrong channel", ""))
+	ctx := context.Background()
+	require.NoError(t, Worker(ctx, &store.DBMock{}, &store.CacheMock{}, &StanMock{}, "", ""))
+	require.Error(t, Worker(ctx, &store.DBMock{}, &store.CacheMock{}, &StanMock{}, "wrong channel", ""))
+
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, Worker(ctx, &store.DBMock{}, &store.CacheMock{}, &StanMock{}, "", "", WithBatch(&BatchMock{})))
+
+	// Testing 'Worker' with an ack wait, expecting a manual ack subscription
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	sc := &StanMock{}
+	require.NoError(t, Worker(ctx, &store.DBMock{}, &store.CacheMock{}, sc, "", "durable", WithAckWait(time.Minute)))
+	require.True(t, sc.opts.ManualAcks)
+	require.Equal(t, time.Minute, sc.opts.AckWait)
+	require.Equal(t, "durable", sc.opts.DurableName)
+}
+
+// AckMock records the sequences of the acked and rejected messages.
+type AckMock struct {
+	mu       sync.Mutex
+	acked    []uint64
+	rejected []uint64
+	reasons  []error
+}
+
+func (am *AckMock) options() *options {
+	return &options{ack: am.ack, reject: []RejectFunc{am.reject}}
+}
+
+func (am *AckMock) ack(m *stan.Msg) error {
+	defer am.mu.Unlock()
+	am.mu.Lock()
+	am.acked = append(am.acked, m.Sequence)
+	if m.Sequence == 0 {
+		return fmt.Errorf("error: ack failed")
+	}
+	return nil
+}
+
+func (am *AckMock) reject(m *stan.Msg, reason error) error {
+	defer am.mu.Unlock()
+	am.mu.Lock()
+	if string(m.Data) == "{}" {
+		return fmt.Errorf("error: reject failed")
+	}
+	am.rejected = append(am.rejected, m.Sequence)
+	am.reasons = append(am.reasons, reason)
+	return nil
 }
 
 func TestSubHandler(t *testing.T) {
@@ -108,28 +172,199 @@ func TestSubHandler(t *testing.T) {
 	"date_created":"2021-11-26T06:22:19Z",
 	"oof_shard":"1"}`
 	tc := []struct {
-		input string
-		err   string
+		input    string
+		err      string
+		rejected bool
 	}{
-		{`{"wrong_json":"oeshgoseh"]}`, "JSON Validation Error"},
-		{`{"none_of_the_fields":"oeshgoseh"}`, "Field Validation Error"},
-		{`{"order_uid":123}`, "Decode Error"},
-		{``, "JSON Validation Error"},
-		{fmt.Sprintf(jsonExample, "NDW839yHW9h", -2935), "Decode Error"},
-		{fmt.Sprintf(jsonExample, "very_wrong_uid_for_db", 2935), "DB Error"},
-		{fmt.Sprintf(jsonExample, "very_wrong_uid_for_cache", 2935), "Cache Error"},
+		{`{"wrong_json":"oeshgoseh"]}`, "JSON Validation Error", true},
+		{`{"none_of_the_fields":"oeshgoseh"}`, "Field Validation Error", true},
+		{`{"order_uid":123}`, "Decode Error", true},
+		{``, "JSON Validation Error", true},
+		{fmt.Sprintf(jsonExample, "NDW839yHW9h", -2935), "Decode Error", true},
+		{fmt.Sprintf(jsonExample, "very_wrong_uid_for_db", 2935), "DB Error", false},
 	}
-	buf := new(bytes.Buffer)
-	f := subHandler(log.New(buf, "", 0), &store.DBMock{}, &store.CacheMock{})
-	msg := &stan.Msg{}
-	for _, c := range tc {
-		msg.Data = []byte(c.input)
+	buf, am := new(bytes.Buffer), &AckMock{}
+	f := subHandler(context.Background(), log.New(buf, "", 0), dbWriter(&store.DBMock{}), &store.CacheMock{}, am.options())
+	for i, c := range tc {
+		// Testing 'subHandler', expecting invalid messages to be rejected
+		// and acked, and failed writes to be left unacked
+		msg := &stan.Msg{MsgProto: pb.MsgProto{Sequence: uint64(i + 1), Data: []byte(c.input)}}
 		f(msg)
 		str, _ := buf.ReadBytes("\n"[0])
 		require.Contains(t, string(str), c.err)
+		if c.rejected {
+			require.Equal(t, msg.Sequence, am.rejected[len(am.rejected)-1])
+			require.Equal(t, msg.Sequence, am.acked[len(am.acked)-1])
+		} else {
+			require.NotContains(t, am.acked, msg.Sequence)
+		}
 	}
-	msg.Data = []byte(fmt.Sprintf(jsonExample, "NDW839yHW9h", 69))
+	require.ErrorIs(t, am.reasons[0], ErrorInvalidJSON)
+
+	// Testing 'subHandler' with a valid message, expecting it to be acked
+	msg := &stan.Msg{MsgProto: pb.MsgProto{Sequence: 42, Data: []byte(fmt.Sprintf(jsonExample, "NDW839yHW9h", 69))}}
 	f(msg)
 	str, _ := buf.ReadBytes("\n"[0])
 	require.Equal(t, string(str), "")
+	require.Equal(t, uint64(42), am.acked[len(am.acked)-1])
+
+	// Testing 'subHandler' with a message that fails to be cached, expecting
+	// it to be acked, as it's stored in the db
+	msg = &stan.Msg{MsgProto: pb.MsgProto{Sequence: 44, Data: []byte(fmt.Sprintf(jsonExample, "very_wrong_uid_for_cache", 2935))}}
+	f(msg)
+	require.Contains(t, buf.String(), "Cache Error")
+	require.Equal(t, uint64(44), am.acked[len(am.acked)-1])
+	msg.Sequence = 42
+
+	// Testing 'subHandler', expecting a message that fails to be rejected
+	// to be left unacked
+	acked := len(am.acked)
+	f(&stan.Msg{MsgProto: pb.MsgProto{Sequence: 43, Data: []byte("{}")}})
+	require.Contains(t, buf.String(), "Reject Error")
+	require.Len(t, am.acked, acked)
+
+	// Testing 'subHandler', expecting a failed ack to be logged
+	msg.Sequence = 0
+	f(msg)
+	require.Contains(t, buf.String(), "Ack Error")
+}
+
+func TestSubHandlerRefused(t *testing.T) {
+	data := `{"order_uid":"NDW839yHW9h","track_number":"WBILMTESTTRACK","entry":"WBIL",
+	"delivery":{"name":"Test Testov"},"payment":{"transaction":"b563feb7b2b84b6test"},"items":[],
+	"locale":"en","customer_id":"test","delivery_service":"meest","shardkey":"9","sm_id":99,
+	"date_created":"2021-11-26T06:22:19Z","oof_shard":"1"}`
+	var writeErr error
+	write := func(ctx context.Context, m *store.Model, done func(int, error)) {
+		done(-1, writeErr)
+	}
+	buf, am := new(bytes.Buffer), &AckMock{}
+	o := am.options()
+	o.refused = db.Refused
+	f := subHandler(context.Background(), log.New(buf, "", 0), write, &store.CacheMock{}, o)
+
+	// Testing 'subHandler' with db errors that aren't refusals, like a
+	// statement timeout or a read-only replica, expecting the messages to be
+	// left unacked
+	for i, err := range []error{
+		&pgconn.PgError{Code: "57014"},
+		&pgconn.PgError{Code: "25006"},
+		errors.New("closed pool"),
+	} {
+		writeErr = err
+		f(&stan.Msg{MsgProto: pb.MsgProto{Sequence: uint64(i + 1), Data: []byte(data)}})
+		require.Contains(t, buf.String(), "DB Error")
+		require.Empty(t, am.rejected)
+		require.Empty(t, am.acked)
+	}
+
+	// Testing 'subHandler' with a constraint violation, expecting the message
+	// to be rejected as ClassDB and acked
+	writeErr = &pgconn.PgError{Code: "23505"}
+	f(&stan.Msg{MsgProto: pb.MsgProto{Sequence: 4, Data: []byte(data)}})
+	require.Equal(t, []uint64{4}, am.rejected)
+	require.Equal(t, []uint64{4}, am.acked)
+	require.Equal(t, store.ClassDB, Classify(am.reasons[0]))
+}
+
+type BatchMock struct {
+	models []*store.Model
+}
+
+func (bm *BatchMock) Add(ctx context.Context, m *store.Model, done func(int, error)) {
+	bm.models = append(bm.models, m)
+	if m.Order_uid == "very_wrong_uid_for_batch" {
+		done(-1, fmt.Errorf("error"))
+		return
+	}
+	done(len(bm.models), nil)
+}
+
+func TestSubHandlerBatch(t *testing.T) {
+	jsonExample := `{"order_uid":"%s","track_number":"WBILMTESTTRACK","entry":"WBIL",
+	"delivery":{"name":"Test Testov"},"payment":{"transaction":"b563feb7b2b84b6test"},"items":[],
+	"locale":"en","customer_id":"test","delivery_service":"meest","shardkey":"9","sm_id":99,
+	"date_created":"2021-11-26T06:22:19Z","oof_shard":"1"}`
+	buf, batch, am := new(bytes.Buffer), &BatchMock{}, &AckMock{}
+	f := subHandler(context.Background(), log.New(buf, "", 0), batch.Add, &store.CacheMock{}, am.options())
+
+	f(&stan.Msg{MsgProto: pb.MsgProto{Sequence: 1, Data: []byte(fmt.Sprintf(jsonExample, "NDW839yHW9h"))}})
+	require.Len(t, batch.models, 1)
+	require.Equal(t, "NDW839yHW9h", batch.models[0].Order_uid)
+	require.Empty(t, buf.String())
+	require.Equal(t, []uint64{1}, am.acked)
+
+	f(&stan.Msg{MsgProto: pb.MsgProto{Sequence: 2, Data: []byte(fmt.Sprintf(jsonExample, "very_wrong_uid_for_batch"))}})
+	require.Len(t, batch.models, 2)
+	require.Contains(t, buf.String(), "DB Error")
+	require.Equal(t, []uint64{1}, am.acked)
+}
+
+func TestRetrying(t *testing.T) {
+	down := errors.New("connection refused")
+	transient := func(err error) bool { return errors.Is(err, down) }
+	failing := func(fails int) (writer, *int) {
+		calls := new(int)
+		return func(ctx context.Context, m *store.Model, done func(int, error)) {
+			*calls++
+			if *calls <= fails {
+				done(-1, down)
+				return
+			}
+			done(*calls, nil)
+		}, calls
+	}
+	result := func(write writer, ctx context.Context) (int, error) {
+		type res struct {
+			id  int
+			err error
+		}
+		ch := make(chan res, 1)
+		write(ctx, &store.Model{}, func(id int, err error) { ch <- res{id, err} })
+		r := <-ch
+		return r.id, r.err
+	}
+	buf := new(bytes.Buffer)
+	o := &options{
+		backoff:   retry.Backoff{Attempts: 3, Base: time.Millisecond, Max: time.Millisecond},
+		transient: transient,
+	}
+
+	// Testing 'retrying' with transient errors, expecting the write retried
+	write, calls := failing(2)
+	id, err := result(retrying(log.New(buf, "", 0), write, o), context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 3, id)
+	require.Equal(t, 3, *calls)
+	require.Contains(t, buf.String(), "Retry 2/2 after DB Error")
+
+	// Testing 'retrying' with more transient errors than attempts, expecting
+	// the last error
+	write, calls = failing(5)
+	_, err = result(retrying(log.New(buf, "", 0), write, o), context.Background())
+	require.ErrorIs(t, err, down)
+	require.Equal(t, 3, *calls)
+
+	// Testing 'retrying' with an error that isn't transient, expecting no retry
+	other := errors.New("some error")
+	calls = new(int)
+	_, err = result(retrying(log.New(buf, "", 0), func(ctx context.Context, m *store.Model, done func(int, error)) {
+		*calls++
+		done(-1, other)
+	}, o), context.Background())
+	require.ErrorIs(t, err, other)
+	require.Equal(t, 1, *calls)
+
+	// Testing 'retrying' with a breaker, expecting it open after the
+	// failures and writes paused until ctx is done
+	o.breaker = retry.NewBreaker("db", 3, time.Hour)
+	write, calls = failing(5)
+	_, err = result(retrying(log.New(buf, "", 0), write, o), context.Background())
+	require.ErrorIs(t, err, down)
+	require.Equal(t, retry.Open, o.breaker.Status().State)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = result(retrying(log.New(buf, "", 0), write, o), ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, 3, *calls)
 }